@@ -1,20 +1,15 @@
 package claim
 
 import (
-	"../common"
-	"../contract"
+	"../dag"
 	"../ethash"
 	"../mtree"
 	"../share"
-	"bufio"
-	"encoding/hex"
+	"../verifier"
+	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/core/types"
-	"io"
-	"log"
 	"math/big"
-	"os"
-	"path/filepath"
 	"sort"
 )
 
@@ -26,163 +21,191 @@ func (c Claim) Less(i, j int) bool {
 	return c[i].Counter().Cmp(c[j].Counter()) == -1
 }
 
-func (c Claim) MinDifficulty() *big.Int {
-	var m *big.Int
-	if len(c) > 0 {
-		m = c[0].ShareDifficulty
-		for _, s := range c {
-			if m.Cmp(s.ShareDifficulty) >= 0 {
-				m = s.ShareDifficulty
-			}
+// sorted returns a copy of the claim ordered by share counter, which is
+// the order of shares in the augmented merkle tree. The claim itself is
+// left untouched so it can be read by other goroutines meanwhile.
+func (c Claim) sorted() Claim {
+	result := make(Claim, len(c))
+	copy(result, c)
+	sort.Sort(result)
+	return result
+}
+
+// Difficulty returns the difficulty the claim is submitted with. The
+// contract checks it against the extra data of the proved share, so it
+// is the difficulty of the extra data, see claimable.
+func (c Claim) Difficulty() *big.Int {
+	for _, s := range c {
+		if d, err := s.ClaimDifficulty(); err == nil {
+			return d
 		}
 	}
-	return m
+	return nil
 }
 
-func processDuringRead(
-	datasetPath string, mt *mtree.DagTree) {
-
-	f, err := os.Open(datasetPath)
-	if err != nil {
-		log.Fatal(err)
-	}
-	r := bufio.NewReader(f)
-	buf := [128]byte{}
-	// ignore first 8 bytes magic number at the beginning
-	// of dataset. See more at https://github.com/ethereum/wiki/wiki/Ethash-DAG-Disk-Storage-Format
-	_, err = io.ReadFull(r, buf[:8])
-	if err != nil {
-		log.Fatal(err)
-	}
-	var i uint32 = 0
-	for {
-		n, err := io.ReadFull(r, buf[:128])
-		if n == 0 {
-			if err == nil {
-				continue
-			}
-			if err == io.EOF {
-				break
-			}
-			log.Fatal(err)
+// claimable splits the claim into the shares worth submitting
+// together and the ones that can't be. Every share of a claim must have
+// extra data for the same difficulty, which only differs when the
+// share difficulty was changed while shares were collected. It keeps
+// the shares of the difficulty d maximizing d times their number.
+// Dropped shares can't go to a later claim because counters of a claim
+// must be greater than those of the previous one.
+func (c Claim) claimable() (kept Claim, dropped Claim) {
+	counts := map[string]int{}
+	difficulties := map[string]*big.Int{}
+	for _, s := range c {
+		if d, err := s.ClaimDifficulty(); err == nil {
+			counts[d.String()]++
+			difficulties[d.String()] = d
 		}
-		if n != 128 {
-			fmt.Println(n)
-			log.Fatal("Malformed dataset")
+	}
+	var best *big.Int
+	bestValue := big.NewInt(0)
+	for key, d := range difficulties {
+		value := new(big.Int).Mul(d, big.NewInt(int64(counts[key])))
+		cmp := value.Cmp(bestValue)
+		// prefer more shares when values are equal
+		if cmp > 0 || (cmp == 0 && best != nil && d.Cmp(best) < 0) {
+			best = d
+			bestValue = value
 		}
-		mt.Insert(common.Word(buf), i)
-		if err != nil && err != io.EOF {
-			log.Fatal(err)
+	}
+	kept, dropped = Claim{}, Claim{}
+	for _, s := range c {
+		if d, err := s.ClaimDifficulty(); err == nil && best != nil && d.Cmp(best) == 0 {
+			kept = append(kept, s)
+		} else {
+			dropped = append(dropped, s)
 		}
-		i++
 	}
+	return kept, dropped
 }
 
-// TODO: remove this
-func (c *Claim) SubmitProof_debug(_client *contract.ContractClient, index int) (*big.Int, error) {
-	sort.Sort(c)
+// ShareIndex returns index of the share the contract asks to prove
+// for the given claim seed.
+func (c Claim) ShareIndex(seed *big.Int) (int, error) {
+	if len(c) == 0 {
+		return 0, errors.New("claim has no shares")
+	}
+	if seed == nil || seed.Sign() < 0 {
+		return 0, fmt.Errorf("invalid claim seed: %v", seed)
+	}
+	index := new(big.Int).Mod(seed, big.NewInt(int64(len(c))))
+	return int(index.Int64()), nil
+}
+
+// proof builds the arguments of VerifyClaim for the share at index and
+// checks them the way the contract does, so a bad proof is never paid
+// for.
+func (c *Claim) proof(index int) (*verifier.Proof, error) {
+	shares := c.sorted()
+	if index < 0 || index >= len(shares) {
+		return nil, Fatal(fmt.Errorf("share index %d out of range, claim has %d shares", index, len(shares)))
+	}
 	amt := mtree.NewAugTree()
 	amt.RegisterIndex(uint32(index))
-	for i, s := range *c {
+	for i, s := range shares {
 		amt.Insert(*s, uint32(i))
 	}
 	amt.Finalize()
-	requestedShare := (*c)[index]
-	rlpHeader, _ := requestedShare.RlpHeaderWithoutNonce()
-	nonce := requestedShare.NonceBig()
-	shareIndex := big.NewInt(int64(index))
-	augCountersBranch := amt.CounterBranchArray()
-	augHashesBranch := amt.HashBranchArray()
+	requestedShare := shares[index]
+	rlpHeader, err := requestedShare.RlpHeaderWithoutNonce()
+	if err != nil {
+		return nil, Fatal(err)
+	}
 
 	eth := ethash.New()
 	indices := eth.GetVerificationIndices(requestedShare)
-	seedHash, err := ethash.GetSeedHash(requestedShare.NumberU64())
+	dc, release, err := dag.MerkleCache(requestedShare.NumberU64())
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	path := filepath.Join(
-		ethash.DefaultDir,
-		fmt.Sprintf("full-R%s-%s", "23", hex.EncodeToString(seedHash[:8])),
-	)
-	mt := mtree.NewDagTree()
-	mt.RegisterIndex(indices...)
-	processDuringRead(path, mt)
-	mt.Finalize()
-	sproof := share.ShareProof{
-		mt.AllDAGElements(),
-		mt.AllBranchesArray(),
-	}
-	dataSetLookup := sproof.DAGElementArray()
-	witnessForLookup := sproof.DAGProofArray()
-	return _client.VerifyClaim_debug(
-		rlpHeader,
-		nonce,
-		shareIndex,
-		dataSetLookup,
-		witnessForLookup,
-		augCountersBranch,
-		augHashesBranch,
-	)
+	defer release()
+	elements, err := dag.Items(requestedShare.NumberU64(), indices)
+	if err != nil {
+		return nil, err
+	}
+	branches, err := dc.AllBranchesArray(indices)
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	root, err := dc.RootHash()
+	if err != nil {
+		return nil, err
+	}
+	sproof := share.ShareProof{elements, branches}
+	proof := &verifier.Proof{
+		RlpHeader:         rlpHeader,
+		Nonce:             requestedShare.NonceBig(),
+		ShareIndex:        big.NewInt(int64(index)),
+		DataSetLookup:     sproof.DAGElementArray(),
+		WitnessForLookup:  sproof.DAGProofArray(),
+		AugCountersBranch: amt.CounterBranchArray(),
+		AugHashesBranch:   amt.HashBranchArray(),
+	}
+	submitted := verifier.SubmittedClaim{
+		NumShares:  big.NewInt(int64(len(shares))),
+		Difficulty: c.Difficulty(),
+		Min:        amt.RootMin(),
+		Max:        amt.RootMax(),
+		AugRoot:    amt.RootHash().Big(),
+	}
+	epoch := verifier.Epoch{
+		Number:                  requestedShare.NumberU64() / 30000,
+		MerkleRoot:              root.Big(),
+		FullSizeIn128Resolution: uint64(dc.Rows()),
+		BranchDepth:             uint64(dc.Depth()),
+	}
+	if err = proof.Verify(submitted, epoch); err != nil {
+		return nil, Fatal(fmt.Errorf("proof of share %d is invalid: %s", index, err))
+	}
+	return proof, nil
 }
 
-// TODO: should break this function into smaller meaningful ones
-func (c *Claim) SubmitProof(_client *contract.ContractClient, index int) (*types.Transaction, error) {
-	sort.Sort(c)
-	amt := mtree.NewAugTree()
-	amt.RegisterIndex(uint32(index))
-	for i, s := range *c {
-		amt.Insert(*s, uint32(i))
+// TODO: remove this
+func (c *Claim) SubmitProof_debug(_client ClaimContract, index int) (*big.Int, error) {
+	proof, err := c.proof(index)
+	if err != nil {
+		return nil, err
 	}
-	amt.Finalize()
-	requestedShare := (*c)[index]
-	rlpHeader, _ := requestedShare.RlpHeaderWithoutNonce()
-	nonce := requestedShare.NonceBig()
-	shareIndex := big.NewInt(int64(index))
-	augCountersBranch := amt.CounterBranchArray()
-	augHashesBranch := amt.HashBranchArray()
+	return _client.VerifyClaim_debug(
+		proof.RlpHeader,
+		proof.Nonce,
+		proof.ShareIndex,
+		proof.DataSetLookup,
+		proof.WitnessForLookup,
+		proof.AugCountersBranch,
+		proof.AugHashesBranch,
+	)
+}
 
-	eth := ethash.New()
-	indices := eth.GetVerificationIndices(requestedShare)
-	seedHash, err := ethash.GetSeedHash(requestedShare.NumberU64())
+func (c *Claim) SubmitProof(_client ClaimContract, index int) (*types.Transaction, error) {
+	proof, err := c.proof(index)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	path := filepath.Join(
-		ethash.DefaultDir,
-		fmt.Sprintf("full-R%s-%s", "23", hex.EncodeToString(seedHash[:8])),
-	)
-	mt := mtree.NewDagTree()
-	mt.RegisterIndex(indices...)
-	processDuringRead(path, mt)
-	mt.Finalize()
-	sproof := share.ShareProof{
-		mt.AllDAGElements(),
-		mt.AllBranchesArray(),
-	}
-	dataSetLookup := sproof.DAGElementArray()
-	witnessForLookup := sproof.DAGProofArray()
 	return _client.VerifyClaim(
-		rlpHeader,
-		nonce,
-		shareIndex,
-		dataSetLookup,
-		witnessForLookup,
-		augCountersBranch,
-		augHashesBranch,
+		proof.RlpHeader,
+		proof.Nonce,
+		proof.ShareIndex,
+		proof.DataSetLookup,
+		proof.WitnessForLookup,
+		proof.AugCountersBranch,
+		proof.AugHashesBranch,
 	)
 }
 
-func (c *Claim) SubmitToContract(_client *contract.ContractClient) (*types.Transaction, error) {
-	sort.Sort(c)
+func (c *Claim) SubmitToContract(_client ClaimContract) (*types.Transaction, error) {
+	shares := c.sorted()
 	amt := mtree.NewAugTree()
-	for i, s := range *c {
+	for i, s := range shares {
 		amt.Insert(*s, uint32(i))
 	}
 	amt.Finalize()
-	fmt.Printf("  Submitting %d shares to contract\n", len(*c))
+	fmt.Printf("  Submitting %d shares to contract\n", len(shares))
 	return _client.SubmitClaim(
-		big.NewInt(int64(len(*c))),
-		c.MinDifficulty(),
+		big.NewInt(int64(len(shares))),
+		c.Difficulty(),
 		amt.RootMin(),
 		amt.RootMax(),
 		amt.RootHash().Big(),
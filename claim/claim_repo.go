@@ -5,99 +5,518 @@ import (
 	"../params"
 	"../share"
 	"../txs"
+	"errors"
 	"fmt"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"math/big"
+	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 )
 
 var DefaultClaimRepo *ClaimRepo
 
+// ClaimContract is the part of the pool contract the claim watcher
+// uses, see contract.ContractClient.
+type ClaimContract interface {
+	SubmitClaim(numShares, difficulty, min, max, augMerkle *big.Int) (*types.Transaction, error)
+	VerifyClaim(rlpHeader []byte, nonce, shareIndex *big.Int,
+		dataSetLookup, witnessForLookup, augCountersBranch, augHashesBranch []*big.Int) (*types.Transaction, error)
+	VerifyClaim_debug(rlpHeader []byte, nonce, shareIndex *big.Int,
+		dataSetLookup, witnessForLookup, augCountersBranch, augHashesBranch []*big.Int) (*big.Int, error)
+	GetClaimSeed() (*big.Int, error)
+	ReplaceTransaction(h common.Hash) (*types.Transaction, error)
+}
+
+// ClaimRepo is safe for concurrent use. Shares are added from rpc
+// handlers while the claim watcher seals and submits claims.
 type ClaimRepo struct {
-	claims         map[int]Claim
+	// protects claims, cClaimNumber and the lifecycle of each claim
+	mu             sync.Mutex
+	claims         map[int]*ClaimRecord
 	cClaimNumber   uint64
 	shareThreshold uint64
 	watcherStarted bool
 	ticker         <-chan time.Time
-	contract       *contract.ContractClient
+	// stops the ticker of LoadClaimRepo
+	stopTicker func()
+	// closed by StopWatcher, watcherDone is closed when actOnTick
+	// returns
+	quit        chan struct{}
+	watcherDone chan struct{}
+	contract    ClaimContract
+	storage     ClaimStorage
+	retryPolicy RetryPolicy
+	// re-checks the transactions of claims until they are final,
+	// only used by the claim watcher
+	tracker *txs.Tracker
+	// counters of shares in the current claim, the augmented merkle
+	// tree needs them to be unique
+	counters map[string]bool
+	// verify claims with eth_call instead of sending transactions
+	debug bool
 }
 
-func LoadClaimRepo(cc *contract.ContractClient) *ClaimRepo {
-	// TODO: load from persistent storage
-	// TODO: this is currently not safe for multiple go routines
-	repo := &ClaimRepo{
-		map[int]Claim{0: Claim{}},
-		0,
-		13,
-		false,
-		time.Tick(params.SubmitInterval),
-		cc,
+// LoadClaimRepo rebuilds the claim repo from the claim journal in
+// params.DataDir. If params.DataDir is empty nothing is persisted.
+func LoadClaimRepo(cc ClaimContract) (*ClaimRepo, error) {
+	var storage ClaimStorage = memoryStorage{}
+	if params.DataDir != "" {
+		journal, err := OpenJournalStorage(filepath.Join(params.DataDir, journalFile))
+		if err != nil {
+			return nil, err
+		}
+		storage = journal
+	}
+	ticker := time.NewTicker(params.SubmitInterval)
+	repo, err := newClaimRepo(cc, storage, ticker.C)
+	if err != nil {
+		ticker.Stop()
+		storage.Close()
+		return nil, err
+	}
+	repo.stopTicker = ticker.Stop
+	if params.NoSharePerClaim > 0 {
+		repo.shareThreshold = uint64(params.NoSharePerClaim)
+	}
+	if params.DebugClaims {
+		fmt.Printf("Warning: proofs are checked with eth_call and never sent, claims won't be paid\n")
+		repo.debug = true
 	}
 	repo.StartWatcher()
-	return repo
+	return repo, nil
 }
 
-func (cr *ClaimRepo) actOnTick_debug() {
-	for t := range cr.ticker {
-		// TODO: get oldest un
-		currentClaim := cr.CurrentClaim()
-		if uint64(len(currentClaim)) >= cr.shareThreshold {
-			fmt.Printf("\n================\n")
-			fmt.Printf("  It's time (%s) to collect submitted shares to construct augmented merkle tree and submit to contract\n", t)
-			fmt.Printf("  First, start new claim\n")
-			fmt.Printf("  Getting claim number: ")
-			cr.cClaimNumber = cr.NextClaimNumber()
-			fmt.Printf("%d\n", cr.cClaimNumber)
-			cr.claims[int(cr.cClaimNumber)] = Claim{}
-			fmt.Printf("  New claim started.\n")
-			fmt.Printf("  Submitting the claim.\n")
-			tx, err := currentClaim.SubmitToContract(cr.contract)
-			if err != nil {
-				panic(err)
+func newClaimRepo(cc ClaimContract, storage ClaimStorage, ticker <-chan time.Time) (*ClaimRepo, error) {
+	stored, err := storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	repo := &ClaimRepo{
+		claims:         stored.claims,
+		cClaimNumber:   stored.current,
+		shareThreshold: 13,
+		watcherStarted: false,
+		ticker:         ticker,
+		contract:       cc,
+		storage:        storage,
+		retryPolicy:    DefaultRetryPolicy,
+		tracker:        txs.NewTracker(params.FinalityDepth),
+		counters:       map[string]bool{},
+	}
+	for _, s := range repo.claims[int(repo.cClaimNumber)].Shares {
+		repo.counters[s.Counter().String()] = true
+	}
+	pending := 0
+	for _, r := range repo.claims {
+		if !r.State().keepsShares() {
+			// shares of finished claims are not needed anymore
+			r.Shares = nil
+		} else if r.State() != ClaimOpen {
+			pending++
+		}
+		repo.trackClaim(r)
+	}
+	if pending > 0 || len(repo.CurrentClaim()) > 0 {
+		fmt.Printf("Restored claims: current claim %d with %d shares, %d claims in progress\n",
+			repo.cClaimNumber, len(repo.CurrentClaim()), pending)
+	}
+	return repo, nil
+}
+
+func (cr *ClaimRepo) transit(r *ClaimRecord, state ClaimState, txHash common.Hash, blockNumber uint64) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.transitLocked(r, state, txHash, blockNumber)
+}
+
+// transitLocked must be called with cr.mu held.
+func (cr *ClaimRepo) transitLocked(r *ClaimRecord, state ClaimState, txHash common.Hash, blockNumber uint64) error {
+	return cr.applyLocked(r, Transition{state, txHash, blockNumber, common.Hash{}, time.Now(), ""})
+}
+
+// confirmLocked moves r to state with the mined transaction of result
+// and tracks the transaction until it is final. It must be called with
+// cr.mu held.
+func (cr *ClaimRepo) confirmLocked(r *ClaimRecord, state ClaimState, result *txs.TxResult) error {
+	t := Transition{state, result.Hash, result.BlockNumber, result.BlockHash, time.Now(), ""}
+	if err := cr.applyLocked(r, t); err != nil {
+		return err
+	}
+	cr.trackClaim(r)
+	return nil
+}
+
+func (cr *ClaimRepo) confirm(r *ClaimRecord, state ClaimState, result *txs.TxResult) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.confirmLocked(r, state, result)
+}
+
+// applyLocked must be called with cr.mu held.
+func (cr *ClaimRepo) applyLocked(r *ClaimRecord, t Transition) error {
+	if err := r.transit(t); err != nil {
+		return err
+	}
+	if err := cr.storage.AddTransition(r.Number, t); err != nil {
+		fmt.Printf("Warning: couldn't record state %s of claim %d: %s\n", t.State, r.Number, err)
+	}
+	if !t.State.keepsShares() {
+		r.Shares = nil
+	}
+	return nil
+}
+
+// fail moves the claim to the dead-letter state so the watcher stops
+// working on it. The reason is kept in the claim history.
+func (cr *ClaimRepo) fail(r *ClaimRecord, reason error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	fmt.Printf("  Giving up claim %d (%s): %s\n", r.Number, r.State(), reason)
+	t := Transition{ClaimFailed, common.Hash{}, 0, common.Hash{}, time.Now(), reason.Error()}
+	if err := cr.applyLocked(r, t); err != nil {
+		fmt.Printf("Warning: %s\n", err)
+	}
+}
+
+// sealIfReady stops the current claim from accepting shares and opens
+// a new one if the current claim has enough shares. It returns the
+// sealed claim or nil if the current claim is not ready.
+func (cr *ClaimRepo) sealIfReady() *ClaimRecord {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	sealed := cr.claims[int(cr.cClaimNumber)]
+	if uint64(len(sealed.Shares)) < cr.shareThreshold {
+		return nil
+	}
+	if err := cr.transitLocked(sealed, ClaimSealed, common.Hash{}, 0); err != nil {
+		fmt.Printf("Warning: couldn't seal claim %d: %s\n", sealed.Number, err)
+		return nil
+	}
+	if sealed.Dropped > 0 {
+		fmt.Printf("Warning: claim %d left out %d shares whose extra data doesn't match the others\n",
+			sealed.Number, sealed.Dropped)
+	}
+	if len(sealed.Shares) == 0 {
+		t := Transition{ClaimFailed, common.Hash{}, 0, common.Hash{}, time.Now(), "no share has the pool's extra data"}
+		if err := cr.applyLocked(sealed, t); err != nil {
+			fmt.Printf("Warning: %s\n", err)
+		}
+	}
+	cr.cClaimNumber = cr.NextClaimNumber()
+	cr.claims[int(cr.cClaimNumber)] = newClaimRecord(cr.cClaimNumber)
+	cr.counters = map[string]bool{}
+	return sealed
+}
+
+// oldest returns the claim with the smallest number in the given state.
+func (cr *ClaimRepo) oldest(state ClaimState) *ClaimRecord {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	var result *ClaimRecord
+	for _, r := range cr.claims {
+		if r.State() == state && (result == nil || r.Number < result.Number) {
+			result = r
+		}
+	}
+	return result
+}
+
+// expireOlderClaims expires claims still waiting for their proof when a
+// newer claim is confirmed. The contract keeps only the last claim of a
+// miner so older ones can't be proved anymore.
+func (cr *ClaimRepo) expireOlderClaims(confirmed *ClaimRecord) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	for _, r := range cr.claims {
+		if r.Number < confirmed.Number && r.State() == ClaimSubmissionConfirmed {
+			fmt.Printf("  Claim %d expired, it was replaced by claim %d.\n", r.Number, confirmed.Number)
+			if err := cr.transitLocked(r, ClaimExpired, common.Hash{}, 0); err != nil {
+				fmt.Printf("Warning: %s\n", err)
 			}
-			fmt.Printf("  Submitted by pending tx: 0x%x.\n", tx.Hash())
-			// wait until tx is confirmed
-			txs.NewTxWatcher(tx).Wait()
-			fmt.Printf("  tx: 0x%x is confirmed.\n", tx.Hash())
-			verResult, err := cr.VerifyClaim_debug()
-			if err != nil {
-				panic(err)
+		}
+	}
+}
+
+// submitClaim submits a sealed claim and waits for the
+// submission to be mined.
+func (cr *ClaimRepo) submitClaim(r *ClaimRecord) error {
+	fmt.Printf("  Submitting claim %d.\n", r.Number)
+	var tx *types.Transaction
+	err := cr.withRetry(r, "submit", func() (err error) {
+		tx, err = r.Shares.SubmitToContract(cr.contract)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  Submitted by pending tx: 0x%x.\n", tx.Hash())
+	if err = cr.transit(r, ClaimSubmitted, tx.Hash(), 0); err != nil {
+		return err
+	}
+	return cr.confirmSubmission(r)
+}
+
+// reject records that the contract reverted a transaction of the claim.
+func (cr *ClaimRepo) reject(r *ClaimRecord, result *txs.TxResult) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.rejectLocked(r, result)
+}
+
+// rejectLocked must be called with cr.mu held.
+func (cr *ClaimRepo) rejectLocked(r *ClaimRecord, result *txs.TxResult) error {
+	fmt.Printf("  tx: 0x%x reverted, gas used %d.\n", result.Hash, result.GasUsed)
+	reason := fmt.Sprintf("tx reverted in block %d, gas used %d", result.BlockNumber, result.GasUsed)
+	t := Transition{ClaimRejected, result.Hash, result.BlockNumber, result.BlockHash, time.Now(), reason}
+	return cr.applyLocked(r, t)
+}
+
+// longest the claim watcher waits for a transaction when
+// params.TxTimeout is 0, every other claim waits meanwhile
+const maxTxWait = time.Hour
+
+// waitMined waits until the transaction r is pending on is confirmed.
+// When it stays pending for params.TxReplaceAfter it is replaced with a
+// higher gas price, whichever version is mined first counts.
+func (cr *ClaimRepo) waitMined(r *ClaimRecord) (*txs.TxResult, error) {
+	timeout := params.TxTimeout
+	if timeout == 0 {
+		timeout = maxTxWait
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		cr.mu.Lock()
+		state, hashes := r.State(), r.pendingTxs()
+		cr.mu.Unlock()
+		left := deadline.Sub(time.Now())
+		if left <= 0 {
+			return nil, fmt.Errorf("tx: 0x%x is not confirmed after %s", hashes[len(hashes)-1], timeout)
+		}
+		wait := params.TxReplaceAfter
+		if wait == 0 || left < wait {
+			wait = left
+		}
+		result, err := txs.NewTxWatcherByHash(hashes...).WaitFor(wait)
+		if err == nil {
+			return result, nil
+		}
+		if params.TxReplaceAfter == 0 {
+			return nil, err
+		}
+		latest := hashes[len(hashes)-1]
+		tx, err := cr.contract.ReplaceTransaction(latest)
+		if err == contract.ErrNotPending {
+			// mined, waiting for its confirmations
+			continue
+		} else if err != nil {
+			fmt.Printf("  Couldn't replace tx: 0x%x: %s\n", latest, err)
+			continue
+		}
+		fmt.Printf("  tx: 0x%x is pending for too long, replaced by tx: 0x%x.\n", latest, tx.Hash())
+		if err = cr.transit(r, state, tx.Hash(), 0); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (cr *ClaimRepo) confirmSubmission(r *ClaimRecord) error {
+	// wait until tx is confirmed
+	result, err := cr.waitMined(r)
+	if err != nil {
+		return err
+	}
+	if !result.Succeeded() {
+		return cr.reject(r, result)
+	}
+	fmt.Printf("  tx: 0x%x is confirmed.\n", result.Hash)
+	if err := cr.confirm(r, ClaimSubmissionConfirmed, result); err != nil {
+		return err
+	}
+	cr.expireOlderClaims(r)
+	return nil
+}
+
+func (cr *ClaimRepo) proveClaim(r *ClaimRecord) error {
+	if cr.debug {
+		var verResult *big.Int
+		err := cr.withRetry(r, "verify", func() (err error) {
+			verResult, err = cr.verifyRecord_debug(r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  Verification result: 0x%s\n", verResult.Text(16))
+		return nil
+	}
+	var tx *types.Transaction
+	err := cr.withRetry(r, "prove", func() (err error) {
+		tx, err = cr.verifyRecord(r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  Verification submitted by pending tx: 0x%x\n", tx.Hash())
+	return cr.confirmProof(r)
+}
+
+func (cr *ClaimRepo) confirmProof(r *ClaimRecord) error {
+	result, err := cr.waitMined(r)
+	if err != nil {
+		return err
+	}
+	if !result.Succeeded() {
+		return cr.reject(r, result)
+	}
+	fmt.Printf("  Verification tx: 0x%x is confirmed\n", result.Hash)
+	return cr.confirm(r, ClaimVerified, result)
+}
+
+// trackClaim tracks the mined transactions of r the claim still depends
+// on. It must be called with cr.mu held.
+func (cr *ClaimRepo) trackClaim(r *ClaimRecord) {
+	state := r.State()
+	if state != ClaimSubmissionConfirmed && state != ClaimProofSubmitted && state != ClaimVerified {
+		return
+	}
+	submission := r.submission()
+	cr.tracker.Track(transitionResult(submission), func(change txs.TxChange, result *txs.TxResult) {
+		cr.submissionChanged(r, change, result)
+	})
+	if current := r.Current(); state == ClaimVerified && current.TxHash != (common.Hash{}) {
+		cr.tracker.Track(transitionResult(current), func(change txs.TxChange, result *txs.TxResult) {
+			cr.proofChanged(r, change, result)
+		})
+	}
+}
+
+func transitionResult(t Transition) *txs.TxResult {
+	return &txs.TxResult{Hash: t.TxHash, BlockNumber: t.BlockNumber, BlockHash: t.BlockHash}
+}
+
+// submissionChanged rolls r back when its submission is reorged. A
+// submission that lands in another block gets another claim seed so
+// the proof is made again.
+func (cr *ClaimRepo) submissionChanged(r *ClaimRecord, change txs.TxChange, result *txs.TxResult) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	state := r.State()
+	if state != ClaimSubmissionConfirmed && state != ClaimProofSubmitted && state != ClaimVerified {
+		return
+	}
+	if r.submission().TxHash != result.Hash {
+		return
+	}
+	if change == txs.TxFinal {
+		// proofs made with eth_call have no transaction to wait for
+		if state == ClaimVerified && r.Current().TxHash == (common.Hash{}) {
+			if err := cr.transitLocked(r, ClaimFinalized, common.Hash{}, 0); err != nil {
+				fmt.Printf("Warning: %s\n", err)
 			}
-			fmt.Printf("  Verification result: 0x%s\n", verResult.Text(16))
-			fmt.Printf("================\n")
+		}
+		return
+	}
+	fmt.Printf("  Submission tx: 0x%x of claim %d is %s by a reorg.\n", result.Hash, r.Number, change)
+	if state == ClaimVerified {
+		cr.tracker.Untrack(r.Current().TxHash)
+	}
+	var err error
+	switch {
+	case change == txs.TxMoved && !result.Succeeded():
+		err = cr.rejectLocked(r, result)
+	case change == txs.TxMoved:
+		err = cr.confirmLocked(r, ClaimSubmissionConfirmed, result)
+	case change == txs.TxPending:
+		err = cr.transitLocked(r, ClaimSubmitted, result.Hash, 0)
+	case change == txs.TxDropped:
+		err = cr.transitLocked(r, ClaimSealed, common.Hash{}, 0)
+	}
+	if err != nil {
+		fmt.Printf("Warning: %s\n", err)
+	}
+}
+
+// proofChanged finalizes r once its proof is final and rolls it back
+// when the proof is reorged.
+func (cr *ClaimRepo) proofChanged(r *ClaimRecord, change txs.TxChange, result *txs.TxResult) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if r.State() != ClaimVerified || r.Current().TxHash != result.Hash {
+		return
+	}
+	var err error
+	switch {
+	case change == txs.TxFinal:
+		t := Transition{ClaimFinalized, result.Hash, result.BlockNumber, result.BlockHash, time.Now(), ""}
+		err = cr.applyLocked(r, t)
+	case change == txs.TxMoved && !result.Succeeded():
+		fmt.Printf("  Verification tx: 0x%x of claim %d is moved by a reorg.\n", result.Hash, r.Number)
+		err = cr.rejectLocked(r, result)
+	case change == txs.TxMoved:
+		fmt.Printf("  Verification tx: 0x%x of claim %d is moved by a reorg.\n", result.Hash, r.Number)
+		err = cr.confirmLocked(r, ClaimVerified, result)
+	case change == txs.TxPending:
+		fmt.Printf("  Verification tx: 0x%x of claim %d is pending again after a reorg.\n", result.Hash, r.Number)
+		err = cr.transitLocked(r, ClaimProofSubmitted, result.Hash, 0)
+	case change == txs.TxDropped:
+		fmt.Printf("  Verification tx: 0x%x of claim %d is dropped by a reorg.\n", result.Hash, r.Number)
+		submission := r.submission()
+		submission.Time = time.Now()
+		err = cr.applyLocked(r, submission)
+	}
+	if err != nil {
+		fmt.Printf("Warning: %s\n", err)
+	}
+}
+
+// advanceClaims moves every claim that is not open through its
+// lifecycle, oldest first, until each of them is in a final state.
+// A claim that can't make progress after retrying is moved to the
+// failed state so it doesn't hold back the other claims.
+func (cr *ClaimRepo) advanceClaims() {
+	for {
+		var r *ClaimRecord
+		var err error
+		if r = cr.oldest(ClaimSealed); r != nil {
+			err = cr.submitClaim(r)
+		} else if r = cr.oldest(ClaimSubmitted); r != nil {
+			err = cr.confirmSubmission(r)
+		} else if r = cr.oldest(ClaimProofSubmitted); r != nil {
+			err = cr.confirmProof(r)
+		} else if r = cr.oldest(ClaimSubmissionConfirmed); r != nil {
+			err = cr.proveClaim(r)
+		} else {
+			return
+		}
+		if err != nil {
+			cr.fail(r, err)
 		}
 	}
 }
 
 func (cr *ClaimRepo) actOnTick() {
-	for t := range cr.ticker {
-		// TODO: get oldest un
-		currentClaim := cr.CurrentClaim()
-		if uint64(len(currentClaim)) >= cr.shareThreshold {
+	defer close(cr.watcherDone)
+	for {
+		var t time.Time
+		select {
+		case t = <-cr.ticker:
+		case <-cr.quit:
+			return
+		}
+		// roll back claims whose transactions were reorged, then
+		// finish claims left over from previous ticks or runs
+		cr.tracker.Check()
+		cr.advanceClaims()
+		if sealed := cr.sealIfReady(); sealed != nil {
 			fmt.Printf("\n================\n")
 			fmt.Printf("  It's time (%s) to collect submitted shares to construct augmented merkle tree and submit to contract\n", t)
-			fmt.Printf("  First, start new claim\n")
-			fmt.Printf("  Getting claim number: ")
-			cr.cClaimNumber = cr.NextClaimNumber()
-			fmt.Printf("%d\n", cr.cClaimNumber)
-			cr.claims[int(cr.cClaimNumber)] = Claim{}
-			fmt.Printf("  New claim started.\n")
-			fmt.Printf("  Submitting the claim.\n")
-			tx, err := currentClaim.SubmitToContract(cr.contract)
-			if err != nil {
-				panic(err)
-			}
-			fmt.Printf("  Submitted by pending tx: 0x%x.\n", tx.Hash())
-			// wait until tx is confirmed
-			txs.NewTxWatcher(tx).Wait()
-			fmt.Printf("  tx: 0x%x is confirmed.\n", tx.Hash())
-			tx, err = cr.VerifyClaim()
-			if err != nil {
-				panic(err)
-			}
-			fmt.Printf("  Verification submitted by pending tx: 0x%x\n", tx.Hash())
-			txs.NewTxWatcher(tx).Wait()
-			fmt.Printf("  Verification tx: 0x%x is confirmed\n", tx.Hash())
+			fmt.Printf("  Claim %d sealed with %d shares.\n", sealed.Number, len(sealed.Shares))
+			fmt.Printf("  New claim %d started.\n", sealed.Number+1)
+			cr.advanceClaims()
 			fmt.Printf("================\n")
 		}
 	}
@@ -108,52 +527,191 @@ func (cr *ClaimRepo) StartWatcher() {
 		fmt.Printf("Warning: calling ClaimRepo.StatWatcher multiple times\n")
 		return
 	}
-	// TODO: change to actOnTick
-	go cr.actOnTick_debug()
+	cr.quit = make(chan struct{})
+	cr.watcherDone = make(chan struct{})
+	go cr.actOnTick()
 	cr.watcherStarted = true
 }
 
-func (cr *ClaimRepo) AddShare(s *share.Share) {
-	cr.claims[int(cr.cClaimNumber)] = append(cr.claims[int(cr.cClaimNumber)][:], s)
+// StopWatcher stops the claim watcher once it finishes the tick it
+// works on. Claims are left where they are and go on when the watcher
+// is started again.
+func (cr *ClaimRepo) StopWatcher() {
+	if !cr.watcherStarted {
+		return
+	}
+	close(cr.quit)
+	<-cr.watcherDone
+	if cr.stopTicker != nil {
+		cr.stopTicker()
+	}
+	cr.watcherStarted = false
+}
+
+var ErrDuplicateShare = errors.New("duplicate share")
+
+// AddShare adds s to the current claim. It fails with
+// ErrDuplicateShare if the claim already has a share with the same
+// counter.
+func (cr *ClaimRepo) AddShare(s *share.Share) error {
+	counter := s.Counter().String()
+	cr.mu.Lock()
+	if cr.counters[counter] {
+		cr.mu.Unlock()
+		return ErrDuplicateShare
+	}
+	if err := cr.storage.AddShare(cr.cClaimNumber, s); err != nil {
+		fmt.Printf("Warning: couldn't record share of claim %d: %s\n", cr.cClaimNumber, err)
+	}
+	cr.counters[counter] = true
+	r := cr.claims[int(cr.cClaimNumber)]
+	r.Shares = append(r.Shares[:], s)
+	cr.mu.Unlock()
+	// the share is written in claim order above, the fsync is shared
+	// with the shares added meanwhile
+	if err := cr.storage.Sync(); err != nil {
+		fmt.Printf("Warning: couldn't sync recorded shares: %s\n", err)
+	}
+	return nil
 }
 
 func (cr *ClaimRepo) GetClaim(number int) Claim {
-	return cr.claims[number]
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if r := cr.claims[number]; r != nil {
+		return r.Shares
+	}
+	return nil
+}
+
+// Record returns a snapshot of the claim with its lifecycle or nil if
+// the claim number is unknown.
+func (cr *ClaimRepo) Record(number int) *ClaimRecord {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if r := cr.claims[number]; r != nil {
+		return r.snapshot()
+	}
+	return nil
+}
+
+// Records returns snapshots of all known claims ordered by claim number.
+func (cr *ClaimRepo) Records() []*ClaimRecord {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	result := []*ClaimRecord{}
+	for _, r := range cr.claims {
+		result = append(result, r.snapshot())
+	}
+	sort.Sort(byNumber(result))
+	return result
+}
+
+type byNumber []*ClaimRecord
+
+func (b byNumber) Len() int           { return len(b) }
+func (b byNumber) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byNumber) Less(i, j int) bool { return b[i].Number < b[j].Number }
+
+func (cr *ClaimRepo) PrintInfo() {
+	for _, r := range cr.Records() {
+		r.PrintInfo()
+	}
+}
+
+// getClaimToVerify returns the oldest claim whose submission is
+// confirmed but whose proof is not submitted yet.
+func (cr *ClaimRepo) getClaimToVerify() *ClaimRecord {
+	return cr.oldest(ClaimSubmissionConfirmed)
 }
 
-func (cr *ClaimRepo) getClaimToVerify() Claim {
-	// TODO: get the oldest unverified claim
-	// right now, we just get the claim that has
-	// claim number of current number - 3
-	return cr.GetClaim(int(cr.cClaimNumber) - 1)
+// requestedShareIndex reads the claim seed from the contract and returns
+// index of the share to prove. The seed is only meaningful once the
+// claim submission is confirmed.
+func (cr *ClaimRepo) requestedShareIndex(r *ClaimRecord) (int, error) {
+	if r.State() != ClaimSubmissionConfirmed {
+		return 0, Fatal(fmt.Errorf("claim %d is %s, its seed is not available", r.Number, r.State()))
+	}
+	seed, err := cr.contract.GetClaimSeed()
+	if err != nil {
+		return 0, err
+	}
+	index, err := r.Shares.ShareIndex(seed)
+	if err != nil {
+		return 0, Fatal(err)
+	}
+	fmt.Printf("  Claim seed: 0x%s, proving share %d of %d\n", seed.Text(16), index, len(r.Shares))
+	return index, nil
 }
 
 // TODO: remove this function
 func (cr *ClaimRepo) VerifyClaim_debug() (*big.Int, error) {
-	index := 8
-	claim := cr.getClaimToVerify()
-	if claim != nil {
-		return claim.SubmitProof_debug(cr.contract, index)
-	} else {
+	r := cr.getClaimToVerify()
+	if r == nil {
 		return nil, nil
 	}
+	return cr.verifyRecord_debug(r)
+}
+
+// TODO: remove this function
+func (cr *ClaimRepo) verifyRecord_debug(r *ClaimRecord) (*big.Int, error) {
+	index, err := cr.requestedShareIndex(r)
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.Shares.SubmitProof_debug(cr.contract, index)
+	if err != nil {
+		return nil, err
+	}
+	// verifyClaim_debug returns 0 for a valid proof and the code of the
+	// check that failed otherwise
+	if result == nil || result.Sign() != 0 {
+		return result, Fatal(fmt.Errorf("contract refused the proof of claim %d with code %v", r.Number, result))
+	}
+	// eth_call doesn't leave a transaction behind, the claim is final
+	// once its submission is
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if err = cr.transitLocked(r, ClaimVerified, common.Hash{}, 0); err != nil {
+		return nil, err
+	}
+	cr.trackClaim(r)
+	return result, nil
 }
 
 func (cr *ClaimRepo) VerifyClaim() (*types.Transaction, error) {
-	// TODO: Get seed from contract
-	index := 8
-	claim := cr.getClaimToVerify()
-	if claim != nil {
-		return claim.SubmitProof(cr.contract, index)
-	} else {
+	r := cr.getClaimToVerify()
+	if r == nil {
 		return nil, nil
 	}
+	return cr.verifyRecord(r)
 }
 
+func (cr *ClaimRepo) verifyRecord(r *ClaimRecord) (*types.Transaction, error) {
+	index, err := cr.requestedShareIndex(r)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := r.Shares.SubmitProof(cr.contract, index)
+	if err != nil {
+		return nil, err
+	}
+	return tx, cr.transit(r, ClaimProofSubmitted, tx.Hash(), 0)
+}
+
+// NextClaimNumber must be called with cr.mu held.
 func (cr *ClaimRepo) NextClaimNumber() uint64 {
 	return cr.cClaimNumber + 1
 }
 
+func (cr *ClaimRepo) CurrentClaimNumber() uint64 {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.cClaimNumber
+}
+
 func (cr *ClaimRepo) CurrentClaim() Claim {
-	return cr.claims[int(cr.cClaimNumber)]
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.claims[int(cr.cClaimNumber)].Shares
 }
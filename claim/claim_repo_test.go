@@ -0,0 +1,110 @@
+package claim
+
+import (
+	spcommon "../common"
+	"../share"
+	"../txs"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"testing"
+)
+
+func testWork(i int) *spcommon.Work {
+	h := &types.Header{
+		Number:     big.NewInt(1),
+		Difficulty: big.NewInt(1000000),
+		Time:       big.NewInt(int64(i)),
+		Extra:      []byte(spcommon.ExtraData(common.Address{}, big.NewInt(100000))),
+	}
+	return spcommon.NewWork(h, fmt.Sprintf("0x%064x", i), "0x0", big.NewInt(100000))
+}
+
+func TestClaimRepoRejectsDuplicateShares(t *testing.T) {
+	repo, err := newClaimRepo(nil, memoryStorage{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.shareThreshold = 2
+	work := testWork(1)
+	if err := repo.AddShare(share.NewShare(work.BlockHeader(), work.ShareDifficulty())); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddShare(share.NewShare(work.BlockHeader(), work.ShareDifficulty())); err != ErrDuplicateShare {
+		t.Errorf("expected %v, got %v", ErrDuplicateShare, err)
+	}
+	work = testWork(2)
+	if err := repo.AddShare(share.NewShare(work.BlockHeader(), work.ShareDifficulty())); err != nil {
+		t.Fatal(err)
+	}
+	if repo.sealIfReady() == nil {
+		t.Fatal("claim wasn't sealed")
+	}
+	// counters are unique per claim only
+	if err := repo.AddShare(share.NewShare(work.BlockHeader(), work.ShareDifficulty())); err != nil {
+		t.Errorf("share rejected by the next claim: %v", err)
+	}
+}
+
+// A reorg dropping the submission of a verified claim sends the claim
+// back to be submitted again with its shares.
+func TestClaimRepoRollsBackReorgedSubmission(t *testing.T) {
+	repo, err := newClaimRepo(nil, memoryStorage{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.shareThreshold = 1
+	work := testWork(1)
+	if err := repo.AddShare(share.NewShare(work.BlockHeader(), work.ShareDifficulty())); err != nil {
+		t.Fatal(err)
+	}
+	r := repo.sealIfReady()
+	submission := &txs.TxResult{Hash: common.HexToHash("0x01"), BlockNumber: 10, BlockHash: common.HexToHash("0xa")}
+	proof := &txs.TxResult{Hash: common.HexToHash("0x02"), BlockNumber: 12, BlockHash: common.HexToHash("0xb")}
+	repo.transit(r, ClaimSubmitted, submission.Hash, 0)
+	repo.confirm(r, ClaimSubmissionConfirmed, submission)
+	repo.transit(r, ClaimProofSubmitted, proof.Hash, 0)
+	repo.confirm(r, ClaimVerified, proof)
+
+	moved := *submission
+	moved.BlockNumber, moved.BlockHash = 11, common.HexToHash("0xc")
+	repo.submissionChanged(r, txs.TxMoved, &moved)
+	if r.State() != ClaimSubmissionConfirmed || r.Current().BlockNumber != 11 {
+		t.Fatalf("expected %s in block 11, got %s in block %d", ClaimSubmissionConfirmed, r.State(), r.Current().BlockNumber)
+	}
+	// the proof of the old seed is not followed anymore
+	repo.proofChanged(r, txs.TxFinal, proof)
+	if r.State() != ClaimSubmissionConfirmed {
+		t.Errorf("stale proof moved the claim to %s", r.State())
+	}
+	repo.submissionChanged(r, txs.TxDropped, &moved)
+	if r.State() != ClaimSealed {
+		t.Errorf("expected %s, got %s", ClaimSealed, r.State())
+	}
+	if len(r.Shares) != 1 {
+		t.Errorf("expected the share to be kept, got %d shares", len(r.Shares))
+	}
+}
+
+// Failed claims keep their shares so they can be proved again.
+func TestClaimRepoKeepsSharesOfFailedClaims(t *testing.T) {
+	repo, err := newClaimRepo(nil, memoryStorage{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.shareThreshold = 1
+	work := testWork(1)
+	if err := repo.AddShare(share.NewShare(work.BlockHeader(), work.ShareDifficulty())); err != nil {
+		t.Fatal(err)
+	}
+	r := repo.sealIfReady()
+	repo.fail(r, errors.New("node is down"))
+	if r.State() != ClaimFailed {
+		t.Fatalf("expected %s, got %s", ClaimFailed, r.State())
+	}
+	if len(r.Shares) != 1 || len(repo.Record(int(r.Number)).Shares) != 1 {
+		t.Errorf("expected the share of the failed claim to be kept, got %d shares", len(r.Shares))
+	}
+}
@@ -0,0 +1,55 @@
+package claim
+
+import (
+	spcommon "../common"
+	"../share"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"testing"
+)
+
+func TestClaimableKeepsVardiffShares(t *testing.T) {
+	c := Claim{}
+	for i, d := range []int64{100000, 400000, 400000, 100000, 800000} {
+		c = append(c, share.NewShare(testWork(i).BlockHeader(), big.NewInt(d)))
+	}
+	kept, dropped := c.claimable()
+	if len(kept) != 5 || len(dropped) != 0 {
+		t.Fatalf("expected 5 shares kept and none dropped, got %d and %d", len(kept), len(dropped))
+	}
+	// the contract checks the claim difficulty against the extra data
+	if kept.Difficulty().Int64() != 100000 {
+		t.Errorf("expected the extra data difficulty 100000, got %s", kept.Difficulty())
+	}
+}
+
+func TestClaimableDropsOtherExtraData(t *testing.T) {
+	c := Claim{}
+	for i := 0; i < 3; i++ {
+		c = append(c, share.NewShare(testWork(i).BlockHeader(), big.NewInt(100000)))
+	}
+	// shares collected before the share difficulty was changed
+	for i := 3; i < 5; i++ {
+		h := testWork(i).BlockHeader()
+		h.Extra = []byte(spcommon.ExtraData(common.Address{}, big.NewInt(200000)))
+		c = append(c, share.NewShare(h, big.NewInt(200000)))
+	}
+	// 100000*3 = 300000 < 200000*2 = 400000
+	kept, dropped := c.claimable()
+	if len(kept) != 2 || len(dropped) != 3 {
+		t.Fatalf("expected 2 shares kept and 3 dropped, got %d and %d", len(kept), len(dropped))
+	}
+	if kept.Difficulty().Int64() != 200000 {
+		t.Errorf("expected difficulty 200000, got %s", kept.Difficulty())
+	}
+
+	r := newClaimRecord(0)
+	r.Shares = c
+	if err := r.transit(Transition{State: ClaimSealed}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Shares) != 2 || r.Dropped != 3 {
+		t.Errorf("expected the sealed claim to record 3 dropped shares, got %d shares and %d dropped",
+			len(r.Shares), r.Dropped)
+	}
+}
@@ -0,0 +1,134 @@
+package claim
+
+import (
+	"../client"
+	"../node"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// RetryPolicy tells the claim watcher how to retry a failed step of the
+// claim lifecycle before giving up on the claim.
+type RetryPolicy struct {
+	// attempts including the first one
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  8,
+	InitialDelay: 5 * time.Second,
+	MaxDelay:     5 * time.Minute,
+	Multiplier:   2,
+}
+
+// Delay returns how long to wait after the given failed attempt,
+// attempts are counted from 1.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := float64(p.InitialDelay)
+	for i := 1; i < attempt; i++ {
+		delay *= p.Multiplier
+		if delay >= float64(p.MaxDelay) {
+			return p.MaxDelay
+		}
+	}
+	return time.Duration(delay)
+}
+
+// fatalError is an error that retrying can't fix.
+type fatalError struct {
+	error
+}
+
+// Fatal marks err as not retryable.
+func Fatal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fatalError{err}
+}
+
+// errors of the connection to the node, they go away once the node is
+// back or another one is used
+var transientMessages = []string{
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"no such host",
+	"timeout",
+	"timed out",
+	"deadline exceeded",
+	"eof",
+}
+
+// nonce clashes, the nonce of the account was used by a transaction
+// the client didn't count, e.g. one sent elsewhere or one whose send
+// timed out but reached the node. A failed send makes the nonce manager
+// take the next nonce from the node again, see NonceManager.Send, so
+// the next attempt uses a free one.
+var nonceMessages = []string{
+	"nonce too low",
+	"replacement transaction underpriced",
+}
+
+// IsRetryable tells whether a failed step may succeed when it is tried
+// again. Connection errors, timeouts and nonce clashes are, reverts and
+// any other error of the node would fail the same way. A transaction
+// the node already knows was sent, the sender returns it without an
+// error.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(fatalError); ok {
+		return false
+	}
+	if client.IsUnavailable(err) || err == node.ErrNoEndpoint {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == context.DeadlineExceeded {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range transientMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	for _, m := range nonceMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRetry runs f until it succeeds, fails with a fatal error or runs
+// out of attempts. It returns the last error.
+func (cr *ClaimRepo) withRetry(r *ClaimRecord, step string, f func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = f(); err == nil {
+			return nil
+		}
+		if !IsRetryable(err) {
+			return err
+		}
+		if attempt >= cr.retryPolicy.MaxAttempts {
+			return fmt.Errorf("%s failed %d times, last error: %s", step, attempt, err)
+		}
+		delay := cr.retryPolicy.Delay(attempt)
+		fmt.Printf("  Couldn't %s claim %d (attempt %d/%d): %s. Retry in %s...\n",
+			step, r.Number, attempt, cr.retryPolicy.MaxAttempts, err, delay)
+		time.Sleep(delay)
+	}
+}
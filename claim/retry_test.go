@@ -0,0 +1,38 @@
+package claim
+
+import (
+	"../client"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestIsRetryable(t *testing.T) {
+	retryable := []error{
+		io.EOF,
+		&client.UnavailableError{"eth_sendRawTransaction", errors.New("dial tcp: connection refused")},
+		errors.New("Post http://127.0.0.1:8545: net/http: request canceled (Client.Timeout exceeded)"),
+		errors.New("context deadline exceeded"),
+		// the nonce is taken from the node again on the next attempt
+		errors.New("nonce too low"),
+		errors.New("replacement transaction underpriced"),
+	}
+	for _, err := range retryable {
+		if !IsRetryable(err) {
+			t.Errorf("expected %q to be retryable", err)
+		}
+	}
+	fatal := []error{
+		errors.New("VM Exception while processing transaction: revert"),
+		// the sender returns known transactions, sending one again
+		// would use another nonce
+		errors.New("known transaction: 0x01"),
+		errors.New("exceeds block gas limit"),
+		Fatal(io.EOF),
+	}
+	for _, err := range fatal {
+		if IsRetryable(err) {
+			t.Errorf("expected %q not to be retryable", err)
+		}
+	}
+}
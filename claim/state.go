@@ -0,0 +1,213 @@
+package claim
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"time"
+)
+
+type ClaimState int
+
+const (
+	// claim is accepting shares
+	ClaimOpen ClaimState = iota
+	// claim stopped accepting shares and waits to be submitted
+	ClaimSealed
+	// claim submission tx is pending
+	ClaimSubmitted
+	// claim submission tx is mined, the claim waits for its proof
+	ClaimSubmissionConfirmed
+	// proof tx is pending
+	ClaimProofSubmitted
+	// proof tx is mined, the claim is done unless a reorg drops one of
+	// its transactions
+	ClaimVerified
+	// contract didn't accept the claim or its proof
+	ClaimRejected
+	// claim can't be proved anymore
+	ClaimExpired
+	// claim kept failing and was given up, see the error of the
+	// transition
+	ClaimFailed
+	// proof tx is deeper than params.FinalityDepth, the claim is done
+	ClaimFinalized
+)
+
+var stateNames = map[ClaimState]string{
+	ClaimOpen:                "open",
+	ClaimSealed:              "sealed",
+	ClaimSubmitted:           "submitted",
+	ClaimSubmissionConfirmed: "submission-confirmed",
+	ClaimProofSubmitted:      "proof-submitted",
+	ClaimVerified:            "verified",
+	ClaimRejected:            "rejected",
+	ClaimExpired:             "expired",
+	ClaimFailed:              "failed",
+	ClaimFinalized:           "finalized",
+}
+
+func (s ClaimState) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+func ParseClaimState(name string) (ClaimState, error) {
+	for s, n := range stateNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown claim state: %s", name)
+}
+
+// Final states are never left.
+func (s ClaimState) Final() bool {
+	return s == ClaimFinalized || s == ClaimRejected || s == ClaimExpired || s == ClaimFailed
+}
+
+// keepsShares tells whether a claim in state may still need its
+// shares. Failed claims keep them so they can be inspected and proved
+// again, see the claim prove command.
+func (s ClaimState) keepsShares() bool {
+	return !s.Final() || s == ClaimFailed
+}
+
+// states each state can move to. A reorg moves a claim back to
+// resubmit the transaction it lost, or to the same state when the
+// transaction lands in another block. Pending states move to
+// themselves when their transaction is replaced.
+var transitions = map[ClaimState][]ClaimState{
+	ClaimOpen:   {ClaimSealed},
+	ClaimSealed: {ClaimSubmitted, ClaimExpired, ClaimFailed},
+	ClaimSubmitted: {ClaimSubmissionConfirmed, ClaimRejected, ClaimExpired, ClaimFailed,
+		ClaimSubmitted},
+	ClaimSubmissionConfirmed: {ClaimProofSubmitted, ClaimVerified, ClaimRejected, ClaimExpired, ClaimFailed,
+		ClaimSealed, ClaimSubmitted, ClaimSubmissionConfirmed},
+	ClaimProofSubmitted: {ClaimVerified, ClaimRejected, ClaimExpired, ClaimFailed,
+		ClaimSealed, ClaimSubmitted, ClaimSubmissionConfirmed, ClaimProofSubmitted},
+	ClaimVerified: {ClaimFinalized, ClaimRejected, ClaimFailed,
+		ClaimSealed, ClaimSubmitted, ClaimSubmissionConfirmed, ClaimProofSubmitted, ClaimVerified},
+}
+
+func canTransit(from, to ClaimState) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition records when and how a claim entered a state. TxHash and
+// BlockNumber are zero when the state isn't tied to a transaction or
+// the transaction is not mined yet. Error explains why a claim failed.
+type Transition struct {
+	State       ClaimState
+	TxHash      common.Hash
+	BlockNumber uint64
+	BlockHash   common.Hash
+	Time        time.Time
+	Error       string
+}
+
+// ClaimRecord is a claim together with its lifecycle.
+type ClaimRecord struct {
+	Number uint64
+	Shares Claim
+	// number of shares left out when the claim was sealed, see
+	// Claim.claimable
+	Dropped int
+	History []Transition
+}
+
+func newClaimRecord(number uint64) *ClaimRecord {
+	return &ClaimRecord{
+		number,
+		Claim{},
+		0,
+		[]Transition{{ClaimOpen, common.Hash{}, 0, common.Hash{}, time.Now(), ""}},
+	}
+}
+
+// snapshot copies the record so it can be read while the original
+// keeps changing. Shares themselves are never modified.
+func (r *ClaimRecord) snapshot() *ClaimRecord {
+	history := make([]Transition, len(r.History))
+	copy(history, r.History)
+	shares := make(Claim, len(r.Shares))
+	copy(shares, r.Shares)
+	return &ClaimRecord{r.Number, shares, r.Dropped, history}
+}
+
+func (r *ClaimRecord) Current() Transition {
+	return r.History[len(r.History)-1]
+}
+
+func (r *ClaimRecord) State() ClaimState {
+	return r.Current().State
+}
+
+// pendingTxs returns every version of the transaction the claim waits
+// for, the latest last.
+func (r *ClaimRecord) pendingTxs() []common.Hash {
+	state := r.State()
+	result := []common.Hash{}
+	for i := len(r.History) - 1; i >= 0 && r.History[i].State == state; i-- {
+		result = append([]common.Hash{r.History[i].TxHash}, result...)
+	}
+	return result
+}
+
+// submission returns the last confirmation of the claim submission.
+func (r *ClaimRecord) submission() Transition {
+	for i := len(r.History) - 1; i >= 0; i-- {
+		if r.History[i].State == ClaimSubmissionConfirmed {
+			return r.History[i]
+		}
+	}
+	return Transition{}
+}
+
+// Since returns when the claim entered its current state.
+func (r *ClaimRecord) Since() time.Time {
+	return r.Current().Time
+}
+
+// transit moves the claim to t.State. Sealing leaves out the shares
+// the contract can't credit together with the others and counts them
+// in Dropped, replaying the journal leaves out the same ones.
+func (r *ClaimRecord) transit(t Transition) error {
+	if !canTransit(r.State(), t.State) {
+		return fmt.Errorf("claim %d can't move from %s to %s", r.Number, r.State(), t.State)
+	}
+	if t.State == ClaimSealed && r.State() == ClaimOpen {
+		var dropped Claim
+		r.Shares, dropped = r.Shares.claimable()
+		r.Dropped = len(dropped)
+	}
+	r.History = append(r.History, t)
+	return nil
+}
+
+func (r *ClaimRecord) PrintInfo() {
+	fmt.Printf("Claim %d: %s, %d shares", r.Number, r.State(), len(r.Shares))
+	if r.Dropped > 0 {
+		fmt.Printf(", %d dropped", r.Dropped)
+	}
+	fmt.Printf("\n")
+	for _, t := range r.History {
+		fmt.Printf("	%-20s %s", t.State, t.Time.Format(time.RFC3339))
+		if t.TxHash != (common.Hash{}) {
+			fmt.Printf(" tx: %s", t.TxHash.Hex())
+		}
+		if t.BlockNumber != 0 {
+			fmt.Printf(" block: %d", t.BlockNumber)
+		}
+		if t.Error != "" {
+			fmt.Printf(" error: %s", t.Error)
+		}
+		fmt.Printf("\n")
+	}
+}
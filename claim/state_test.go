@@ -0,0 +1,136 @@
+package claim
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"testing"
+	"time"
+)
+
+func TestCanTransit(t *testing.T) {
+	tests := []struct {
+		from, to ClaimState
+		ok       bool
+	}{
+		{ClaimOpen, ClaimSealed, true},
+		{ClaimOpen, ClaimSubmitted, false},
+		{ClaimOpen, ClaimFailed, false},
+		{ClaimSealed, ClaimSubmitted, true},
+		{ClaimSealed, ClaimExpired, true},
+		{ClaimSealed, ClaimSealed, false},
+		{ClaimSealed, ClaimSubmissionConfirmed, false},
+		// a replaced submission
+		{ClaimSubmitted, ClaimSubmitted, true},
+		{ClaimSubmitted, ClaimSubmissionConfirmed, true},
+		{ClaimSubmitted, ClaimRejected, true},
+		{ClaimSubmitted, ClaimVerified, false},
+		{ClaimSubmissionConfirmed, ClaimProofSubmitted, true},
+		// a proof checked with eth_call has no transaction
+		{ClaimSubmissionConfirmed, ClaimVerified, true},
+		// the submission is dropped or moved by a reorg
+		{ClaimSubmissionConfirmed, ClaimSealed, true},
+		{ClaimSubmissionConfirmed, ClaimSubmissionConfirmed, true},
+		{ClaimSubmissionConfirmed, ClaimFinalized, false},
+		{ClaimProofSubmitted, ClaimVerified, true},
+		{ClaimProofSubmitted, ClaimProofSubmitted, true},
+		{ClaimProofSubmitted, ClaimFinalized, false},
+		{ClaimVerified, ClaimFinalized, true},
+		{ClaimVerified, ClaimProofSubmitted, true},
+		{ClaimVerified, ClaimExpired, false},
+		{ClaimVerified, ClaimOpen, false},
+		{ClaimFinalized, ClaimVerified, false},
+		{ClaimRejected, ClaimSealed, false},
+		{ClaimExpired, ClaimSubmitted, false},
+		{ClaimFailed, ClaimSealed, false},
+	}
+	for _, test := range tests {
+		if ok := canTransit(test.from, test.to); ok != test.ok {
+			t.Errorf("canTransit(%s, %s) = %v, expected %v", test.from, test.to, ok, test.ok)
+		}
+	}
+}
+
+// Final states are never left, the others can always fail.
+func TestFinalStatesAreNotLeft(t *testing.T) {
+	for from := range stateNames {
+		for to := range stateNames {
+			if from.Final() && canTransit(from, to) {
+				t.Errorf("final state %s can move to %s", from, to)
+			}
+		}
+		if !from.Final() && from != ClaimOpen && !canTransit(from, ClaimFailed) {
+			t.Errorf("state %s can't fail", from)
+		}
+	}
+}
+
+func TestClaimRecordTransit(t *testing.T) {
+	tests := []struct {
+		states []ClaimState
+		// index of the first state the record can't move to, -1 if it
+		// moves through all of them
+		invalid int
+	}{
+		{[]ClaimState{ClaimSealed, ClaimSubmitted, ClaimSubmissionConfirmed, ClaimProofSubmitted, ClaimVerified, ClaimFinalized}, -1},
+		{[]ClaimState{ClaimSealed, ClaimSubmitted, ClaimSubmitted, ClaimRejected}, -1},
+		{[]ClaimState{ClaimSealed, ClaimSubmitted, ClaimSubmissionConfirmed, ClaimSealed, ClaimSubmitted}, -1},
+		{[]ClaimState{ClaimSealed, ClaimSubmitted, ClaimSubmissionConfirmed, ClaimExpired, ClaimProofSubmitted}, 4},
+		{[]ClaimState{ClaimSealed, ClaimFailed, ClaimSealed}, 2},
+		{[]ClaimState{ClaimSubmitted}, 0},
+	}
+	for _, test := range tests {
+		r := newClaimRecord(0)
+		for i, s := range test.states {
+			tx := common.HexToHash("0x1")
+			err := r.transit(Transition{State: s, TxHash: tx, Time: time.Now()})
+			if i != test.invalid {
+				if err != nil {
+					t.Errorf("%v: unexpected error at state %d: %s", test.states, i, err)
+					break
+				}
+				if r.State() != s || r.Current().TxHash != tx || len(r.History) != i+2 {
+					t.Errorf("%v: expected %s after %d states, got %s after %d",
+						test.states, s, i+2, r.State(), len(r.History))
+				}
+				continue
+			}
+			if err == nil {
+				t.Errorf("%v: expected moving to %s to fail", test.states, s)
+			}
+			// a refused transition leaves the record as it was
+			if len(r.History) != i+1 {
+				t.Errorf("%v: expected %d states after the refused one, got %d", test.states, i+1, len(r.History))
+			}
+			break
+		}
+	}
+}
+
+func TestKeepsShares(t *testing.T) {
+	tests := map[ClaimState]bool{
+		ClaimOpen:           true,
+		ClaimProofSubmitted: true,
+		ClaimVerified:       true,
+		// kept to prove the claim again
+		ClaimFailed:    true,
+		ClaimRejected:  false,
+		ClaimExpired:   false,
+		ClaimFinalized: false,
+	}
+	for s, expected := range tests {
+		if s.keepsShares() != expected {
+			t.Errorf("expected %s to keep shares: %v", s, expected)
+		}
+	}
+}
+
+func TestParseClaimState(t *testing.T) {
+	for s, name := range stateNames {
+		parsed, err := ParseClaimState(name)
+		if err != nil || parsed != s {
+			t.Errorf("expected %s to parse as %d, got %d, %v", name, s, parsed, err)
+		}
+	}
+	if _, err := ParseClaimState("mined"); err == nil {
+		t.Error("expected an unknown state not to parse")
+	}
+}
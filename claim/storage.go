@@ -0,0 +1,463 @@
+package claim
+
+import (
+	"../share"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rlp"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// name of the claim journal in the data directory
+const journalFile = "claims.journal"
+
+// Kinds of entries recorded in storage.
+const (
+	// a share was added to a claim
+	eventShare = "share"
+	// a claim moved to another state
+	eventState = "state"
+	// shares a claim left out when it was sealed, written instead of
+	// them when the journal is compacted
+	eventDropped = "dropped"
+)
+
+// ClaimStorage persists what the ClaimRepo needs to resume its work
+// after the client is restarted.
+type ClaimStorage interface {
+	// AddShare records a share received for claim number. It may not
+	// be on disk before Sync returns.
+	AddShare(number uint64, s *share.Share) error
+	// Sync makes sure the shares recorded so far are on disk.
+	Sync() error
+	// AddTransition records a state change of claim number.
+	AddTransition(number uint64, t Transition) error
+	// Load replays everything recorded so far.
+	Load() (*storedClaims, error)
+	Close() error
+}
+
+// storedClaims is the repo state rebuilt from storage.
+type storedClaims struct {
+	current uint64
+	claims  map[int]*ClaimRecord
+}
+
+func newStoredClaims() *storedClaims {
+	return &storedClaims{
+		0,
+		map[int]*ClaimRecord{0: newClaimRecord(0)},
+	}
+}
+
+func (sc *storedClaims) record(number uint64) *ClaimRecord {
+	r := sc.claims[int(number)]
+	if r == nil {
+		r = newClaimRecord(number)
+		sc.claims[int(number)] = r
+	}
+	return r
+}
+
+func (sc *storedClaims) addShare(number uint64, s *share.Share) {
+	r := sc.record(number)
+	r.Shares = append(r.Shares[:], s)
+}
+
+func (sc *storedClaims) addTransition(number uint64, t Transition) error {
+	if err := sc.record(number).transit(t); err != nil {
+		return err
+	}
+	// sealing a claim always opens the next one
+	if t.State == ClaimSealed && number+1 > sc.current {
+		sc.current = number + 1
+		sc.record(sc.current)
+	}
+	return nil
+}
+
+func (sc *storedClaims) apply(entry journalEntry) error {
+	switch entry.Kind {
+	case eventShare:
+		s := &share.Share{}
+		if err := rlp.DecodeBytes(entry.Share, s); err != nil {
+			return err
+		}
+		sc.addShare(entry.Claim, s)
+	case eventState:
+		t, err := entry.transition()
+		if err != nil {
+			return err
+		}
+		return sc.addTransition(entry.Claim, t)
+	case eventDropped:
+		sc.record(entry.Claim).Dropped = entry.Dropped
+	default:
+		return fmt.Errorf("unknown journal entry: %s", entry.Kind)
+	}
+	return nil
+}
+
+// memoryStorage keeps nothing. It is used when no data directory
+// is configured.
+type memoryStorage struct{}
+
+func (memoryStorage) AddShare(number uint64, s *share.Share) error    { return nil }
+func (memoryStorage) Sync() error                                     { return nil }
+func (memoryStorage) AddTransition(number uint64, t Transition) error { return nil }
+func (memoryStorage) Load() (*storedClaims, error)                    { return newStoredClaims(), nil }
+func (memoryStorage) Close() error                                    { return nil }
+
+type journalEntry struct {
+	Claim       uint64        `json:"claim"`
+	Kind        string        `json:"kind"`
+	Share       hexutil.Bytes `json:"share,omitempty"`
+	State       string        `json:"state,omitempty"`
+	TxHash      *common.Hash  `json:"tx,omitempty"`
+	BlockNumber uint64        `json:"block,omitempty"`
+	BlockHash   *common.Hash  `json:"blockHash,omitempty"`
+	Time        *time.Time    `json:"time,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Dropped     int           `json:"dropped,omitempty"`
+}
+
+func shareEntry(number uint64, s *share.Share) (journalEntry, error) {
+	data, err := rlp.EncodeToBytes(s)
+	if err != nil {
+		return journalEntry{}, err
+	}
+	return journalEntry{Claim: number, Kind: eventShare, Share: data}, nil
+}
+
+func transitionEntry(number uint64, t Transition) journalEntry {
+	entry := journalEntry{
+		Claim:       number,
+		Kind:        eventState,
+		State:       t.State.String(),
+		BlockNumber: t.BlockNumber,
+		Time:        &t.Time,
+		Error:       t.Error,
+	}
+	if t.TxHash != (common.Hash{}) {
+		entry.TxHash = &t.TxHash
+	}
+	if t.BlockHash != (common.Hash{}) {
+		entry.BlockHash = &t.BlockHash
+	}
+	return entry
+}
+
+func (e journalEntry) transition() (Transition, error) {
+	state, err := ParseClaimState(e.State)
+	if err != nil {
+		return Transition{}, err
+	}
+	t := Transition{State: state, BlockNumber: e.BlockNumber, Error: e.Error}
+	if e.TxHash != nil {
+		t.TxHash = *e.TxHash
+	}
+	if e.BlockHash != nil {
+		t.BlockHash = *e.BlockHash
+	}
+	if e.Time != nil {
+		t.Time = *e.Time
+	}
+	return t, nil
+}
+
+// JournalStorage is an append-only journal of claim events, one json
+// entry per line. Transitions are synced to disk before the call
+// returns, shares once Sync returns, so a crash loses at most the
+// entries being written. Shares of claims done with them are dropped
+// from the journal when it is loaded.
+type JournalStorage struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	// number of entries written
+	written uint64
+
+	// syncMu serializes fsyncs so concurrent Sync calls share one,
+	// synced is the number of entries on disk
+	syncMu sync.Mutex
+	synced uint64
+}
+
+func OpenJournalStorage(path string) (*JournalStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &JournalStorage{path: path, file: f}, nil
+}
+
+var errJournalClosed = errors.New("claim journal is closed")
+
+func (js *JournalStorage) write(entry journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.file == nil {
+		return errJournalClosed
+	}
+	if _, err = js.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	js.written++
+	return nil
+}
+
+// Sync syncs the entries written so far. A call waiting for another
+// one to finish returns without syncing again if that one covered its
+// entries, so shares added together share an fsync.
+func (js *JournalStorage) Sync() error {
+	js.syncMu.Lock()
+	defer js.syncMu.Unlock()
+	js.mu.Lock()
+	f, written := js.file, js.written
+	js.mu.Unlock()
+	if written <= js.synced {
+		return nil
+	}
+	if f == nil {
+		return errJournalClosed
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	js.synced = written
+	return nil
+}
+
+func (js *JournalStorage) AddShare(number uint64, s *share.Share) error {
+	entry, err := shareEntry(number, s)
+	if err != nil {
+		return err
+	}
+	return js.write(entry)
+}
+
+func (js *JournalStorage) AddTransition(number uint64, t Transition) error {
+	if err := js.write(transitionEntry(number, t)); err != nil {
+		return err
+	}
+	return js.Sync()
+}
+
+// replayJournal reads the journal at path. good is the length of the
+// complete entries, torn is set when the last line is incomplete.
+func replayJournal(f *os.File, path string) (result *storedClaims, good int64, torn bool, err error) {
+	result = newStoredClaims()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	var pending error
+	for scanner.Scan() {
+		line++
+		if pending != nil {
+			// a bad line followed by more entries is corruption,
+			// not a torn write
+			return nil, 0, false, pending
+		}
+		entry := journalEntry{}
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			pending = fmt.Errorf("claim journal %s line %d: %s", path, line, err)
+			continue
+		}
+		if err = result.apply(entry); err != nil {
+			return nil, 0, false, fmt.Errorf("claim journal %s line %d: %s", path, line, err)
+		}
+		good += int64(len(scanner.Bytes())) + 1
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, 0, false, err
+	}
+	return result, good, pending != nil, nil
+}
+
+// Load replays the journal from the beginning. A partially written
+// last line, left by a crash in the middle of a write, is cut off so
+// new entries are appended after the last complete one. The journal is
+// then compacted if it has shares of claims done with them.
+func (js *JournalStorage) Load() (*storedClaims, error) {
+	f, err := os.Open(js.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	result, good, torn, err := replayJournal(f, js.path)
+	if err != nil {
+		return nil, err
+	}
+	if torn {
+		fmt.Printf("Warning: dropping incomplete last entry of claim journal %s\n", js.path)
+		if err = js.fixTail(good, true); err != nil {
+			return nil, err
+		}
+	} else if info, err := f.Stat(); err == nil && info.Size() < good {
+		// last entry is complete but its newline didn't make it
+		if err = js.fixTail(good, false); err != nil {
+			return nil, err
+		}
+	}
+	if compactable(result) {
+		if err = js.compact(result); err != nil {
+			// the journal is still whole, only bigger than needed
+			fmt.Printf("Warning: couldn't compact claim journal %s: %s\n", js.path, err)
+		}
+	}
+	return result, nil
+}
+
+// fixTail cuts the journal to the good length of its complete entries
+// if the last one is torn, or adds the newline the last entry misses.
+func (js *JournalStorage) fixTail(good int64, torn bool) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.file == nil {
+		return nil
+	}
+	if torn {
+		return js.file.Truncate(good)
+	}
+	_, err := js.file.Write([]byte{'\n'})
+	return err
+}
+
+// compactable tells whether stored has shares the journal can drop.
+func compactable(stored *storedClaims) bool {
+	for _, r := range stored.claims {
+		if !r.State().keepsShares() && len(r.Shares) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// compact rewrites the journal with only what is needed to rebuild
+// stored. Claims done with their shares keep their history and the
+// number of shares they dropped. The new journal replaces the old one
+// once it is on disk. It is called by Load before the journal is used
+// by a repo.
+func (js *JournalStorage) compact(stored *storedClaims) error {
+	records := []*ClaimRecord{}
+	for _, r := range stored.claims {
+		records = append(records, r)
+	}
+	sort.Sort(byNumber(records))
+	tmp := js.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	written := 0
+	for _, r := range records {
+		entries := []journalEntry{}
+		if r.State().keepsShares() {
+			for _, s := range r.Shares {
+				entry, err := shareEntry(r.Number, s)
+				if err != nil {
+					f.Close()
+					return err
+				}
+				entries = append(entries, entry)
+			}
+		}
+		// the first transition is the open state every claim starts in
+		for _, t := range r.History[1:] {
+			entries = append(entries, transitionEntry(r.Number, t))
+		}
+		if r.Dropped > 0 {
+			entries = append(entries, journalEntry{Claim: r.Number, Kind: eventDropped, Dropped: r.Dropped})
+		}
+		for _, entry := range entries {
+			if err = encoder.Encode(entry); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		written += len(entries)
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.file == nil {
+		return errJournalClosed
+	}
+	if err = os.Rename(tmp, js.path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(js.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	// entries are appended to the new journal from now on
+	file, err := os.OpenFile(js.path, os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	js.file.Close()
+	js.file = file
+	fmt.Printf("Compacted claim journal %s to %d entries\n", js.path, written)
+	return nil
+}
+
+// ReadClaimRecords reads the claims recorded in the journal of dataDir
+// without modifying it, so it can be used while the client is running.
+func ReadClaimRecords(dataDir string) ([]*ClaimRecord, error) {
+	path := filepath.Join(dataDir, journalFile)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []*ClaimRecord{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stored, _, _, err := replayJournal(f, path)
+	if err != nil {
+		return nil, err
+	}
+	result := []*ClaimRecord{}
+	for _, r := range stored.claims {
+		result = append(result, r)
+	}
+	sort.Sort(byNumber(result))
+	return result, nil
+}
+
+func (js *JournalStorage) Close() error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.file == nil {
+		return nil
+	}
+	err := js.file.Close()
+	js.file = nil
+	return err
+}
@@ -0,0 +1,203 @@
+package claim
+
+import (
+	spcommon "../common"
+	"../share"
+	"github.com/ethereum/go-ethereum/common"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestJournal opens a journal in a new directory, the caller
+// removes the directory.
+func openTestJournal(t *testing.T) (*JournalStorage, string) {
+	dir, err := ioutil.TempDir("", "claims")
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := OpenJournalStorage(filepath.Join(dir, journalFile))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return js, dir
+}
+
+func testShare(i int) *share.Share {
+	work := testWork(i)
+	return share.NewShare(work.BlockHeader(), work.ShareDifficulty())
+}
+
+func reloadJournal(t *testing.T, js *JournalStorage) (*JournalStorage, *storedClaims) {
+	if err := js.Close(); err != nil {
+		t.Fatal(err)
+	}
+	js, err := OpenJournalStorage(js.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := js.Load()
+	if err != nil {
+		js.Close()
+		t.Fatal(err)
+	}
+	return js, stored
+}
+
+func appendToJournal(t *testing.T, path string, data string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	js, dir := openTestJournal(t)
+	defer os.RemoveAll(dir)
+	for i := 0; i < 2; i++ {
+		if err := js.AddShare(0, testShare(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	submitted := Transition{State: ClaimSubmitted, TxHash: common.HexToHash("0x1"), Time: time.Now()}
+	for _, tr := range []Transition{{State: ClaimSealed, Time: time.Now()}, submitted} {
+		if err := js.AddTransition(0, tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := js.AddShare(1, testShare(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	js, stored := reloadJournal(t, js)
+	defer js.Close()
+	if stored.current != 1 {
+		t.Errorf("expected current claim 1, got %d", stored.current)
+	}
+	r := stored.claims[0]
+	if r.State() != ClaimSubmitted || len(r.Shares) != 2 || r.Current().TxHash != submitted.TxHash {
+		t.Errorf("expected claim 0 submitted in tx %x with 2 shares, got %s in tx %x with %d shares",
+			submitted.TxHash, r.State(), r.Current().TxHash, len(r.Shares))
+	}
+	if r := stored.claims[1]; r.State() != ClaimOpen || len(r.Shares) != 1 {
+		t.Errorf("expected claim 1 open with 1 share, got %s with %d shares", r.State(), len(r.Shares))
+	}
+}
+
+// A crash in the middle of a write leaves a torn last line. It is cut
+// off so the entries written after it are read back.
+func TestJournalDropsTornLastEntry(t *testing.T) {
+	js, dir := openTestJournal(t)
+	defer os.RemoveAll(dir)
+	if err := js.AddShare(0, testShare(0)); err != nil {
+		t.Fatal(err)
+	}
+	appendToJournal(t, js.path, `{"claim":0,"kind":"sha`)
+	js, stored := reloadJournal(t, js)
+	if len(stored.claims[0].Shares) != 1 {
+		t.Fatalf("expected the complete share replayed, got %d shares", len(stored.claims[0].Shares))
+	}
+	if err := js.AddShare(0, testShare(1)); err != nil {
+		t.Fatal(err)
+	}
+	js, stored = reloadJournal(t, js)
+	defer js.Close()
+	if len(stored.claims[0].Shares) != 2 {
+		t.Errorf("expected the share added after the torn entry replayed, got %d shares", len(stored.claims[0].Shares))
+	}
+}
+
+// A bad entry followed by good ones is not a torn write, the journal
+// is not loaded rather than losing the entries after it.
+func TestJournalRejectsCorruptEntry(t *testing.T) {
+	js, dir := openTestJournal(t)
+	defer os.RemoveAll(dir)
+	defer js.Close()
+	if err := js.AddShare(0, testShare(0)); err != nil {
+		t.Fatal(err)
+	}
+	appendToJournal(t, js.path, "{not json}\n")
+	if err := js.AddShare(0, testShare(1)); err != nil {
+		t.Fatal(err)
+	}
+	before, err := ioutil.ReadFile(js.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.Load(); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error at line 2, got %v", err)
+	}
+	if _, err := ReadClaimRecords(dir); err == nil {
+		t.Error("expected the corrupt journal not to be read")
+	}
+	after, err := ioutil.ReadFile(js.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Error("corrupt journal was modified")
+	}
+}
+
+// Shares of rejected claims are dropped from the journal when it is
+// loaded, their history and dropped shares are kept.
+func TestJournalCompactsFinishedClaims(t *testing.T) {
+	js, dir := openTestJournal(t)
+	defer os.RemoveAll(dir)
+	for i := 0; i < 3; i++ {
+		if err := js.AddShare(0, testShare(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a share collected before the share difficulty was changed
+	h := testWork(3).BlockHeader()
+	h.Extra = []byte(spcommon.ExtraData(common.Address{}, big.NewInt(200000)))
+	if err := js.AddShare(0, share.NewShare(h, big.NewInt(200000))); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []ClaimState{ClaimSealed, ClaimSubmitted, ClaimRejected} {
+		if err := js.AddTransition(0, Transition{State: s, Time: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := js.AddShare(1, testShare(4)); err != nil {
+		t.Fatal(err)
+	}
+	js, stored := reloadJournal(t, js)
+	if r := stored.claims[0]; r.Dropped != 1 {
+		t.Fatalf("expected claim 0 to drop 1 share, got %d", r.Dropped)
+	}
+	data, err := ioutil.ReadFile(js.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), `"kind":"share"`); n != 1 {
+		t.Errorf("expected only the share of claim 1 left in the journal, got %d shares", n)
+	}
+
+	// the compacted journal is appended to and replays the same claims
+	if err := js.AddShare(1, testShare(5)); err != nil {
+		t.Fatal(err)
+	}
+	js, stored = reloadJournal(t, js)
+	defer js.Close()
+	r := stored.claims[0]
+	if r.State() != ClaimRejected || len(r.History) != 4 || r.Dropped != 1 || len(r.Shares) != 0 {
+		t.Errorf("expected claim 0 rejected after 4 states with 1 dropped share, got %s after %d with %d dropped and %d shares",
+			r.State(), len(r.History), r.Dropped, len(r.Shares))
+	}
+	if r := stored.claims[1]; stored.current != 1 || len(r.Shares) != 2 {
+		t.Errorf("expected current claim 1 with 2 shares, got claim %d with %d shares", stored.current, len(r.Shares))
+	}
+}
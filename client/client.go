@@ -2,13 +2,14 @@ package client
 
 import (
 	spcommon "../common"
+	"../node"
 	"../params"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/rpc"
-	"log"
 	"math/big"
 	"time"
 )
@@ -33,16 +34,64 @@ type jsonHeader struct {
 
 var DefaultGethClient *GethClient
 
+var ErrNotSynced = errors.New("ethereum node is not synced")
+
+// UnavailableError is returned when the node couldn't be reached.
+type UnavailableError struct {
+	Method string
+	Err    error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("ethereum node is unavailable (%s): %s", e.Method, e.Err)
+}
+
+// MalformedResponseError is returned when the node answered with
+// something that is not what the method returns.
+type MalformedResponseError struct {
+	Method string
+	Reason string
+}
+
+func (e *MalformedResponseError) Error() string {
+	return fmt.Sprintf("malformed response to %s: %s", e.Method, e.Reason)
+}
+
+func IsUnavailable(err error) bool {
+	_, ok := err.(*UnavailableError)
+	return ok
+}
+
 type GethClient struct {
-	client *rpc.Client
+	node *node.Manager
 }
 
-func (g GethClient) GetPendingBlockHeader() *types.Header {
-	header := jsonHeader{}
-	err := g.client.Call(&header, "eth_getBlockByNumber", "pending", false)
-	if err != nil {
-		log.Fatal("Couldn't get latest block:", err)
-		return nil
+// call decodes the result itself so errors of the connection, of the
+// node and of its response can be told apart.
+func (g GethClient) call(result interface{}, method string, args ...interface{}) error {
+	var raw json.RawMessage
+	if err := g.node.Call(&raw, method, args...); err != nil {
+		if node.IsConnectionError(err) {
+			return &UnavailableError{method, err}
+		}
+		return err
+	}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return &MalformedResponseError{method, err.Error()}
+	}
+	return nil
+}
+
+func (g GethClient) GetPendingBlockHeader() (*types.Header, error) {
+	var header *jsonHeader
+	if err := g.call(&header, "eth_getBlockByNumber", "pending", false); err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, &MalformedResponseError{"eth_getBlockByNumber", "no pending block"}
+	}
+	if missing := header.missingField(); missing != "" {
+		return nil, &MalformedResponseError{"eth_getBlockByNumber", "missing " + missing}
 	}
 	result := types.Header{}
 	result.ParentHash = *header.ParentHash
@@ -65,66 +114,212 @@ func (g GethClient) GetPendingBlockHeader() *types.Header {
 	}
 	result.MixDigest = *header.MixDigest
 	result.Nonce = types.BlockNonce{}
-	return &result
+	return &result, nil
 }
 
-func (g GethClient) GetBlockHeader(number int) *types.Header {
-	header := types.Header{}
-	err := g.client.Call(&header, "eth_getBlockByNumber", number, false)
-	if err != nil {
-		log.Fatal("Couldn't get latest block:", err)
-		return nil
+// missingField returns the name of a field the pending block header
+// needs but the node didn't send.
+func (h *jsonHeader) missingField() string {
+	switch {
+	case h.ParentHash == nil:
+		return "parentHash"
+	case h.UncleHash == nil:
+		return "sha3Uncles"
+	case h.Root == nil:
+		return "stateRoot"
+	case h.TxHash == nil:
+		return "transactionsRoot"
+	case h.ReceiptHash == nil:
+		return "receiptsRoot"
+	case h.Difficulty == nil:
+		return "difficulty"
+	case h.Number == nil:
+		return "number"
+	case h.GasLimit == nil:
+		return "gasLimit"
+	case h.GasUsed == nil:
+		return "gasUsed"
+	case h.Time == nil:
+		return "timestamp"
+	case h.MixDigest == nil:
+		return "mixHash"
+	}
+	return ""
+}
+
+func (g GethClient) GetBlockHeader(number int) (*types.Header, error) {
+	var header *types.Header
+	if err := g.call(&header, "eth_getBlockByNumber", hexutil.Uint64(number), false); err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, &MalformedResponseError{"eth_getBlockByNumber", fmt.Sprintf("block %d not found", number)}
 	}
-	return &header
+	return header, nil
 }
 
 type gethWork [3]string
 
 func (w gethWork) PoWHash() string { return w[0] }
 
-func (g GethClient) GetWork() *spcommon.Work {
+var ErrInconsistentWork = errors.New("pending block header doesn't match eth_getWork")
+
+// FetchWork returns the work geth is mining on together with its
+// pending block header. It fails with ErrInconsistentWork when geth
+// switches to a new pending block between the two calls and with
+// ErrNotSynced when geth has no work because it is still syncing.
+func (g GethClient) FetchWork() (*spcommon.Work, error) {
 	w := gethWork{}
-	var h *types.Header
-	for {
-		h = g.GetPendingBlockHeader()
-		g.client.Call(&w, "eth_getWork")
-		// waiting for pending block to be the same as
-		// block we are going to pass to miner
-		if w.PoWHash() != "" && w.PoWHash() == h.HashNoNonce().Hex() {
-			break
+	h, err := g.GetPendingBlockHeader()
+	if err != nil {
+		return nil, err
+	}
+	if err := g.call(&w, "eth_getWork"); err != nil {
+		if IsUnavailable(err) {
+			return nil, err
+		}
+		if syncing, serr := g.Syncing(); serr == nil && syncing {
+			return nil, ErrNotSynced
+		}
+		return nil, err
+	}
+	if w.PoWHash() == "" || w.PoWHash() != h.HashNoNonce().Hex() {
+		return nil, ErrInconsistentWork
+	}
+	return spcommon.NewWork(h, w[0], w[1], params.ShareDifficulty), nil
+}
+
+// GetWork retries FetchWork while the work is inconsistent. Long
+// running components should use a WorkFeed instead.
+func (g GethClient) GetWork() (*spcommon.Work, error) {
+	var err error
+	for i := 0; i < workFetchAttempts; i++ {
+		var w *spcommon.Work
+		if w, err = g.FetchWork(); err != ErrInconsistentWork {
+			return w, err
 		}
-		time.Sleep(1000 * time.Millisecond)
-		fmt.Printf("Get inconsistent pending block header. Retry in 1s...\n")
+		time.Sleep(workRetryDelay)
+	}
+	return nil, err
+}
+
+func (g GethClient) GetBlockNumber() (uint64, error) {
+	var result hexutil.Uint64
+	err := g.call(&result, "eth_blockNumber")
+	return uint64(result), err
+}
+
+// Syncing tells whether the node is still catching up with the chain.
+func (g GethClient) Syncing() (bool, error) {
+	// eth_syncing returns false or the sync progress
+	var result interface{}
+	if err := g.call(&result, "eth_syncing"); err != nil {
+		return false, err
 	}
-	return spcommon.NewWork(h, w[0], w[1])
+	syncing, ok := result.(bool)
+	return !ok || syncing, nil
 }
 
-func (g GethClient) SubmitHashrate(hashrate hexutil.Uint64, id common.Hash) bool {
+func (g GethClient) SubmitHashrate(hashrate hexutil.Uint64, id common.Hash) (bool, error) {
 	var result bool
-	g.client.Call(&result, "eth_submitHashrate", hashrate, id)
-	return result
+	err := g.call(&result, "eth_submitHashrate", hashrate, id)
+	return result, err
 }
 
-func (g GethClient) SubmitWork(nonce types.BlockNonce, hash, mixDigest common.Hash) bool {
+func (g GethClient) SubmitWork(nonce types.BlockNonce, hash, mixDigest common.Hash) (bool, error) {
 	var result bool
-	g.client.Call(&result, "eth_submitWork", nonce, hash, mixDigest)
-	return result
+	err := g.call(&result, "eth_submitWork", nonce, hash, mixDigest)
+	return result, err
 }
 
 type jsonTransaction struct {
-	BlockHash string `json:"blockHash"`
+	BlockHash   *common.Hash    `json:"blockHash"`
+	BlockNumber *hexutil.Big    `json:"blockNumber"`
+	Gas         *hexutil.Uint64 `json:"gas"`
 }
 
-func (g GethClient) IsVerified(h common.Hash) bool {
-	result := jsonTransaction{}
-	g.client.Call(&result, "eth_getTransactionByHash", h)
-	return result.BlockHash != "0x0000000000000000000000000000000000000000000000000000000000000000"
+// IsVerified tells whether the transaction is included in a block.
+// Unknown transactions are not verified.
+func (g GethClient) IsVerified(h common.Hash) (bool, error) {
+	number, err := g.GetTransactionBlockNumber(h)
+	return number != 0, err
 }
 
-func NewGethRPCClient() (*GethClient, error) {
-	client, err := rpc.Dial("http://127.0.0.1:8545")
-	if err != nil {
+// return number of the block including the transaction
+// or 0 if it is still pending
+func (g GethClient) GetTransactionBlockNumber(h common.Hash) (uint64, error) {
+	var result *jsonTransaction
+	if err := g.call(&result, "eth_getTransactionByHash", h); err != nil {
+		return 0, err
+	}
+	if result == nil || result.BlockHash == nil || *result.BlockHash == (common.Hash{}) || result.BlockNumber == nil {
+		return 0, nil
+	}
+	return (*big.Int)(result.BlockNumber).Uint64(), nil
+}
+
+// IsPending tells whether the transaction waits in the pool of the
+// node. Transactions the node doesn't know are not pending.
+func (g GethClient) IsPending(h common.Hash) (bool, error) {
+	var result *jsonTransaction
+	if err := g.call(&result, "eth_getTransactionByHash", h); err != nil {
+		return false, err
+	}
+	return result != nil && (result.BlockHash == nil || *result.BlockHash == (common.Hash{})), nil
+}
+
+// GetTransactionGas returns the gas limit of the transaction.
+func (g GethClient) GetTransactionGas(h common.Hash) (uint64, error) {
+	var result *jsonTransaction
+	if err := g.call(&result, "eth_getTransactionByHash", h); err != nil {
+		return 0, err
+	}
+	if result == nil || result.Gas == nil {
+		return 0, &MalformedResponseError{"eth_getTransactionByHash", "no gas of transaction " + h.Hex()}
+	}
+	return uint64(*result.Gas), nil
+}
+
+// TxReceipt is the part of a transaction receipt the client needs.
+// Status is nil for blocks before Byzantium.
+type TxReceipt struct {
+	BlockHash   common.Hash
+	BlockNumber uint64
+	GasUsed     uint64
+	Status      *uint64
+}
+
+type jsonReceipt struct {
+	BlockHash   *common.Hash    `json:"blockHash"`
+	BlockNumber *hexutil.Uint64 `json:"blockNumber"`
+	GasUsed     *hexutil.Uint64 `json:"gasUsed"`
+	Status      *hexutil.Uint64 `json:"status"`
+}
+
+// GetTransactionReceipt returns nil while the transaction is pending.
+func (g GethClient) GetTransactionReceipt(h common.Hash) (*TxReceipt, error) {
+	var result *jsonReceipt
+	if err := g.call(&result, "eth_getTransactionReceipt", h); err != nil {
 		return nil, err
 	}
-	return &GethClient{client}, nil
+	if result == nil || result.BlockHash == nil {
+		return nil, nil
+	}
+	if result.BlockNumber == nil || result.GasUsed == nil {
+		return nil, &MalformedResponseError{"eth_getTransactionReceipt", "missing blockNumber or gasUsed"}
+	}
+	receipt := &TxReceipt{
+		BlockHash:   *result.BlockHash,
+		BlockNumber: uint64(*result.BlockNumber),
+		GasUsed:     uint64(*result.GasUsed),
+	}
+	if result.Status != nil {
+		status := uint64(*result.Status)
+		receipt.Status = &status
+	}
+	return receipt, nil
+}
+
+func NewGethRPCClient(m *node.Manager) *GethClient {
+	return &GethClient{m}
 }
@@ -0,0 +1,228 @@
+package client
+
+import (
+	spcommon "../common"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	workRetryDelay    = 100 * time.Millisecond
+	workFetchAttempts = 20
+	blockPollInterval = 500 * time.Millisecond
+	// how long to poll before trying to subscribe again, the node
+	// may have been switched to one supporting subscriptions
+	subscribeRetryInterval = time.Minute
+)
+
+var DefaultWorkFeed *WorkFeed
+
+// WorkFeed keeps the current work, refreshed once per block, and
+// pushes every new work to its subscribers. New blocks are taken from
+// a newHeads subscription when the node connection supports it and
+// from polling eth_blockNumber otherwise.
+type WorkFeed struct {
+	client *GethClient
+
+	mu      sync.Mutex
+	current *spcommon.Work
+	// why the work of the latest block couldn't be fetched, current is
+	// outdated then
+	err   error
+	ready chan struct{}
+	subs  map[chan *spcommon.Work]bool
+
+	// closed by Stop, done is closed when loop returns
+	quit     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+var ErrNoWork = errors.New("no work fetched from the node yet")
+
+func NewWorkFeed(g *GethClient) *WorkFeed {
+	return &WorkFeed{
+		client: g,
+		ready:  make(chan struct{}),
+		subs:   map[chan *spcommon.Work]bool{},
+		quit:   make(chan struct{}),
+	}
+}
+
+func (f *WorkFeed) Start() {
+	f.done = make(chan struct{})
+	go f.loop()
+}
+
+// Stop ends the refreshes of a started feed and waits for them to
+// return. Subscribers don't get new works anymore.
+func (f *WorkFeed) Stop() {
+	f.stopOnce.Do(func() { close(f.quit) })
+	if f.done != nil {
+		<-f.done
+	}
+}
+
+func (f *WorkFeed) stopped() bool {
+	select {
+	case <-f.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Current returns the latest work. It waits up to timeout for the
+// first work. It fails with the error of the last refresh, e.g.
+// ErrNotSynced, when the work of the latest block couldn't be fetched.
+func (f *WorkFeed) Current(timeout time.Duration) (*spcommon.Work, error) {
+	select {
+	case <-f.ready:
+	case <-time.After(timeout):
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.current == nil {
+		return nil, ErrNoWork
+	}
+	return f.current, nil
+}
+
+// Subscribe returns a channel receiving every new work. Slow
+// subscribers only get the latest one. The returned function
+// cancels the subscription.
+func (f *WorkFeed) Subscribe() (<-chan *spcommon.Work, func()) {
+	ch := make(chan *spcommon.Work, 1)
+	f.mu.Lock()
+	f.subs[ch] = true
+	f.mu.Unlock()
+	return ch, func() {
+		f.mu.Lock()
+		delete(f.subs, ch)
+		f.mu.Unlock()
+	}
+}
+
+func (f *WorkFeed) publish(w *spcommon.Work) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = nil
+	if f.current != nil && f.current.PoWHash() == w.PoWHash() {
+		return
+	}
+	if f.current == nil {
+		close(f.ready)
+	}
+	f.current = w
+	for ch := range f.subs {
+		// drop the work the subscriber hasn't picked up yet
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- w
+	}
+}
+
+// refresh fetches the work of a new block. Geth may take a moment to
+// prepare the pending block so inconsistent works are retried.
+func (f *WorkFeed) refresh() bool {
+	var err error
+	for i := 0; i < workFetchAttempts; i++ {
+		var w *spcommon.Work
+		if w, err = f.client.FetchWork(); err == nil {
+			f.publish(w)
+			return true
+		}
+		time.Sleep(workRetryDelay)
+	}
+	fmt.Printf("Couldn't refresh work: %s\n", err)
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+	return false
+}
+
+type headNotification struct {
+	Number *hexutil.Big `json:"number"`
+}
+
+func (f *WorkFeed) loop() {
+	defer close(f.done)
+	// the node that can't subscribe, it is only reported once
+	var unsupported string
+	for !f.stopped() {
+		err := f.follow()
+		switch {
+		case err == nil:
+		case subscriptionUnsupported(err):
+			if endpoint := f.client.node.Endpoint(); endpoint != unsupported {
+				fmt.Printf("%s doesn't support subscriptions. Polling for new blocks instead.\n", endpoint)
+				unsupported = endpoint
+			}
+		default:
+			fmt.Printf("New block subscription failed (%s). Polling for new blocks instead.\n", err)
+		}
+		f.poll(time.Now().Add(subscribeRetryInterval))
+	}
+}
+
+// subscriptionUnsupported tells whether err comes from a connection
+// or a node that can't subscribe at all, e.g. over HTTP, rather than
+// from a subscription that failed.
+func subscriptionUnsupported(err error) bool {
+	if err == rpc.ErrNotificationsUnsupported {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "notifications not supported") ||
+		strings.Contains(msg, "eth_subscribe does not exist")
+}
+
+// follow refreshes the work on every new head until the subscription
+// fails.
+func (f *WorkFeed) follow() error {
+	heads := make(chan *headNotification, 16)
+	c, _ := f.client.node.Client()
+	sub, err := c.EthSubscribe(context.Background(), heads, "newHeads")
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	f.refresh()
+	for {
+		select {
+		case <-heads:
+			f.refresh()
+		case err := <-sub.Err():
+			return err
+		case <-f.quit:
+			return nil
+		}
+	}
+}
+
+func (f *WorkFeed) poll(until time.Time) {
+	var last uint64
+	for time.Now().Before(until) && !f.stopped() {
+		number, err := f.client.GetBlockNumber()
+		// retry on the next poll if the work of this block couldn't
+		// be fetched
+		if err == nil && number != last && f.refresh() {
+			last = number
+		}
+		select {
+		case <-time.After(blockPollInterval):
+		case <-f.quit:
+		}
+	}
+}
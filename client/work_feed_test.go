@@ -0,0 +1,203 @@
+package client
+
+import (
+	spcommon "../common"
+	"../node"
+	"../params"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testEth is a node mining on the pending block number.
+type testEth struct {
+	mu     sync.Mutex
+	number int64
+}
+
+func (e *testEth) header() *types.Header {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return &types.Header{
+		Coinbase:   common.HexToAddress(params.ContractAddress),
+		Difficulty: big.NewInt(1000),
+		Number:     big.NewInt(e.number),
+		GasLimit:   big.NewInt(4000000),
+		GasUsed:    big.NewInt(0),
+		Time:       big.NewInt(1500000000),
+		Extra:      []byte(params.ExtraData),
+	}
+}
+
+func (e *testEth) setNumber(number int64) {
+	e.mu.Lock()
+	e.number = number
+	e.mu.Unlock()
+}
+
+func (e *testEth) BlockNumber() hexutil.Uint64 { return hexutil.Uint64(e.header().Number.Uint64()) }
+func (e *testEth) Syncing() bool               { return false }
+
+func (e *testEth) GetBlockByNumber(number string, full bool) map[string]interface{} {
+	h := e.header()
+	return map[string]interface{}{
+		"parentHash":       h.ParentHash,
+		"sha3Uncles":       h.UncleHash,
+		"miner":            h.Coinbase,
+		"stateRoot":        h.Root,
+		"transactionsRoot": h.TxHash,
+		"receiptsRoot":     h.ReceiptHash,
+		"difficulty":       (*hexutil.Big)(h.Difficulty),
+		"number":           (*hexutil.Big)(h.Number),
+		"gasLimit":         (*hexutil.Big)(h.GasLimit),
+		"gasUsed":          (*hexutil.Big)(h.GasUsed),
+		"timestamp":        (*hexutil.Big)(h.Time),
+		"extraData":        hexutil.Bytes(h.Extra),
+		"mixHash":          h.MixDigest,
+	}
+}
+
+func (e *testEth) GetWork() [3]string {
+	return [3]string{e.header().HashNoNonce().Hex(), common.Hash{}.Hex(), common.Hash{}.Hex()}
+}
+
+// newTestFeed returns a feed, not started, on a node serving eth over
+// HTTP. The caller closes the server.
+func newTestFeed(t *testing.T, eth *testEth) (*WorkFeed, *httptest.Server) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", eth); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server)
+	m, err := node.NewManager([]string{ts.URL})
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return NewWorkFeed(NewGethRPCClient(m)), ts
+}
+
+func receiveWork(t *testing.T, ch <-chan *spcommon.Work) *spcommon.Work {
+	select {
+	case w := <-ch:
+		return w
+	case <-time.After(5 * time.Second):
+		t.Fatal("no work received")
+		return nil
+	}
+}
+
+func expectNoWork(t *testing.T, ch <-chan *spcommon.Work) {
+	select {
+	case w := <-ch:
+		t.Errorf("unexpected work of block %d", w.BlockHeader().Number)
+	default:
+	}
+}
+
+// Refreshing on a block that didn't change the work doesn't push it
+// again.
+func TestWorkFeedSkipsSameWork(t *testing.T) {
+	eth := &testEth{number: 1}
+	f, ts := newTestFeed(t, eth)
+	defer ts.Close()
+	works, cancel := f.Subscribe()
+	defer cancel()
+	if !f.refresh() {
+		t.Fatal("couldn't fetch work")
+	}
+	first := receiveWork(t, works)
+	if !f.refresh() {
+		t.Fatal("couldn't fetch work")
+	}
+	expectNoWork(t, works)
+
+	eth.setNumber(2)
+	if !f.refresh() {
+		t.Fatal("couldn't fetch work")
+	}
+	if w := receiveWork(t, works); w.PoWHash() == first.PoWHash() || w.BlockHeader().Number.Int64() != 2 {
+		t.Errorf("expected the work of block 2, got block %d", w.BlockHeader().Number)
+	}
+	if w, err := f.Current(0); err != nil || w.BlockHeader().Number.Int64() != 2 {
+		t.Errorf("expected the current work of block 2, got %v, %v", w, err)
+	}
+}
+
+func TestWorkFeedFansOutToSubscribers(t *testing.T) {
+	eth := &testEth{number: 1}
+	f, ts := newTestFeed(t, eth)
+	defer ts.Close()
+	first, cancelFirst := f.Subscribe()
+	defer cancelFirst()
+	second, cancelSecond := f.Subscribe()
+	cancelled, cancel := f.Subscribe()
+	cancel()
+
+	// a slow subscriber only gets the latest work
+	for n := int64(1); n <= 3; n++ {
+		eth.setNumber(n)
+		if !f.refresh() {
+			t.Fatal("couldn't fetch work")
+		}
+		if w := receiveWork(t, first); w.BlockHeader().Number.Int64() != n {
+			t.Errorf("expected the work of block %d, got block %d", n, w.BlockHeader().Number)
+		}
+	}
+	if w := receiveWork(t, second); w.BlockHeader().Number.Int64() != 3 {
+		t.Errorf("expected the slow subscriber to get block 3, got block %d", w.BlockHeader().Number)
+	}
+	expectNoWork(t, second)
+	expectNoWork(t, cancelled)
+
+	cancelSecond()
+	eth.setNumber(4)
+	f.refresh()
+	receiveWork(t, first)
+	expectNoWork(t, second)
+}
+
+// Over HTTP the feed can't subscribe and polls for new blocks.
+func TestWorkFeedPollsWithoutSubscriptions(t *testing.T) {
+	eth := &testEth{number: 1}
+	f, ts := newTestFeed(t, eth)
+	defer ts.Close()
+	works, cancel := f.Subscribe()
+	defer cancel()
+	f.Start()
+	defer f.Stop()
+	receiveWork(t, works)
+	eth.setNumber(2)
+	if w := receiveWork(t, works); w.BlockHeader().Number.Int64() != 2 {
+		t.Errorf("expected the work of block 2, got block %d", w.BlockHeader().Number)
+	}
+}
+
+func TestSubscriptionUnsupported(t *testing.T) {
+	unsupported := []error{
+		rpc.ErrNotificationsUnsupported,
+		errors.New("notifications not supported"),
+		errors.New("the method eth_subscribe does not exist/is not available"),
+	}
+	for _, err := range unsupported {
+		if !subscriptionUnsupported(err) {
+			t.Errorf("expected %q to mean subscriptions are unsupported", err)
+		}
+	}
+	failed := []error{
+		errors.New("dial tcp 127.0.0.1:8546: connection refused"),
+		errors.New("subscription queue overflow"),
+	}
+	for _, err := range failed {
+		if subscriptionUnsupported(err) {
+			t.Errorf("expected %q to be a failed subscription", err)
+		}
+	}
+}
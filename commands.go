@@ -0,0 +1,529 @@
+package main
+
+import (
+	"./claim"
+	"./client"
+	spcommon "./common"
+	"./config"
+	"./contract"
+	"./dag"
+	"./node"
+	"./params"
+	"./server"
+	"./txs"
+	"flag"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const clientVersion = "0.1.0"
+
+// Exit codes of the commands.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// command is a subcommand of the client. flags registers the command
+// flags on fs and returns the function running it with the remaining
+// arguments. Commands that only read local data don't need the whole
+// config to be valid.
+type command struct {
+	name       string
+	args       string
+	usage      string
+	needConfig bool
+	flags      func(fs *flag.FlagSet) func(args []string) int
+}
+
+var commands = []command{
+	{"run", "", "run the pool client", true, runFlags},
+	{"register", "", "register the miner address to the pool", true, registerFlags},
+	{"epoch submit", "<block>", "submit the dag merkle root of the block's epoch", true, epochSubmitFlags},
+	{"epoch update", "", "keep the epoch data of the contract up to date", true, epochUpdateFlags},
+	{"epoch root", "<block>", "print the dag merkle root of the block's epoch", false, epochRootFlags},
+	{"claim list", "", "list the claims recorded in the data directory", false, claimListFlags},
+	{"claim prove", "<claim> <share index>", "submit the proof of a share of a claim", true, claimProveFlags},
+	{"extradata check", "", "check the extra data of the pending block with the contract", true, extraDataCheckFlags},
+	{"work show", "", "print the work geth gives to miners", true, workShowFlags},
+	{"config show", "", "print the effective config and check it", false, configShowFlags},
+	{"version", "", "print the client version", false, versionFlags},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] [args]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-36s %s\n", strings.TrimSpace(c.name+" "+c.args), c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '<command> -h' for the flags of a command.\n")
+}
+
+// findCommand returns the command named by the first words of args and
+// the arguments following its name.
+func findCommand(args []string) (*command, []string) {
+	for i := range commands {
+		words := strings.Fields(commands[i].name)
+		if len(args) < len(words) {
+			continue
+		}
+		if strings.Join(args[:len(words)], " ") == commands[i].name {
+			return &commands[i], args[len(words):]
+		}
+	}
+	return nil, nil
+}
+
+func main() {
+	cmd, args := findCommand(os.Args[1:])
+	if cmd == nil {
+		usage()
+		os.Exit(exitUsage)
+	}
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags] %s\n\n%s\n\nFlags:\n",
+			filepath.Base(os.Args[0]), cmd.name, cmd.args, cmd.usage)
+		fs.PrintDefaults()
+	}
+	load := config.Flags(fs)
+	run := cmd.flags(fs)
+	fs.Parse(args)
+	cfg, err := load()
+	if cfg == nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitUsage)
+	}
+	loadedConfig, configErr = cfg, err
+	if cmd.needConfig && !validConfig() {
+		os.Exit(exitUsage)
+	}
+	cfg.Apply()
+	os.Exit(run(fs.Args()))
+}
+
+var (
+	loadedConfig *config.Config
+	// why loadedConfig is invalid, commands that don't need a config
+	// run with it anyway
+	configErr error
+)
+
+// validConfig reports the problems of the config to commands that need
+// a valid one. Commands exit with exitUsage when it fails.
+func validConfig() bool {
+	if configErr != nil {
+		fmt.Fprintf(os.Stderr, "invalid config: %s\n", configErr)
+		return false
+	}
+	return true
+}
+
+// expectArgs checks the number of positional arguments.
+func expectArgs(args []string, n int) bool {
+	if len(args) != n {
+		fmt.Fprintf(os.Stderr, "expected %d arguments, got %d\n", n, len(args))
+		return false
+	}
+	return true
+}
+
+func parseBlockNumber(arg string) (uint64, bool) {
+	n, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid block number %q\n", arg)
+		return 0, false
+	}
+	return n, true
+}
+
+func runFlags(fs *flag.FlagSet) func([]string) int {
+	noStratum := fs.Bool("no-stratum", false, "don't start the stratum server")
+	updateEpochs := fs.Bool("update-epochs", false, "keep the epoch data of the contract up to date, the miner must be allowed to set it")
+	return func(args []string) int {
+		if !expectArgs(args, 0) {
+			return exitUsage
+		}
+		if !Initialize(needContract) {
+			return exitFailure
+		}
+		client.DefaultWorkFeed = client.NewWorkFeed(client.DefaultGethClient)
+		client.DefaultWorkFeed.Start()
+		server.DefaultServer = server.NewRPCServer()
+		server.DefaultStratumServer = server.NewStratumServer()
+		var err error
+		claim.DefaultClaimRepo, err = claim.LoadClaimRepo(contract.DefaultContractClient)
+		if err != nil {
+			fmt.Printf("Couldn't load claims from %s: %s\n", params.DataDir, err)
+			return exitFailure
+		}
+		// TODO: check current geth setup to see if coinbase address
+		// and extradata is set properly
+		if !registerToPool(common.HexToAddress(params.MinerAddress)) {
+			return exitFailure
+		}
+		if *updateEpochs && !startEpochUpdater(epochUpdateAhead, epochUpdateInterval) {
+			return exitFailure
+		}
+		if !*noStratum {
+			go server.DefaultStratumServer.Start()
+		}
+		server.DefaultServer.Start()
+		return exitFailure
+	}
+}
+
+func registerFlags(fs *flag.FlagSet) func([]string) int {
+	payment := fs.String("payment-address", "", "address the pool pays to, the miner address by default")
+	return func(args []string) int {
+		if !expectArgs(args, 0) {
+			return exitUsage
+		}
+		address := common.HexToAddress(params.MinerAddress)
+		if *payment != "" {
+			if !common.IsHexAddress(*payment) {
+				fmt.Fprintf(os.Stderr, "invalid payment address %q\n", *payment)
+				return exitUsage
+			}
+			address = common.HexToAddress(*payment)
+		}
+		if !Initialize(needContract) || !registerToPool(address) {
+			return exitFailure
+		}
+		return exitOK
+	}
+}
+
+// epochData builds the dag of the block's epoch if needed and returns
+// what the contract needs to verify shares of the epoch.
+func epochData(blockNumber uint64) (root *big.Int, fullSizeIn128Resolution uint64, branchDepth uint64, epoch *big.Int, err error) {
+	fmt.Printf("Block number: %d\n", blockNumber)
+	data, err := dag.EpochDataOf(blockNumber)
+	if err != nil {
+		return nil, 0, 0, nil, err
+	}
+	epoch = big.NewInt(int64(blockNumber) / 30000)
+	return data.MerkleRoot, data.FullSizeIn128Resolution, data.BranchDepth, epoch, nil
+}
+
+func epochRootFlags(fs *flag.FlagSet) func([]string) int {
+	return func(args []string) int {
+		if !expectArgs(args, 1) {
+			return exitUsage
+		}
+		blockNumber, ok := parseBlockNumber(args[0])
+		if !ok {
+			return exitUsage
+		}
+		root, fullSize, depth, epoch, err := epochData(blockNumber)
+		if err != nil {
+			fmt.Printf("Couldn't build the DAG: %s\n", err)
+			return exitFailure
+		}
+		fmt.Printf("Epoch: %s\n", epoch)
+		fmt.Printf("Dag Merkle Root: 0x%s\n", root.Text(16))
+		fmt.Printf("Full size in 128 bytes: %d\n", fullSize)
+		fmt.Printf("Branch depth: %d\n", depth)
+		return exitOK
+	}
+}
+
+func epochSubmitFlags(fs *flag.FlagSet) func([]string) int {
+	wait := fs.Bool("wait", true, "wait for the transaction to be mined")
+	return func(args []string) int {
+		if !expectArgs(args, 1) {
+			return exitUsage
+		}
+		blockNumber, ok := parseBlockNumber(args[0])
+		if !ok {
+			return exitUsage
+		}
+		if !Initialize(needGeth) {
+			return exitFailure
+		}
+		updaterClient, err := contract.NewUpdaterClient(node.DefaultManager)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			return exitFailure
+		}
+		root, fullSize, depth, epoch, err := epochData(blockNumber)
+		if err != nil {
+			fmt.Printf("Couldn't build the DAG: %s\n", err)
+			return exitFailure
+		}
+		tx, err := updaterClient.SetEpochData(root, fullSize, depth, epoch)
+		if err != nil {
+			fmt.Printf("Couldn't submit epoch data: %s\n", err)
+			return exitFailure
+		}
+		fmt.Printf("Transfer pending: 0x%x\n", tx.Hash())
+		if *wait {
+			return waitTx(tx)
+		}
+		return exitOK
+	}
+}
+
+const (
+	// blocks before the next epoch its dag is generated and its data
+	// submitted, generating a dag takes minutes
+	epochUpdateAhead    = 3000
+	epochUpdateInterval = time.Minute
+)
+
+func startEpochUpdater(ahead uint64, interval time.Duration) bool {
+	updaterClient, err := contract.NewUpdaterClient(node.DefaultManager)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return false
+	}
+	dag.DefaultEpochUpdater = dag.NewEpochUpdater(updaterClient, ahead)
+	dag.DefaultEpochUpdater.Start(interval)
+	return true
+}
+
+func epochUpdateFlags(fs *flag.FlagSet) func([]string) int {
+	ahead := fs.Uint64("ahead", epochUpdateAhead, "number of blocks before the next epoch to prepare it")
+	interval := fs.Duration("interval", epochUpdateInterval, "how often to check the chain head")
+	return func(args []string) int {
+		if !expectArgs(args, 0) {
+			return exitUsage
+		}
+		if *ahead >= 30000 {
+			fmt.Fprintf(os.Stderr, "ahead must be less than an epoch\n")
+			return exitUsage
+		}
+		if *interval <= 0 {
+			fmt.Fprintf(os.Stderr, "interval must be positive\n")
+			return exitUsage
+		}
+		if !Initialize(needGeth) {
+			return exitFailure
+		}
+		updaterClient, err := contract.NewUpdaterClient(node.DefaultManager)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			return exitFailure
+		}
+		dag.DefaultEpochUpdater = dag.NewEpochUpdater(updaterClient, *ahead)
+		dag.DefaultEpochUpdater.Run(*interval)
+		return exitFailure
+	}
+}
+
+// waitTx waits for tx to be confirmed and reports its outcome.
+func waitTx(tx *types.Transaction) int {
+	result, err := txs.NewTxWatcher(tx).WaitFor(params.TxTimeout)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return exitFailure
+	}
+	fmt.Printf("Tx 0x%x %s in block %d, gas used %d, %d confirmations\n",
+		result.Hash, result.Status, result.BlockNumber, result.GasUsed, result.Confirmations)
+	if !result.Succeeded() {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func claimListFlags(fs *flag.FlagSet) func([]string) int {
+	state := fs.String("state", "", "only list claims in this state")
+	return func(args []string) int {
+		if !expectArgs(args, 0) {
+			return exitUsage
+		}
+		var filter *claim.ClaimState
+		if *state != "" {
+			s, err := claim.ParseClaimState(*state)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				return exitUsage
+			}
+			filter = &s
+		}
+		records, err := claim.ReadClaimRecords(params.DataDir)
+		if err != nil {
+			fmt.Printf("Couldn't read claims from %s: %s\n", params.DataDir, err)
+			return exitFailure
+		}
+		for _, r := range records {
+			if filter == nil || r.State() == *filter {
+				r.PrintInfo()
+			}
+		}
+		return exitOK
+	}
+}
+
+func claimProveFlags(fs *flag.FlagSet) func([]string) int {
+	debug := fs.Bool("debug", false, "check the proof with eth_call instead of sending a transaction")
+	return func(args []string) int {
+		if !expectArgs(args, 2) {
+			return exitUsage
+		}
+		number, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid claim number %q\n", args[0])
+			return exitUsage
+		}
+		index, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid share index %q\n", args[1])
+			return exitUsage
+		}
+		records, err := claim.ReadClaimRecords(params.DataDir)
+		if err != nil {
+			fmt.Printf("Couldn't read claims from %s: %s\n", params.DataDir, err)
+			return exitFailure
+		}
+		var record *claim.ClaimRecord
+		for _, r := range records {
+			if r.Number == number {
+				record = r
+			}
+		}
+		if record == nil || len(record.Shares) == 0 {
+			fmt.Printf("Claim %d has no shares to prove\n", number)
+			return exitFailure
+		}
+		if !Initialize(needContract) {
+			return exitFailure
+		}
+		if *debug {
+			result, err := record.Shares.SubmitProof_debug(contract.DefaultContractClient, index)
+			if err != nil {
+				fmt.Printf("Couldn't verify claim %d: %s\n", number, err)
+				return exitFailure
+			}
+			fmt.Printf("Verification result: %s\n", result)
+			return exitOK
+		}
+		tx, err := record.Shares.SubmitProof(contract.DefaultContractClient, index)
+		if err != nil {
+			fmt.Printf("Couldn't submit proof of claim %d: %s\n", number, err)
+			return exitFailure
+		}
+		fmt.Printf("Proof pending: 0x%x\n", tx.Hash())
+		return waitTx(tx)
+	}
+}
+
+func extraDataCheckFlags(fs *flag.FlagSet) func([]string) int {
+	return func(args []string) int {
+		if !expectArgs(args, 0) {
+			return exitUsage
+		}
+		if !Initialize(needGeth) {
+			return exitFailure
+		}
+		updaterClient, err := contract.NewUpdaterClient(node.DefaultManager)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			return exitFailure
+		}
+		pendingBlock, err := client.DefaultGethClient.GetPendingBlockHeader()
+		if err != nil {
+			fmt.Printf("Couldn't get pending block: %s\n", err)
+			return exitFailure
+		}
+		diff := params.ShareDifficulty
+		minerAddress := common.HexToAddress(params.MinerAddress)
+		base := big.NewInt(0)
+		base.Exp(big.NewInt(62), big.NewInt(11), nil)
+		id := big.NewInt(0)
+		id.Mod(minerAddress.Big(), base)
+		encodedID := spcommon.BigToBase62(id)
+		extra32 := [32]byte{}
+		id32 := [32]byte{}
+		copy(extra32[:], pendingBlock.Extra[:])
+		copy(id32[21:], []byte(encodedID)[:])
+		fmt.Printf("Extra data of pending block: %s\n", string(pendingBlock.Extra))
+		fmt.Printf("Expected extra data:         %s\n", params.ExtraData)
+		ok, err := updaterClient.VerifyExtraData(extra32, id32, diff)
+		if err != nil {
+			fmt.Printf("Couldn't check extra data: %s\n", err)
+			return exitFailure
+		}
+		if !ok {
+			fmt.Printf("Extra data is not accepted by the contract\n")
+			return exitFailure
+		}
+		fmt.Printf("Extra data is accepted by the contract\n")
+		return exitOK
+	}
+}
+
+func workShowFlags(fs *flag.FlagSet) func([]string) int {
+	header := fs.Bool("header", false, "print the block header of the work too")
+	return func(args []string) int {
+		if !expectArgs(args, 0) {
+			return exitUsage
+		}
+		if !Initialize(needGeth) {
+			return exitFailure
+		}
+		w, err := client.DefaultGethClient.FetchWork()
+		if err != nil {
+			fmt.Printf("Couldn't get work: %s\n", err)
+			return exitFailure
+		}
+		if *header {
+			w.PrintInfo()
+			return exitOK
+		}
+		fmt.Printf("Pow hash:    %s\n", w.PoWHash().Hex())
+		fmt.Printf("Seed hash:   %s\n", w.SeedHash())
+		fmt.Printf("Block:       %d\n", w.BlockHeader().Number.Uint64())
+		fmt.Printf("Difficulty:  %s\n", w.BlockHeader().Difficulty)
+		return exitOK
+	}
+}
+
+func configShowFlags(fs *flag.FlagSet) func([]string) int {
+	return func(args []string) int {
+		if !expectArgs(args, 0) {
+			return exitUsage
+		}
+		// show what was loaded even if it is invalid
+		if err := loadedConfig.Show(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return exitFailure
+		}
+		if !validConfig() {
+			return exitUsage
+		}
+		return exitOK
+	}
+}
+
+func versionFlags(fs *flag.FlagSet) func([]string) int {
+	pool := fs.Bool("contract", false, "print the version of the pool contract too")
+	return func(args []string) int {
+		if !expectArgs(args, 0) {
+			return exitUsage
+		}
+		fmt.Printf("SmartPool client version: %s\n", clientVersion)
+		if *pool {
+			if !validConfig() {
+				return exitUsage
+			}
+			if !Initialize(needGeth) {
+				return exitFailure
+			}
+			v, err := contract.PoolVersion(node.DefaultManager)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to retrieve pool version: %s\n", err)
+				return exitFailure
+			}
+			fmt.Printf("SmartPool version: %s\n", v)
+		}
+		return exitOK
+	}
+}
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+)
+
+const extraDataPrefix = "SmartPool-"
+
+// ExtraData returns the extra data of the blocks mined for address with
+// shares of difficulty diff. The contract credits every share of a
+// claim with the difficulty found in it.
+func ExtraData(address common.Address, diff *big.Int) string {
+	// TODO: get default address from local environment
+	// id = address % (26+26+10)**11
+	base := big.NewInt(0)
+	base.Exp(big.NewInt(62), big.NewInt(11), nil)
+	id := big.NewInt(0)
+	id.Mod(address.Big(), base)
+	return fmt.Sprintf("%s%s%s", extraDataPrefix, BigToBase62(id), BigToBase62(diff))
+}
+
+var errNotPoolExtraData = errors.New("extra data is not the pool's")
+
+// ExtraDataDifficulty returns the share difficulty extra was built
+// for by ExtraData.
+func ExtraDataDifficulty(extra []byte) (*big.Int, error) {
+	if len(extra) != len(extraDataPrefix)+22 || string(extra[:len(extraDataPrefix)]) != extraDataPrefix {
+		return nil, errNotPoolExtraData
+	}
+	return Base62ToBig(string(extra[len(extraDataPrefix)+11:]))
+}
@@ -0,0 +1,27 @@
+package common
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"testing"
+)
+
+func TestExtraDataDifficulty(t *testing.T) {
+	address := common.HexToAddress("0x9e7a1925fa43d5f47b36e2e27f84adae95ddd845")
+	for _, diff := range []int64{1, 61, 62, 100000, 1 << 40} {
+		extra := ExtraData(address, big.NewInt(diff))
+		if len(extra) != 32 {
+			t.Errorf("extra data %s doesn't fit 32 bytes", extra)
+		}
+		got, err := ExtraDataDifficulty([]byte(extra))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Int64() != diff {
+			t.Errorf("expected difficulty %d in %s, got %s", diff, extra, got)
+		}
+	}
+	if _, err := ExtraDataDifficulty([]byte("geth")); err == nil {
+		t.Errorf("expected foreign extra data refused")
+	}
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"fmt"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"math/big"
 )
@@ -88,6 +89,29 @@ func BigToBase62(num *big.Int) string {
 	return base62DigitsToString(digits)
 }
 
+// Base62ToBig parses the representation of BigToBase62.
+func Base62ToBig(s string) (*big.Int, error) {
+	result := big.NewInt(0)
+	base := big.NewInt(62)
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		var digit int64
+		switch {
+		case '0' <= c && c <= '9':
+			digit = int64(c - '0')
+		case 'a' <= c && c <= 'z':
+			digit = int64(c-'a') + 10
+		case 'A' <= c && c <= 'Z':
+			digit = int64(c-'A') + 36
+		default:
+			return nil, fmt.Errorf("invalid base 62 digit %q", c)
+		}
+		result.Mul(result, base)
+		result.Add(result, big.NewInt(digit))
+	}
+	return result, nil
+}
+
 func (h SPHash) Str() string   { return string(h[:]) }
 func (h SPHash) Bytes() []byte { return h[:] }
 func (h SPHash) Big() *big.Int { return BytesToBig(h[:]) }
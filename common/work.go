@@ -7,8 +7,6 @@ import (
 	"math/big"
 )
 
-const fixedDifficulty = 100000
-
 type Work struct {
 	blockHeader     *types.Header
 	powHash         string
@@ -52,6 +50,8 @@ func (w Work) PrintInfo() {
 	fmt.Printf("Extra string --%s--\n", string(h.Extra))
 }
 
-func NewWork(h *types.Header, ph string, sh string) *Work {
-	return &Work{h, ph, sh, big.NewInt(fixedDifficulty)}
+// NewWork makes a work whose shares have at least shareDifficulty.
+// Miners may be given a higher one, see server.vardiff.
+func NewWork(h *types.Header, ph string, sh string, shareDifficulty *big.Int) *Work {
+	return &Work{h, ph, sh, shareDifficulty}
 }
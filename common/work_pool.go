@@ -1,12 +1,67 @@
 package common
 
 import (
+	"../params"
 	"github.com/ethereum/go-ethereum/common"
+	"sync"
 )
 
-type workpool map[common.Hash]*Work
+// workpool keeps works given to miners so submitted solutions can be
+// matched with their block header. Works older than
+// params.WorkExpiryBlocks blocks are dropped so solutions for them are
+// rejected as stale. It is safe for concurrent use.
+type workpool struct {
+	mu          sync.RWMutex
+	works       map[common.Hash]*Work
+	latestBlock uint64
+}
 
-var WorkPool = workpool{}
+func newWorkPool() *workpool {
+	return &workpool{works: map[common.Hash]*Work{}}
+}
+
+func (wp *workpool) AddWork(w *Work) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.works[w.PoWHash()] = w
+	if number := w.BlockHeader().Number.Uint64(); number > wp.latestBlock {
+		wp.latestBlock = number
+		wp.removeExpired()
+	}
+}
+
+// removeExpired must be called with wp.mu held.
+func (wp *workpool) removeExpired() {
+	if params.WorkExpiryBlocks == 0 {
+		return
+	}
+	for hash, w := range wp.works {
+		if w.BlockHeader().Number.Uint64()+params.WorkExpiryBlocks <= wp.latestBlock {
+			delete(wp.works, hash)
+		}
+	}
+}
+
+// return nil if there is no work with the pow hash
+func (wp *workpool) GetWork(hash common.Hash) *Work {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+	return wp.works[hash]
+}
+
+func (wp *workpool) RemoveWork(hash common.Hash) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	delete(wp.works, hash)
+}
+
+func (wp *workpool) Len() int {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+	return len(wp.works)
+}
+
+var WorkPool = newWorkPool()
 
 const (
 	FullBlockSolution int = 2
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"../params"
+	"fmt"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"sync"
+	"testing"
+)
+
+func testWork(i int) *Work {
+	h := &types.Header{Number: big.NewInt(int64(i)), Difficulty: big.NewInt(1)}
+	return NewWork(h, fmt.Sprintf("0x%064x", i), "0x0", big.NewInt(100000))
+}
+
+func TestWorkPoolConcurrentAccess(t *testing.T) {
+	pool := newWorkPool()
+	const workers, works = 32, 500
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < works; i++ {
+				work := testWork(w*works + i)
+				pool.AddWork(work)
+				if pool.GetWork(work.PoWHash()) != work {
+					t.Errorf("work %s is missing right after it was added", work.PoWHash().Hex())
+				}
+				if i%2 == 0 {
+					pool.RemoveWork(work.PoWHash())
+				}
+				pool.Len()
+			}
+		}(w)
+	}
+	wg.Wait()
+	if pool.Len() != workers*works/2 {
+		t.Errorf("expected %d works left, got %d", workers*works/2, pool.Len())
+	}
+}
+
+func TestWorkPoolExpiry(t *testing.T) {
+	defer func(expiry uint64) { params.WorkExpiryBlocks = expiry }(params.WorkExpiryBlocks)
+	params.WorkExpiryBlocks = 2
+	pool := newWorkPool()
+	for i := 1; i <= 5; i++ {
+		pool.AddWork(testWork(i))
+	}
+	for i := 1; i <= 5; i++ {
+		found := pool.GetWork(testWork(i).PoWHash()) != nil
+		if found != (i > 3) {
+			t.Errorf("work of block %d: expected present %t, got %t", i, i > 3, found)
+		}
+	}
+}
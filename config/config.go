@@ -0,0 +1,413 @@
+// Package config loads the client settings from a config file,
+// environment variables and command line flags, in increasing order of
+// precedence, and applies them to params.
+package config
+
+import (
+	"../params"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/naoina/toml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// environment variables overriding the config are named
+// SPCLIENT_<OPTION>, e.g. SPCLIENT_KEYSTORE_PATH for keystore-path
+const envPrefix = "SPCLIENT_"
+
+// the passphrase of the miner account is only taken from the
+// environment, never from config files or flags others can read
+const passphraseEnv = envPrefix + "PASSPHRASE"
+
+// Duration is a time.Duration written as "1m30s" in config files.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var text string
+	if err := unmarshal(&text); err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(text))
+}
+
+type Config struct {
+	Nodes               []string `json:"nodes" toml:"nodes" yaml:"nodes"`
+	KeystorePath        string   `json:"keystorePath" toml:"keystorePath" yaml:"keystorePath"`
+	PassphraseFile      string   `json:"passphraseFile" toml:"passphraseFile" yaml:"passphraseFile"`
+	Passphrase          string   `json:"-" toml:"-" yaml:"-"`
+	DataDir             string   `json:"dataDir" toml:"dataDir" yaml:"dataDir"`
+	ContractAddress     string   `json:"contractAddress" toml:"contractAddress" yaml:"contractAddress"`
+	MinerAddress        string   `json:"minerAddress" toml:"minerAddress" yaml:"minerAddress"`
+	ShareDifficulty     uint64   `json:"shareDifficulty" toml:"shareDifficulty" yaml:"shareDifficulty"`
+	SharesPerClaim      uint32   `json:"sharesPerClaim" toml:"sharesPerClaim" yaml:"sharesPerClaim"`
+	SubmitInterval      Duration `json:"submitInterval" toml:"submitInterval" yaml:"submitInterval"`
+	WorkExpiryBlocks    uint64   `json:"workExpiryBlocks" toml:"workExpiryBlocks" yaml:"workExpiryBlocks"`
+	VardiffTargetTime   Duration `json:"vardiffTargetTime" toml:"vardiffTargetTime" yaml:"vardiffTargetTime"`
+	VardiffRetargetTime Duration `json:"vardiffRetargetTime" toml:"vardiffRetargetTime" yaml:"vardiffRetargetTime"`
+	TxConfirmations     uint64   `json:"txConfirmations" toml:"txConfirmations" yaml:"txConfirmations"`
+	TxTimeout           Duration `json:"txTimeout" toml:"txTimeout" yaml:"txTimeout"`
+	FinalityDepth       uint64   `json:"finalityDepth" toml:"finalityDepth" yaml:"finalityDepth"`
+	TxReplaceAfter      Duration `json:"txReplaceAfter" toml:"txReplaceAfter" yaml:"txReplaceAfter"`
+	GasPricePolicy      string   `json:"gasPricePolicy" toml:"gasPricePolicy" yaml:"gasPricePolicy"`
+	GasPrice            uint64   `json:"gasPrice" toml:"gasPrice" yaml:"gasPrice"`
+	GasPriceMultiplier  float64  `json:"gasPriceMultiplier" toml:"gasPriceMultiplier" yaml:"gasPriceMultiplier"`
+	MaxGasPrice         uint64   `json:"maxGasPrice" toml:"maxGasPrice" yaml:"maxGasPrice"`
+	RPCPort             uint16   `json:"rpcPort" toml:"rpcPort" yaml:"rpcPort"`
+	StratumPort         uint16   `json:"stratumPort" toml:"stratumPort" yaml:"stratumPort"`
+	DebugClaims         bool     `json:"debugClaims" toml:"debugClaims" yaml:"debugClaims"`
+}
+
+func Default() *Config {
+	dataDir := ""
+	if home := os.Getenv("HOME"); home != "" {
+		dataDir = filepath.Join(home, ".spclient")
+	}
+	return &Config{
+		Nodes:               []string{"http://127.0.0.1:8545"},
+		DataDir:             dataDir,
+		ContractAddress:     "0x9e7a1925fa43d5f47b36e2e27f84adae95ddd845",
+		ShareDifficulty:     100000,
+		SharesPerClaim:      13,
+		SubmitInterval:      Duration(time.Minute),
+		WorkExpiryBlocks:    4,
+		VardiffTargetTime:   Duration(10 * time.Second),
+		VardiffRetargetTime: Duration(2 * time.Minute),
+		TxConfirmations:     2,
+		TxTimeout:           Duration(30 * time.Minute),
+		FinalityDepth:       12,
+		TxReplaceAfter:      Duration(5 * time.Minute),
+		GasPricePolicy:      "node",
+		GasPriceMultiplier:  1,
+		RPCPort:             1633,
+		StratumPort:         1634,
+	}
+}
+
+// option is a setting that can be overridden by a flag and an
+// environment variable.
+type option struct {
+	name  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+func parseUint(value string, bits int) (uint64, error) {
+	return strconv.ParseUint(value, 10, bits)
+}
+
+var options = []option{
+	{"nodes", "comma separated ethereum node endpoints (http, ws or ipc), in order of preference", func(c *Config, v string) error {
+		c.Nodes = []string{}
+		for _, endpoint := range strings.Split(v, ",") {
+			if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+				c.Nodes = append(c.Nodes, endpoint)
+			}
+		}
+		return nil
+	}},
+	{"keystore-path", "directory of the geth keystore", func(c *Config, v string) error {
+		c.KeystorePath = v
+		return nil
+	}},
+	{"passphrase-file", "file holding the passphrase of the miner account, " + passphraseEnv + " is used otherwise", func(c *Config, v string) error {
+		c.PassphraseFile = v
+		return nil
+	}},
+	{"data-dir", "directory to keep the client state in", func(c *Config, v string) error {
+		c.DataDir = v
+		return nil
+	}},
+	{"contract-address", "address of the pool contract", func(c *Config, v string) error {
+		c.ContractAddress = v
+		return nil
+	}},
+	{"miner-address", "address the client mines and claims with", func(c *Config, v string) error {
+		c.MinerAddress = v
+		return nil
+	}},
+	{"share-difficulty", "minimum share difficulty", func(c *Config, v string) (err error) {
+		c.ShareDifficulty, err = parseUint(v, 64)
+		return err
+	}},
+	{"shares-per-claim", "number of shares a claim is submitted with", func(c *Config, v string) error {
+		n, err := parseUint(v, 32)
+		c.SharesPerClaim = uint32(n)
+		return err
+	}},
+	{"submit-interval", "how often claims are submitted, e.g. 1m", func(c *Config, v string) error {
+		return c.SubmitInterval.UnmarshalText([]byte(v))
+	}},
+	{"work-expiry-blocks", "number of blocks a work accepts shares for, 0 for ever", func(c *Config, v string) (err error) {
+		c.WorkExpiryBlocks, err = parseUint(v, 64)
+		return err
+	}},
+	{"vardiff-target-time", "average time between shares of a worker, 0 disables vardiff", func(c *Config, v string) error {
+		return c.VardiffTargetTime.UnmarshalText([]byte(v))
+	}},
+	{"vardiff-retarget-time", "how often the share difficulty of a worker is adjusted", func(c *Config, v string) error {
+		return c.VardiffRetargetTime.UnmarshalText([]byte(v))
+	}},
+	{"tx-confirmations", "number of blocks a transaction needs, including its own, to be confirmed", func(c *Config, v string) (err error) {
+		c.TxConfirmations, err = parseUint(v, 64)
+		return err
+	}},
+	{"tx-timeout", "how long to wait for a transaction to be confirmed, 0 for ever (1h for claims)", func(c *Config, v string) error {
+		return c.TxTimeout.UnmarshalText([]byte(v))
+	}},
+	{"finality-depth", "number of blocks, including its own, after which a transaction is final", func(c *Config, v string) (err error) {
+		c.FinalityDepth, err = parseUint(v, 64)
+		return err
+	}},
+	{"tx-replace-after", "how long a claim transaction may stay pending before its gas price is bumped, 0 never", func(c *Config, v string) error {
+		return c.TxReplaceAfter.UnmarshalText([]byte(v))
+	}},
+	{"gas-price-policy", "fixed, node (suggested price times the multiplier) or capped (node, at most max-gas-price)", func(c *Config, v string) error {
+		c.GasPricePolicy = v
+		return nil
+	}},
+	{"gas-price", "gas price in wei of the fixed policy", func(c *Config, v string) (err error) {
+		c.GasPrice, err = parseUint(v, 64)
+		return err
+	}},
+	{"gas-price-multiplier", "factor applied to the gas price suggested by the node", func(c *Config, v string) (err error) {
+		c.GasPriceMultiplier, err = strconv.ParseFloat(v, 64)
+		return err
+	}},
+	{"max-gas-price", "highest gas price in wei of the capped policy and of replacements, 0 for no limit", func(c *Config, v string) (err error) {
+		c.MaxGasPrice, err = parseUint(v, 64)
+		return err
+	}},
+	{"rpc-port", "port of the getwork rpc server", func(c *Config, v string) error {
+		n, err := parseUint(v, 16)
+		c.RPCPort = uint16(n)
+		return err
+	}},
+	{"stratum-port", "port of the stratum server", func(c *Config, v string) error {
+		n, err := parseUint(v, 16)
+		c.StratumPort = uint16(n)
+		return err
+	}},
+	{"debug-claims", "true to check proofs with eth_call instead of sending them, claims are then never paid", func(c *Config, v string) (err error) {
+		c.DebugClaims, err = strconv.ParseBool(v)
+		return err
+	}},
+}
+
+// EnvName returns the environment variable overriding option name.
+func EnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// LoadFile reads path over c. The format is picked by the extension:
+// .toml, .yaml, .yml or .json. Unknown keys are refused so a misspelt
+// setting isn't silently left at its default.
+func (c *Config) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, c)
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		err = d.Decode(c)
+	default:
+		return fmt.Errorf("config file %s: unknown format, use .toml, .yaml or .json", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %s", path, err)
+	}
+	return nil
+}
+
+// ApplyEnv overrides c with the environment variables that are set.
+func (c *Config) ApplyEnv(getenv func(string) string) error {
+	for _, o := range options {
+		if v := getenv(EnvName(o.name)); v != "" {
+			if err := o.set(c, v); err != nil {
+				return fmt.Errorf("%s: %s", EnvName(o.name), err)
+			}
+		}
+	}
+	c.Passphrase = getenv(passphraseEnv)
+	return nil
+}
+
+// flagValue collects a flag so it is applied after the config file and
+// the environment, whatever the order they are read in.
+type flagValue struct {
+	o     option
+	value *string
+}
+
+func (f flagValue) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+func (f flagValue) Set(v string) error {
+	// check the value right away so flag reports the bad one
+	if err := f.o.set(Default(), v); err != nil {
+		return err
+	}
+	*f.value = v
+	return nil
+}
+
+// Flags registers -config and a flag for every option on fs. The
+// returned function builds the config once fs is parsed.
+func Flags(fs *flag.FlagSet) func() (*Config, error) {
+	path := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "config file (.toml, .yaml or .json)")
+	values := make([]*string, len(options))
+	for i, o := range options {
+		values[i] = new(string)
+		fs.Var(flagValue{o, values[i]}, o.name, fmt.Sprintf("%s (env %s)", o.usage, EnvName(o.name)))
+	}
+	return func() (*Config, error) {
+		c := Default()
+		if *path != "" {
+			if err := c.LoadFile(*path); err != nil {
+				return nil, err
+			}
+		}
+		if err := c.ApplyEnv(os.Getenv); err != nil {
+			return nil, err
+		}
+		for i, o := range options {
+			if *values[i] != "" {
+				if err := o.set(c, *values[i]); err != nil {
+					return nil, fmt.Errorf("-%s: %s", o.name, err)
+				}
+			}
+		}
+		return c, c.Validate()
+	}
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	problems := []string{}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	check(len(c.Nodes) > 0, "nodes is required")
+	for _, endpoint := range c.Nodes {
+		check(validEndpoint(endpoint), "node %q is neither an http, ws url nor an ipc path", endpoint)
+	}
+	check(c.KeystorePath != "", "keystore-path is required")
+	check(common.IsHexAddress(c.ContractAddress), "contract-address %q is not an address", c.ContractAddress)
+	check(common.IsHexAddress(c.MinerAddress), "miner-address %q is not an address", c.MinerAddress)
+	check(c.ShareDifficulty > 0, "share-difficulty must be positive")
+	check(c.SharesPerClaim > 0, "shares-per-claim must be positive")
+	check(c.SubmitInterval > 0, "submit-interval must be positive")
+	check(c.VardiffTargetTime >= 0, "vardiff-target-time can't be negative")
+	check(c.VardiffTargetTime == 0 || c.VardiffRetargetTime >= c.VardiffTargetTime,
+		"vardiff-retarget-time must be at least vardiff-target-time")
+	check(c.TxConfirmations > 0, "tx-confirmations must be positive")
+	check(c.TxTimeout >= 0, "tx-timeout can't be negative")
+	check(c.FinalityDepth >= c.TxConfirmations, "finality-depth must be at least tx-confirmations")
+	check(c.TxReplaceAfter >= 0, "tx-replace-after can't be negative")
+	switch c.GasPricePolicy {
+	case "fixed":
+		check(c.GasPrice > 0, "gas-price is required by the fixed gas price policy")
+	case "capped":
+		check(c.MaxGasPrice > 0, "max-gas-price is required by the capped gas price policy")
+	case "node":
+	default:
+		check(false, "gas-price-policy %q is neither fixed, node nor capped", c.GasPricePolicy)
+	}
+	check(c.GasPriceMultiplier > 0, "gas-price-multiplier must be positive")
+	check(c.RPCPort != 0, "rpc-port is required")
+	check(c.StratumPort != 0, "stratum-port is required")
+	check(c.RPCPort != c.StratumPort, "rpc-port and stratum-port must differ")
+	if len(problems) > 0 {
+		return errors.New("invalid config:\n\t" + strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+func validEndpoint(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "ws", "wss":
+		return u.Host != ""
+	case "":
+		// rpc.Dial takes anything else as an ipc path
+		return u.Path != ""
+	}
+	return false
+}
+
+// Apply copies the config to params.
+func (c *Config) Apply() {
+	params.NodeEndpoints = c.Nodes
+	params.KeystorePath = c.KeystorePath
+	params.PassphraseFile = c.PassphraseFile
+	params.Passphrase = c.Passphrase
+	params.DataDir = c.DataDir
+	params.ContractAddress = c.ContractAddress
+	params.MinerAddress = c.MinerAddress
+	params.ShareDifficulty = new(big.Int).SetUint64(c.ShareDifficulty)
+	params.NoSharePerClaim = c.SharesPerClaim
+	params.SubmitInterval = time.Duration(c.SubmitInterval)
+	params.WorkExpiryBlocks = c.WorkExpiryBlocks
+	params.VardiffTargetTime = time.Duration(c.VardiffTargetTime)
+	params.VardiffRetargetTime = time.Duration(c.VardiffRetargetTime)
+	params.TxConfirmations = c.TxConfirmations
+	params.TxTimeout = time.Duration(c.TxTimeout)
+	params.FinalityDepth = c.FinalityDepth
+	params.TxReplaceAfter = time.Duration(c.TxReplaceAfter)
+	params.GasPricePolicy = c.GasPricePolicy
+	params.GasPrice = new(big.Int).SetUint64(c.GasPrice)
+	params.GasPriceMultiplier = c.GasPriceMultiplier
+	params.MaxGasPrice = new(big.Int).SetUint64(c.MaxGasPrice)
+	params.RPCPort = c.RPCPort
+	params.StratumPort = c.StratumPort
+	params.DebugClaims = c.DebugClaims
+}
+
+// Show prints the config as json, which is also a valid config file.
+func (c *Config) Show() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", data)
+	return nil
+}
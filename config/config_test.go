@@ -0,0 +1,178 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spclient-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spclient.json")
+	data := `{"nodes": ["/tmp/geth.ipc", "ws://127.0.0.1:8546"], "keystorePath": "/tmp/keystore", "shareDifficulty": 200000, "submitInterval": "30s"}`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := Default()
+	if err := c.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"SPCLIENT_MINER_ADDRESS":    "0xad42beeb07db31149f5d2c4bd33d01c6d7c34116",
+		"SPCLIENT_SHARE_DIFFICULTY": "300000",
+	}
+	if err := c.ApplyEnv(func(name string) string { return env[name] }); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.ShareDifficulty != 300000 {
+		t.Errorf("expected env to override share difficulty, got %d", c.ShareDifficulty)
+	}
+	if time.Duration(c.SubmitInterval) != 30*time.Second {
+		t.Errorf("expected submit interval 30s, got %s", time.Duration(c.SubmitInterval))
+	}
+	if len(c.Nodes) != 2 {
+		t.Errorf("expected 2 nodes from the file, got %v", c.Nodes)
+	}
+	if c.SharesPerClaim != Default().SharesPerClaim {
+		t.Errorf("expected default shares per claim, got %d", c.SharesPerClaim)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	c := Default()
+	c.StratumPort = c.RPCPort
+	c.Nodes = append(c.Nodes, "ftp://127.0.0.1")
+	c.GasPricePolicy = "capped"
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected invalid config")
+	}
+	for _, name := range []string{"ftp://127.0.0.1", "keystore-path", "miner-address", "stratum-port", "max-gas-price"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("%s is not reported in %q", name, err)
+		}
+	}
+}
+
+// writeConfig writes a config file named name in a new directory, the
+// caller removes the directory.
+func writeConfig(t *testing.T, name string, data string) (string, string) {
+	dir, err := ioutil.TempDir("", "spclient-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, dir
+}
+
+func TestLoadFileFormats(t *testing.T) {
+	files := map[string]string{
+		"spclient.toml": `nodes = ["/tmp/geth.ipc", "ws://127.0.0.1:8546"]
+keystorePath = "/tmp/keystore"
+shareDifficulty = 200000
+submitInterval = "30s"
+gasPriceMultiplier = 1.5
+debugClaims = true
+`,
+		"spclient.yaml": `nodes:
+  - /tmp/geth.ipc
+  - ws://127.0.0.1:8546
+keystorePath: /tmp/keystore
+shareDifficulty: 200000
+submitInterval: 30s
+gasPriceMultiplier: 1.5
+debugClaims: true
+`,
+		"spclient.yml": `{nodes: [/tmp/geth.ipc, "ws://127.0.0.1:8546"], keystorePath: /tmp/keystore, shareDifficulty: 200000,
+  submitInterval: 30s, gasPriceMultiplier: 1.5, debugClaims: true}
+`,
+		"spclient.json": `{"nodes": ["/tmp/geth.ipc", "ws://127.0.0.1:8546"], "keystorePath": "/tmp/keystore", "shareDifficulty": 200000,
+  "submitInterval": "30s", "gasPriceMultiplier": 1.5, "debugClaims": true}
+`,
+	}
+	for name, data := range files {
+		path, dir := writeConfig(t, name, data)
+		defer os.RemoveAll(dir)
+		c := Default()
+		if err := c.LoadFile(path); err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if len(c.Nodes) != 2 || c.Nodes[1] != "ws://127.0.0.1:8546" || c.KeystorePath != "/tmp/keystore" {
+			t.Errorf("%s: expected 2 nodes and the keystore, got %v and %q", name, c.Nodes, c.KeystorePath)
+		}
+		if c.ShareDifficulty != 200000 || time.Duration(c.SubmitInterval) != 30*time.Second {
+			t.Errorf("%s: expected difficulty 200000 every 30s, got %d every %s",
+				name, c.ShareDifficulty, time.Duration(c.SubmitInterval))
+		}
+		if c.GasPriceMultiplier != 1.5 || !c.DebugClaims {
+			t.Errorf("%s: expected multiplier 1.5 and debug claims, got %v and %v", name, c.GasPriceMultiplier, c.DebugClaims)
+		}
+		// settings missing from the file keep their default
+		if c.SharesPerClaim != Default().SharesPerClaim || c.RPCPort != Default().RPCPort {
+			t.Errorf("%s: expected default shares per claim and rpc port, got %d and %d", name, c.SharesPerClaim, c.RPCPort)
+		}
+	}
+}
+
+// A misspelt key would leave its setting at the default without notice.
+func TestLoadFileRejectsUnknownKeys(t *testing.T) {
+	files := map[string]string{
+		"spclient.toml": "shareDificulty = 200000\n",
+		"spclient.yaml": "shareDificulty: 200000\n",
+		"spclient.json": `{"shareDificulty": 200000}`,
+	}
+	for name, data := range files {
+		path, dir := writeConfig(t, name, data)
+		defer os.RemoveAll(dir)
+		err := Default().LoadFile(path)
+		if err == nil || !strings.Contains(err.Error(), "shareDificulty") || !strings.Contains(err.Error(), path) {
+			t.Errorf("%s: expected the unknown key reported, got %v", name, err)
+		}
+	}
+	// the passphrase is only taken from the environment
+	path, dir := writeConfig(t, "spclient.json", `{"passphrase": "secret"}`)
+	defer os.RemoveAll(dir)
+	if err := Default().LoadFile(path); err == nil {
+		t.Error("expected a passphrase in the config file to be refused")
+	}
+}
+
+func TestLoadFileRejectsMalformedFiles(t *testing.T) {
+	files := map[string]string{
+		"spclient.toml": "nodes = [\"/tmp/geth.ipc\"\n",
+		"spclient.yaml": "nodes: [/tmp/geth.ipc\n",
+		"spclient.json": `{"nodes": ["/tmp/geth.ipc"]`,
+		"wrong.json":    `{"shareDifficulty": "many"}`,
+		"duration.toml": "submitInterval = \"soon\"\n",
+		"duration.yaml": "submitInterval: soon\n",
+		"duration.json": `{"submitInterval": "soon"}`,
+		"spclient.conf": "shareDifficulty = 200000\n",
+	}
+	for name, data := range files {
+		path, dir := writeConfig(t, name, data)
+		defer os.RemoveAll(dir)
+		c := Default()
+		err := c.LoadFile(path)
+		if err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("%s: expected an error about %s, got %v", name, path, err)
+		}
+	}
+	if err := Default().LoadFile(filepath.Join(os.TempDir(), "spclient-missing.toml")); err == nil {
+		t.Error("expected a missing config file to fail")
+	}
+}
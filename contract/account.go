@@ -3,40 +3,89 @@ package contract
 import (
 	"../params"
 	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
 	"golang.org/x/crypto/ssh/terminal"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
 	"syscall"
 )
 
 type MinerAccount struct {
+	address    common.Address
 	keyFile    string
 	passphrase string
 }
 
-func (ma MinerAccount) KeyFile() string    { return ma.keyFile }
-func (ma MinerAccount) PassPhrase() string { return ma.passphrase }
+func (ma MinerAccount) Address() common.Address { return ma.address }
+func (ma MinerAccount) KeyFile() string         { return ma.keyFile }
+func (ma MinerAccount) PassPhrase() string      { return ma.passphrase }
 
-// Get the first account in key store
-// Return nil if there's no account
-func GetAccount() *MinerAccount {
+// AccountNotFoundError is returned when the keystore has no key of the
+// miner address.
+type AccountNotFoundError struct {
+	Address   common.Address
+	Keystore  string
+	Available []common.Address
+}
+
+func (e *AccountNotFoundError) Error() string {
+	if len(e.Available) == 0 {
+		return fmt.Sprintf("account %s not found, keystore %s is empty", e.Address.Hex(), e.Keystore)
+	}
+	available := make([]string, len(e.Available))
+	for i, a := range e.Available {
+		available[i] = a.Hex()
+	}
+	return fmt.Sprintf("account %s not found in keystore %s, it has %s",
+		e.Address.Hex(), e.Keystore, strings.Join(available, ", "))
+}
+
+// GetAccount returns the account of params.MinerAddress in the keystore
+// together with its passphrase.
+func GetAccount() (*MinerAccount, error) {
 	keys := keystore.NewKeyStore(
 		params.KeystorePath,
 		keystore.StandardScryptN,
 		keystore.StandardScryptP,
 	)
-	if len(keys.Accounts()) == 0 {
-		return nil
+	address := common.HexToAddress(params.MinerAddress)
+	available := []common.Address{}
+	for _, acc := range keys.Accounts() {
+		if acc.Address != address {
+			available = append(available, acc.Address)
+			continue
+		}
+		passphrase, err := readPassphrase(acc.Address.Hex())
+		if err != nil {
+			return nil, err
+		}
+		return &MinerAccount{acc.Address, acc.URL.Path, passphrase}, nil
 	}
-	acc := keys.Accounts()[0]
-	keyFile := acc.URL.Path
-	passphrase, err := promptUserPassPhrase(acc.Address.Hex())
-	if err != nil {
-		return nil
+	return nil, &AccountNotFoundError{address, params.KeystorePath, available}
+}
+
+// readPassphrase takes the passphrase from params.PassphraseFile, then
+// params.Passphrase and prompts for it only when the client runs in a
+// terminal, so it can run as a service.
+func readPassphrase(acc string) (string, error) {
+	if params.PassphraseFile != "" {
+		data, err := ioutil.ReadFile(params.PassphraseFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
 	}
-	return &MinerAccount{
-		keyFile,
-		passphrase,
+	if params.Passphrase != "" {
+		return params.Passphrase, nil
 	}
+	if !terminal.IsTerminal(int(syscall.Stdin)) {
+		return "", fmt.Errorf("no passphrase for account %s, set a passphrase file or SPCLIENT_PASSPHRASE", acc)
+	}
+	return promptUserPassPhrase(acc)
 }
 
 func promptUserPassPhrase(acc string) (string, error) {
@@ -50,3 +99,37 @@ func promptUserPassPhrase(acc string) (string, error) {
 		return string(bytePassword), nil
 	}
 }
+
+var (
+	transactorMu sync.Mutex
+	transactor   *bind.TransactOpts
+)
+
+// Transactor unlocks the miner account the first time it is called.
+// Every contract client sends with the same unlocked account.
+func Transactor() (*bind.TransactOpts, error) {
+	transactorMu.Lock()
+	defer transactorMu.Unlock()
+	if transactor != nil {
+		return transactor, nil
+	}
+	account, err := GetAccount()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Key: %s\n", account.KeyFile())
+	keyio, err := os.Open(account.KeyFile())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key file: %s", err)
+	}
+	defer keyio.Close()
+	fmt.Printf("Unlocking account...")
+	auth, err := bind.NewTransactor(keyio, account.PassPhrase())
+	if err != nil {
+		fmt.Printf("\n")
+		return nil, fmt.Errorf("failed to unlock account %s: %s", account.Address().Hex(), err)
+	}
+	fmt.Printf("Done.\n")
+	transactor = auth
+	return transactor, nil
+}
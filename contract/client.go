@@ -1,16 +1,14 @@
 package contract
 
 import (
+	"../node"
 	"../params"
 	"fmt"
-	"log"
 	"math/big"
-	"os"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 var DefaultContractClient *ContractClient
@@ -18,8 +16,9 @@ var DefaultContractClient *ContractClient
 type ContractClient struct {
 	// the contract implementation that holds all underlying
 	// communication with Ethereum Contract
-	contract   Contract
-	transactor *bind.TransactOpts
+	contract Contract
+	// sends transactions with managed nonces and gas prices
+	sender *sender
 }
 
 func (cc ContractClient) SubmitClaim(
@@ -28,8 +27,10 @@ func (cc ContractClient) SubmitClaim(
 	min *big.Int,
 	max *big.Int,
 	augMerkle *big.Int) (*types.Transaction, error) {
-	return cc.contract.SubmitClaim(cc.transactor,
-		numShares, difficulty, min, max, augMerkle)
+	return cc.sender.transact(func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return cc.contract.SubmitClaim(opts,
+			numShares, difficulty, min, max, augMerkle)
+	})
 }
 
 func (cc ContractClient) VerifyClaim(
@@ -40,9 +41,11 @@ func (cc ContractClient) VerifyClaim(
 	witnessForLookup []*big.Int,
 	augCountersBranch []*big.Int,
 	augHashesBranch []*big.Int) (*types.Transaction, error) {
-	return cc.contract.VerifyClaim(cc.transactor,
-		rlpHeader, nonce, shareIndex, dataSetLookup,
-		witnessForLookup, augCountersBranch, augHashesBranch)
+	return cc.sender.transact(func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return cc.contract.VerifyClaim(opts,
+			rlpHeader, nonce, shareIndex, dataSetLookup,
+			witnessForLookup, augCountersBranch, augHashesBranch)
+	})
 }
 
 // TODO: remove this
@@ -75,53 +78,55 @@ func (cc ContractClient) CanRegister() bool {
 	return ok
 }
 
+// seed the contract uses to pick the share a miner has to prove
+// for its last submitted claim
+func (cc ContractClient) GetClaimSeed() (*big.Int, error) {
+	return cc.contract.GetClaimSeed(nil)
+}
+
 func (cc ContractClient) Register(paymentAddress common.Address) (*types.Transaction, error) {
-	return cc.contract.Register(cc.transactor, paymentAddress)
+	return cc.sender.transact(func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return cc.contract.Register(opts, paymentAddress)
+	})
 }
 
-func (cc ContractClient) Version() string {
-	v, err := cc.contract.Version(nil)
-	if err != nil {
-		log.Fatalf("Failed to retrieve pool version: %s\n", err)
-		return ""
-	} else {
-		fmt.Printf("SmartPool version: %s\n", v)
-		return v
-	}
+// ReplaceTransaction sends the pending transaction h again with a
+// higher gas price. It fails with ErrNotPending once h is mined.
+func (cc ContractClient) ReplaceTransaction(h common.Hash) (*types.Transaction, error) {
+	return cc.sender.replace(h)
 }
 
-func getClient() (*ethclient.Client, error) {
-	return ethclient.Dial(params.IPCPath)
+func (cc ContractClient) Version() (string, error) {
+	return cc.contract.Version(nil)
 }
 
-func NewContractClient() (*ContractClient, error) {
-	client, err := getClient()
+// PoolVersion reads the version of the pool contract. It only calls
+// the contract so it doesn't need an account.
+func PoolVersion(m *node.Manager) (string, error) {
+	pool, err := newFailoverContract(common.HexToAddress(params.ContractAddress), m)
 	if err != nil {
-		fmt.Printf("Couldn't connect to Geth via IPC file. Error: %s\n", err)
-		return nil, err
+		return "", err
 	}
-	pool, err := NewTestPool(common.HexToAddress(params.ContractAddress), client)
+	return pool.Version(nil)
+}
+
+// NewContractClient binds the pool contract to the nodes of m. The
+// contract follows m when it switches node.
+func NewContractClient(m *node.Manager) (*ContractClient, error) {
+	pool, err := newFailoverContract(common.HexToAddress(params.ContractAddress), m)
 	if err != nil {
 		fmt.Printf("Couldn't get SmartPool information from Ethereum Blockchain. Error: %s\n", err)
 		return nil, err
 	}
-	account := GetAccount()
-	if account == nil {
-		fmt.Printf("Couldn't get any account from key store.\n")
-		return nil, err
-	}
-	fmt.Printf("Key: %s\n", account.KeyFile())
-	keyio, err := os.Open(account.KeyFile())
+	auth, err := Transactor()
 	if err != nil {
-		fmt.Printf("Failed to open key file: %s\n", err)
+		fmt.Printf("%s\n", err)
 		return nil, err
 	}
-	fmt.Printf("Unlocking account...")
-	auth, err := bind.NewTransactor(keyio, account.PassPhrase())
+	s, err := newSender(auth, m)
 	if err != nil {
-		fmt.Printf("Failed to create authorized transactor: %s\n", err)
+		fmt.Printf("%s\n", err)
 		return nil, err
 	}
-	fmt.Printf("Done.\n")
-	return &ContractClient{pool, auth}, nil
+	return &ContractClient{pool, s}, nil
 }
@@ -0,0 +1,210 @@
+package contract
+
+import (
+	"../node"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"math/big"
+	"sync"
+)
+
+// failoverContract is a Contract and an Updater bound to the current
+// connection of a node.Manager. Calls failing because of the connection are retried on
+// the next node. Transactions are not, the failed node may have sent
+// them already.
+type failoverContract struct {
+	address common.Address
+	nodes   *node.Manager
+
+	mu         sync.Mutex
+	pool       *TestPool
+	generation uint64
+}
+
+func newFailoverContract(address common.Address, m *node.Manager) (*failoverContract, error) {
+	fc := &failoverContract{address: address, nodes: m}
+	if _, _, err := fc.current(); err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+// current binds the contract again when the manager switched node.
+func (fc *failoverContract) current() (*TestPool, uint64, error) {
+	c, generation := fc.nodes.Client()
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.pool == nil || fc.generation != generation {
+		pool, err := NewTestPool(fc.address, ethclient.NewClient(c))
+		if err != nil {
+			return nil, 0, err
+		}
+		fc.pool = pool
+		fc.generation = generation
+	}
+	return fc.pool, fc.generation, nil
+}
+
+// call runs f on the current binding and once more on the next node
+// if the connection failed.
+func (fc *failoverContract) call(f func(*TestPool) error) error {
+	pool, generation, err := fc.current()
+	if err != nil {
+		return err
+	}
+	if err = f(pool); !node.IsConnectionError(err) {
+		return err
+	}
+	if fc.nodes.Failover(generation) != nil {
+		return err
+	}
+	if pool, _, err = fc.current(); err != nil {
+		return err
+	}
+	return f(pool)
+}
+
+// transact runs f on the current binding, switching node if the
+// connection failed so the next transaction goes elsewhere.
+func (fc *failoverContract) transact(f func(*TestPool) (*types.Transaction, error)) (*types.Transaction, error) {
+	pool, generation, err := fc.current()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := f(pool)
+	if node.IsConnectionError(err) {
+		fc.nodes.Failover(generation)
+	}
+	return tx, err
+}
+
+func (fc *failoverContract) Version(opts *bind.CallOpts) (result string, err error) {
+	err = fc.call(func(c *TestPool) (err error) {
+		result, err = c.Version(opts)
+		return err
+	})
+	return result, err
+}
+
+func (fc *failoverContract) IsRegistered(opts *bind.CallOpts) (result bool, err error) {
+	err = fc.call(func(c *TestPool) (err error) {
+		result, err = c.IsRegistered(opts)
+		return err
+	})
+	return result, err
+}
+
+func (fc *failoverContract) CanRegister(opts *bind.CallOpts) (result bool, err error) {
+	err = fc.call(func(c *TestPool) (err error) {
+		result, err = c.CanRegister(opts)
+		return err
+	})
+	return result, err
+}
+
+func (fc *failoverContract) GetClaimSeed(opts *bind.CallOpts) (result *big.Int, err error) {
+	err = fc.call(func(c *TestPool) (err error) {
+		result, err = c.GetClaimSeed(opts)
+		return err
+	})
+	return result, err
+}
+
+func (fc *failoverContract) VerifyClaim_debug(
+	opts *bind.CallOpts,
+	rlpHeader []byte,
+	nonce *big.Int,
+	shareIndex *big.Int,
+	dataSetLookup []*big.Int,
+	witnessForLookup []*big.Int,
+	augCountersBranch []*big.Int,
+	augHashesBranch []*big.Int) (result *big.Int, err error) {
+	err = fc.call(func(c *TestPool) (err error) {
+		result, err = c.VerifyClaim_debug(opts, rlpHeader, nonce, shareIndex,
+			dataSetLookup, witnessForLookup, augCountersBranch, augHashesBranch)
+		return err
+	})
+	return result, err
+}
+
+func (fc *failoverContract) Register(opts *bind.TransactOpts, paymentAddress common.Address) (*types.Transaction, error) {
+	return fc.transact(func(c *TestPool) (*types.Transaction, error) {
+		return c.Register(opts, paymentAddress)
+	})
+}
+
+func (fc *failoverContract) SubmitClaim(
+	opts *bind.TransactOpts,
+	numShares *big.Int,
+	difficulty *big.Int,
+	min *big.Int,
+	max *big.Int,
+	augMerkle *big.Int) (*types.Transaction, error) {
+	return fc.transact(func(c *TestPool) (*types.Transaction, error) {
+		return c.SubmitClaim(opts, numShares, difficulty, min, max, augMerkle)
+	})
+}
+
+func (fc *failoverContract) VerifyClaim(
+	opts *bind.TransactOpts,
+	rlpHeader []byte,
+	nonce *big.Int,
+	shareIndex *big.Int,
+	dataSetLookup []*big.Int,
+	witnessForLookup []*big.Int,
+	augCountersBranch []*big.Int,
+	augHashesBranch []*big.Int) (*types.Transaction, error) {
+	return fc.transact(func(c *TestPool) (*types.Transaction, error) {
+		return c.VerifyClaim(opts, rlpHeader, nonce, shareIndex,
+			dataSetLookup, witnessForLookup, augCountersBranch, augHashesBranch)
+	})
+}
+
+func (fc *failoverContract) EpochData(opts *bind.CallOpts, arg0 *big.Int) (result struct {
+	MerkleRoot             *big.Int
+	FullSizeIn128Resultion uint64
+	BranchDepth            uint64
+}, err error) {
+	err = fc.call(func(c *TestPool) (err error) {
+		result, err = c.EpochData(opts, arg0)
+		return err
+	})
+	return result, err
+}
+
+func (fc *failoverContract) VerifyExtraData(opts *bind.CallOpts, extraData [32]byte, minerId [32]byte, difficulty *big.Int) (result bool, err error) {
+	err = fc.call(func(c *TestPool) (err error) {
+		result, err = c.VerifyExtraData(opts, extraData, minerId, difficulty)
+		return err
+	})
+	return result, err
+}
+
+func (fc *failoverContract) VerifyExtraData_debug(opts *bind.CallOpts, extraData [32]byte, minerId [32]byte, difficulty *big.Int) (result *big.Int, err error) {
+	err = fc.call(func(c *TestPool) (err error) {
+		result, err = c.VerifyExtraData_debug(opts, extraData, minerId, difficulty)
+		return err
+	})
+	return result, err
+}
+
+func (fc *failoverContract) To62Encoding(opts *bind.CallOpts, id *big.Int, numChars *big.Int) (result [32]byte, err error) {
+	err = fc.call(func(c *TestPool) (err error) {
+		result, err = c.To62Encoding(opts, id, numChars)
+		return err
+	})
+	return result, err
+}
+
+func (fc *failoverContract) SetEpochData(
+	opts *bind.TransactOpts,
+	merkleRoot *big.Int,
+	fullSizeIn128Resolution uint64,
+	branchDepth uint64,
+	epoch *big.Int) (*types.Transaction, error) {
+	return fc.transact(func(c *TestPool) (*types.Transaction, error) {
+		return c.SetEpochData(opts, merkleRoot, fullSizeIn128Resolution, branchDepth, epoch)
+	})
+}
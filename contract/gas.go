@@ -0,0 +1,77 @@
+package contract
+
+import (
+	"../node"
+	"../params"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"math/big"
+)
+
+// Gas price policies, see params.GasPricePolicy.
+const (
+	// always params.GasPrice
+	GasPriceFixed = "fixed"
+	// the price suggested by the node times params.GasPriceMultiplier
+	GasPriceNode = "node"
+	// GasPriceNode but never above params.MaxGasPrice
+	GasPriceCapped = "capped"
+)
+
+// GasPricer picks the gas price of new transactions.
+type GasPricer interface {
+	GasPrice() (*big.Int, error)
+}
+
+type fixedGasPricer struct {
+	price *big.Int
+}
+
+func (p fixedGasPricer) GasPrice() (*big.Int, error) {
+	return new(big.Int).Set(p.price), nil
+}
+
+type nodeGasPricer struct {
+	nodes      *node.Manager
+	multiplier float64
+	// nil for no cap
+	max *big.Int
+}
+
+func (p nodeGasPricer) GasPrice() (*big.Int, error) {
+	var suggested hexutil.Big
+	if err := p.nodes.Call(&suggested, "eth_gasPrice"); err != nil {
+		return nil, err
+	}
+	price, _ := new(big.Float).Mul(
+		new(big.Float).SetInt((*big.Int)(&suggested)),
+		big.NewFloat(p.multiplier),
+	).Int(nil)
+	if p.max != nil && price.Cmp(p.max) > 0 {
+		price.Set(p.max)
+	}
+	return price, nil
+}
+
+// NewGasPricer returns the pricer of params.GasPricePolicy.
+func NewGasPricer(m *node.Manager) (GasPricer, error) {
+	multiplier := params.GasPriceMultiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+	switch params.GasPricePolicy {
+	case GasPriceFixed:
+		if params.GasPrice == nil || params.GasPrice.Sign() <= 0 {
+			return nil, fmt.Errorf("gas price policy %s needs a gas price", GasPriceFixed)
+		}
+		return fixedGasPricer{params.GasPrice}, nil
+	case GasPriceNode, "":
+		return nodeGasPricer{m, multiplier, nil}, nil
+	case GasPriceCapped:
+		if params.MaxGasPrice == nil || params.MaxGasPrice.Sign() <= 0 {
+			return nil, fmt.Errorf("gas price policy %s needs a max gas price", GasPriceCapped)
+		}
+		return nodeGasPricer{m, multiplier, params.MaxGasPrice}, nil
+	}
+	return nil, fmt.Errorf("unknown gas price policy: %s", params.GasPricePolicy)
+}
@@ -0,0 +1,68 @@
+package contract
+
+import (
+	"../params"
+	"math/big"
+	"testing"
+)
+
+func TestGasPricer(t *testing.T) {
+	defer func(policy string, price *big.Int, multiplier float64, max *big.Int) {
+		params.GasPricePolicy, params.GasPrice, params.GasPriceMultiplier, params.MaxGasPrice = policy, price, multiplier, max
+	}(params.GasPricePolicy, params.GasPrice, params.GasPriceMultiplier, params.MaxGasPrice)
+	eth := newTestEth()
+	eth.gasPrice = 20 * gwei
+	m, ts := startTestEth(t, eth)
+	defer ts.Close()
+
+	tests := []struct {
+		policy     string
+		price      *big.Int
+		multiplier float64
+		max        *big.Int
+		// 0 when the policy is refused
+		expected int64
+	}{
+		{GasPriceFixed, big.NewInt(3 * gwei), 0, nil, 3 * gwei},
+		{GasPriceFixed, nil, 0, nil, 0},
+		{GasPriceNode, nil, 0, nil, 20 * gwei},
+		{"", nil, 1.5, nil, 30 * gwei},
+		// the max gas price only caps the capped policy
+		{GasPriceNode, nil, 1.5, big.NewInt(25 * gwei), 30 * gwei},
+		{GasPriceCapped, nil, 1.5, big.NewInt(25 * gwei), 25 * gwei},
+		{GasPriceCapped, nil, 0.5, big.NewInt(25 * gwei), 10 * gwei},
+		{GasPriceCapped, nil, 1, nil, 0},
+		{"auction", nil, 1, nil, 0},
+	}
+	for _, test := range tests {
+		params.GasPricePolicy, params.GasPrice = test.policy, test.price
+		params.GasPriceMultiplier, params.MaxGasPrice = test.multiplier, test.max
+		pricer, err := NewGasPricer(m)
+		if test.expected == 0 {
+			if err == nil {
+				t.Errorf("%q with price %v and max %v: expected the policy to be refused", test.policy, test.price, test.max)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.policy, err)
+			continue
+		}
+		price, err := pricer.GasPrice()
+		if err != nil {
+			t.Errorf("%q: %s", test.policy, err)
+		} else if price.Int64() != test.expected {
+			t.Errorf("%q with multiplier %v and max %v: expected %d, got %s", test.policy, test.multiplier, test.max, test.expected, price)
+		}
+	}
+}
+
+// The fixed price can't be changed through the prices handed out.
+func TestFixedGasPriceIsCopied(t *testing.T) {
+	pricer := fixedGasPricer{big.NewInt(3 * gwei)}
+	price, _ := pricer.GasPrice()
+	price.SetInt64(0)
+	if price, _ = pricer.GasPrice(); price.Int64() != 3*gwei {
+		t.Errorf("expected %d, got %s", 3*gwei, price)
+	}
+}
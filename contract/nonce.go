@@ -0,0 +1,78 @@
+package contract
+
+import (
+	"../node"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"sync"
+)
+
+// NonceManager hands out the nonces of an account so transactions sent
+// back to back don't collide. It is safe for concurrent use.
+type NonceManager struct {
+	address common.Address
+	nodes   *node.Manager
+
+	mu     sync.Mutex
+	next   uint64
+	synced bool
+}
+
+var (
+	nonceManagersMu sync.Mutex
+	nonceManagers   = map[common.Address]*NonceManager{}
+)
+
+// nonceManager returns the manager of address, every client sending
+// from the same account shares it.
+func nonceManager(address common.Address, m *node.Manager) *NonceManager {
+	nonceManagersMu.Lock()
+	defer nonceManagersMu.Unlock()
+	nm := nonceManagers[address]
+	if nm == nil {
+		nm = &NonceManager{address: address, nodes: m}
+		nonceManagers[address] = nm
+	}
+	return nm
+}
+
+func (nm *NonceManager) pendingNonce() (uint64, error) {
+	var result hexutil.Uint64
+	err := nm.nodes.Call(&result, "eth_getTransactionCount", nm.address, "pending")
+	return uint64(result), err
+}
+
+// Send calls send with the next nonce of the account. Sends are
+// serialized. The nonce is taken from the node when it is ahead, e.g.
+// because the account was used elsewhere, and after a send failed
+// since then the node decides whether the nonce was used.
+func (nm *NonceManager) Send(send func(nonce uint64) (*types.Transaction, error)) (*types.Transaction, error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	pending, err := nm.pendingNonce()
+	if err != nil {
+		return nil, err
+	}
+	// the node may not know every transaction sent yet, e.g. right
+	// after switching node, so it is only trusted when it is ahead
+	if !nm.synced || pending > nm.next {
+		nm.next = pending
+		nm.synced = true
+	}
+	tx, err := send(nm.next)
+	if err != nil {
+		nm.synced = false
+		return nil, err
+	}
+	nm.next++
+	return tx, nil
+}
+
+// Resync makes the next Send start over from the pending nonce of the
+// node, e.g. when a transaction was dropped from the pool.
+func (nm *NonceManager) Resync() {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	nm.synced = false
+}
@@ -0,0 +1,65 @@
+package contract
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"testing"
+)
+
+// sendNonce sends with nm and returns the nonce it was given.
+func sendNonce(nm *NonceManager, err error) uint64 {
+	var given uint64
+	nm.Send(func(nonce uint64) (*types.Transaction, error) {
+		given = nonce
+		return nil, err
+	})
+	return given
+}
+
+func TestNonceManagerReusesNonceAfterSendError(t *testing.T) {
+	eth := newTestEth()
+	eth.nonce = 5
+	m, ts := startTestEth(t, eth)
+	defer ts.Close()
+	nm := &NonceManager{address: common.HexToAddress("0x1"), nodes: m}
+
+	// the node doesn't count the pending transactions yet
+	for _, expected := range []uint64{5, 6} {
+		if nonce := sendNonce(nm, nil); nonce != expected {
+			t.Errorf("expected nonce %d, got %d", expected, nonce)
+		}
+	}
+	if nonce := sendNonce(nm, errors.New("connection refused")); nonce != 7 {
+		t.Errorf("expected nonce 7, got %d", nonce)
+	}
+	// the node has seen 5 and 6, the nonce of the failed send is free
+	eth.set(func() { eth.nonce = 7 })
+	if nonce := sendNonce(nm, nil); nonce != 7 {
+		t.Errorf("expected nonce 7 used again, got %d", nonce)
+	}
+	if nonce := sendNonce(nm, nil); nonce != 8 {
+		t.Errorf("expected nonce 8, got %d", nonce)
+	}
+}
+
+// Nonces used by another client of the account are skipped.
+func TestNonceManagerFollowsNodeAhead(t *testing.T) {
+	eth := newTestEth()
+	m, ts := startTestEth(t, eth)
+	defer ts.Close()
+	nm := &NonceManager{address: common.HexToAddress("0x1"), nodes: m}
+	if nonce := sendNonce(nm, nil); nonce != 0 {
+		t.Fatalf("expected nonce 0, got %d", nonce)
+	}
+	eth.set(func() { eth.nonce = 10 })
+	if nonce := sendNonce(nm, nil); nonce != 10 {
+		t.Errorf("expected the nonce of the node, got %d", nonce)
+	}
+	// a dropped transaction frees its nonce
+	eth.set(func() { eth.nonce = 9 })
+	nm.Resync()
+	if nonce := sendNonce(nm, nil); nonce != 9 {
+		t.Errorf("expected nonce 9 after a resync, got %d", nonce)
+	}
+}
@@ -0,0 +1,137 @@
+package contract
+
+import (
+	"../node"
+	"../params"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// nodes refuse a replacement unless its gas price is at least 10%
+	// higher, a bit more leaves room for rounding
+	priceBumpPercent = 12
+	replaceTimeout   = 30 * time.Second
+)
+
+var ErrNotPending = errors.New("transaction is not pending")
+
+// sender sends the transactions of an account with managed nonces and
+// gas prices.
+type sender struct {
+	opts   *bind.TransactOpts
+	nonces *NonceManager
+	gas    GasPricer
+	nodes  *node.Manager
+}
+
+func newSender(opts *bind.TransactOpts, m *node.Manager) (*sender, error) {
+	gas, err := NewGasPricer(m)
+	if err != nil {
+		return nil, err
+	}
+	return &sender{opts, nonceManager(opts.From, m), gas, m}, nil
+}
+
+// transact calls send with options carrying the next nonce of the
+// account and the gas price of the policy.
+func (s *sender) transact(send func(*bind.TransactOpts) (*types.Transaction, error)) (*types.Transaction, error) {
+	price, err := s.gas.GasPrice()
+	if err != nil {
+		return nil, err
+	}
+	return s.nonces.Send(func(nonce uint64) (*types.Transaction, error) {
+		opts := *s.opts
+		opts.Nonce = new(big.Int).SetUint64(nonce)
+		opts.GasPrice = price
+		var signed *types.Transaction
+		opts.Signer = func(signer types.Signer, address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			tx, err := s.opts.Signer(signer, address, tx)
+			signed = tx
+			return tx, err
+		}
+		tx, err := send(&opts)
+		if err != nil && signed != nil && alreadyKnown(err) {
+			return signed, nil
+		}
+		return tx, err
+	})
+}
+
+// alreadyKnown tells whether err is a node refusing a transaction it
+// already has, e.g. because an earlier send of it timed out after
+// reaching the node. The transaction was sent.
+func alreadyKnown(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "already known") || strings.Contains(msg, "known transaction")
+}
+
+// replace sends the pending transaction h again with the same nonce and
+// a gas price bumped by priceBumpPercent, or the price of the policy if
+// that is higher. It fails with ErrNotPending once h is mined.
+func (s *sender) replace(h common.Hash) (*types.Transaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), replaceTimeout)
+	defer cancel()
+	tx, pending, err := s.transactionByHash(ctx, h)
+	if err == ethereum.NotFound {
+		// dropped from the pool, its nonce may be free again
+		s.nonces.Resync()
+		return nil, fmt.Errorf("transaction %s: %s", h.Hex(), err)
+	} else if err != nil {
+		return nil, err
+	}
+	if !pending {
+		return nil, ErrNotPending
+	}
+	price, err := s.gas.GasPrice()
+	if err != nil {
+		return nil, err
+	}
+	bumped := new(big.Int).Mul(tx.GasPrice(), big.NewInt(100+priceBumpPercent))
+	bumped.Div(bumped, big.NewInt(100))
+	if price.Cmp(bumped) < 0 {
+		price = bumped
+	}
+	if params.MaxGasPrice != nil && params.MaxGasPrice.Sign() > 0 && price.Cmp(params.MaxGasPrice) > 0 {
+		return nil, fmt.Errorf("replacing transaction %s needs gas price %s, above the max gas price %s",
+			h.Hex(), price, params.MaxGasPrice)
+	}
+	replacement := types.NewTransaction(tx.Nonce(), *tx.To(), tx.Value(), tx.Gas(), price, tx.Data())
+	signed, err := s.opts.Signer(types.HomesteadSigner{}, s.opts.From, replacement)
+	if err != nil {
+		return nil, err
+	}
+	c, generation := s.nodes.Client()
+	if err = ethclient.NewClient(c).SendTransaction(ctx, signed); err != nil && !alreadyKnown(err) {
+		// not sent again, the failed node may have sent it already
+		if node.IsConnectionError(err) {
+			s.nodes.Failover(generation)
+		}
+		return nil, err
+	}
+	return signed, nil
+}
+
+// transactionByHash looks h up on the current node and once more on the
+// next node if the connection failed.
+func (s *sender) transactionByHash(ctx context.Context, h common.Hash) (*types.Transaction, bool, error) {
+	c, generation := s.nodes.Client()
+	tx, pending, err := ethclient.NewClient(c).TransactionByHash(ctx, h)
+	if err == ethereum.NotFound || !node.IsConnectionError(err) {
+		return tx, pending, err
+	}
+	if s.nodes.Failover(generation) != nil {
+		return nil, false, err
+	}
+	c, _ = s.nodes.Client()
+	return ethclient.NewClient(c).TransactionByHash(ctx, h)
+}
@@ -0,0 +1,257 @@
+package contract
+
+import (
+	"../node"
+	"../params"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+const gwei = 1000000000
+
+// testEth is a node keeping the transactions sent to it pending.
+type testEth struct {
+	mu       sync.Mutex
+	nonce    uint64
+	gasPrice int64
+	pending  map[common.Hash]*types.Transaction
+	mined    map[common.Hash]*types.Transaction
+	sent     []*types.Transaction
+	// returned by eth_sendRawTransaction when set
+	sendErr error
+}
+
+func newTestEth() *testEth {
+	return &testEth{
+		gasPrice: 5 * gwei,
+		pending:  map[common.Hash]*types.Transaction{},
+		mined:    map[common.Hash]*types.Transaction{},
+	}
+}
+
+// set changes the node while it is serving.
+func (e *testEth) set(change func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	change()
+}
+
+func (e *testEth) BlockNumber() hexutil.Uint64 { return 1 }
+
+func (e *testEth) GetTransactionCount(address common.Address, block string) hexutil.Uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return hexutil.Uint64(e.nonce)
+}
+
+func (e *testEth) GasPrice() *hexutil.Big {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return (*hexutil.Big)(big.NewInt(e.gasPrice))
+}
+
+func (e *testEth) GetTransactionByHash(h common.Hash) (map[string]interface{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	tx, mined := e.pending[h], false
+	if tx == nil {
+		tx, mined = e.mined[h], true
+	}
+	if tx == nil {
+		return nil, nil
+	}
+	data, err := tx.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if mined {
+		fields["blockNumber"] = "0x1"
+		fields["blockHash"] = common.HexToHash("0xb1")
+	}
+	return fields, nil
+}
+
+func (e *testEth) SendRawTransaction(data hexutil.Bytes) (common.Hash, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.sendErr != nil {
+		return common.Hash{}, e.sendErr
+	}
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(data, tx); err != nil {
+		return common.Hash{}, err
+	}
+	e.sent = append(e.sent, tx)
+	e.pending[tx.Hash()] = tx
+	return tx.Hash(), nil
+}
+
+func (e *testEth) lastSent() *types.Transaction {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.sent) == 0 {
+		return nil
+	}
+	return e.sent[len(e.sent)-1]
+}
+
+// startTestEth serves eth over HTTP, the caller closes the server.
+func startTestEth(t *testing.T, eth *testEth) (*node.Manager, *httptest.Server) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", eth); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server)
+	m, err := node.NewManager([]string{ts.URL})
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return m, ts
+}
+
+// newTestSender sends with a new key at the node price.
+func newTestSender(t *testing.T, m *node.Manager) *sender {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := bind.NewKeyedTransactor(key)
+	return &sender{opts, &NonceManager{address: opts.From, nodes: m}, nodeGasPricer{m, 1, nil}, m}
+}
+
+// signTestTx signs a transfer the way a contract binding does.
+func signTestTx(opts *bind.TransactOpts, nonce uint64, gasPrice *big.Int) (*types.Transaction, error) {
+	tx := types.NewTransaction(nonce, common.HexToAddress("0x1"), big.NewInt(0), big.NewInt(21000), gasPrice, nil)
+	return opts.Signer(types.HomesteadSigner{}, opts.From, tx)
+}
+
+func TestAlreadyKnown(t *testing.T) {
+	known := []error{
+		errors.New("already known"),
+		errors.New("known transaction: 0x01"),
+		errors.New("Known transaction: 0x01"),
+	}
+	for _, err := range known {
+		if !alreadyKnown(err) {
+			t.Errorf("expected %q to be a known transaction", err)
+		}
+	}
+	for _, err := range []error{errors.New("nonce too low"), errors.New("replacement transaction underpriced")} {
+		if alreadyKnown(err) {
+			t.Errorf("expected %q not to be a known transaction", err)
+		}
+	}
+}
+
+// A transaction that reached the node before its send failed is
+// returned as sent and its nonce is not used again.
+func TestSenderTreatsKnownTransactionAsSent(t *testing.T) {
+	eth := newTestEth()
+	m, ts := startTestEth(t, eth)
+	defer ts.Close()
+	s := newTestSender(t, m)
+	var signed *types.Transaction
+	tx, err := s.transact(func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		var err error
+		if signed, err = signTestTx(opts, opts.Nonce.Uint64(), opts.GasPrice); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("known transaction: " + signed.Hash().Hex())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx == nil || tx.Hash() != signed.Hash() {
+		t.Fatalf("expected the signed transaction, got %v", tx)
+	}
+	if tx.GasPrice().Int64() != 5*gwei {
+		t.Errorf("expected the node gas price, got %s", tx.GasPrice())
+	}
+	s.transact(func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		if opts.Nonce.Uint64() != 1 {
+			t.Errorf("expected nonce 1 after the known transaction, got %s", opts.Nonce)
+		}
+		return signTestTx(opts, opts.Nonce.Uint64(), opts.GasPrice)
+	})
+}
+
+func TestSenderBumpsReplacementPrice(t *testing.T) {
+	defer func(saved *big.Int) { params.MaxGasPrice = saved }(params.MaxGasPrice)
+	params.MaxGasPrice = nil
+	eth := newTestEth()
+	m, ts := startTestEth(t, eth)
+	defer ts.Close()
+	s := newTestSender(t, m)
+	original, err := signTestTx(s.opts, 3, big.NewInt(10*gwei))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eth.set(func() { eth.pending[original.Hash()] = original })
+
+	tests := []struct {
+		nodePrice int64
+		expected  int64
+	}{
+		// 12% above the replaced price
+		{5 * gwei, 11200000000},
+		// the node price when it is higher
+		{20 * gwei, 20 * gwei},
+	}
+	for _, test := range tests {
+		eth.set(func() { eth.gasPrice = test.nodePrice })
+		replacement, err := s.replace(original.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replacement.Nonce() != 3 || replacement.GasPrice().Int64() != test.expected {
+			t.Errorf("expected nonce 3 at price %d, got nonce %d at %s", test.expected, replacement.Nonce(), replacement.GasPrice())
+		}
+		if sent := eth.lastSent(); sent == nil || sent.Hash() != replacement.Hash() {
+			t.Errorf("expected the replacement %s sent, got %v", replacement.Hash().Hex(), sent)
+		}
+	}
+
+	// a replacement already sent through another node is fine
+	eth.set(func() { eth.sendErr = errors.New("already known") })
+	if _, err := s.replace(original.Hash()); err != nil {
+		t.Errorf("expected a known replacement to be sent, got %s", err)
+	}
+
+	eth.set(func() {
+		eth.sendErr = nil
+		eth.gasPrice = 5 * gwei
+	})
+	last := eth.lastSent()
+	params.MaxGasPrice = big.NewInt(11 * gwei)
+	if _, err := s.replace(original.Hash()); err == nil {
+		t.Error("expected a replacement above the max gas price to fail")
+	}
+	if eth.lastSent() != last {
+		t.Error("replacement above the max gas price was sent")
+	}
+
+	params.MaxGasPrice = nil
+	eth.set(func() {
+		delete(eth.pending, original.Hash())
+		eth.mined[original.Hash()] = original
+	})
+	if _, err := s.replace(original.Hash()); err != ErrNotPending {
+		t.Errorf("expected %v for a mined transaction, got %v", ErrNotPending, err)
+	}
+}
@@ -7,6 +7,11 @@ import (
 )
 
 type Updater interface {
+	EpochData(opts *bind.CallOpts, arg0 *big.Int) (struct {
+		MerkleRoot             *big.Int
+		FullSizeIn128Resultion uint64
+		BranchDepth            uint64
+	}, error)
 	SetEpochData(opts *bind.TransactOpts, merkleRoot *big.Int, fullSizeIn128Resolution uint64, branchDepth uint64, epoch *big.Int) (*types.Transaction, error)
 	VerifyExtraData(opts *bind.CallOpts, extraData [32]byte, minerId [32]byte, difficulty *big.Int) (bool, error)
 	VerifyExtraData_debug(opts *bind.CallOpts, extraData [32]byte, minerId [32]byte, difficulty *big.Int) (*big.Int, error)
@@ -1,23 +1,34 @@
 package contract
 
 import (
+	"../node"
 	"../params"
 	"fmt"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"log"
 	"math/big"
-	"os"
 )
 
 type UpdaterClient struct {
-	contract   Updater
-	transactor *bind.TransactOpts
+	contract Updater
+	sender   *sender
 }
 
 func (uc UpdaterClient) SetEpochData(merkleRoot *big.Int, fullSizeIn128Resolution uint64, branchDepth uint64, epoch *big.Int) (*types.Transaction, error) {
-	return uc.contract.SetEpochData(uc.transactor, merkleRoot, fullSizeIn128Resolution, branchDepth, epoch)
+	return uc.sender.transact(func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return uc.contract.SetEpochData(opts, merkleRoot, fullSizeIn128Resolution, branchDepth, epoch)
+	})
+}
+
+// EpochData returns what the contract has for epoch, a zero root if
+// nothing was set.
+func (uc UpdaterClient) EpochData(epoch *big.Int) (merkleRoot *big.Int, fullSizeIn128Resolution uint64, branchDepth uint64, err error) {
+	data, err := uc.contract.EpochData(nil, epoch)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return data.MerkleRoot, data.FullSizeIn128Resultion, data.BranchDepth, nil
 }
 
 func (uc UpdaterClient) VerifyExtraData(extraData [32]byte, minerId [32]byte, difficulty *big.Int) (bool, error) {
@@ -36,32 +47,20 @@ func (uc UpdaterClient) To62Encoding(id *big.Int, numChars *big.Int) ([32]byte,
 	return uc.contract.To62Encoding(nil, id, numChars)
 }
 
-func NewUpdaterClient() *UpdaterClient {
-	client, err := getClient()
+// NewUpdaterClient binds the contract to the nodes of m. The contract
+// follows m when it switches node.
+func NewUpdaterClient(m *node.Manager) (*UpdaterClient, error) {
+	pool, err := newFailoverContract(common.HexToAddress(params.ContractAddress), m)
 	if err != nil {
-		log.Fatalf("Couldn't connect to Geth via IPC file. Error: %s\n", err)
-		return nil
-	}
-	pool, err := NewTestPool(common.HexToAddress(params.ContractAddress), client)
-	if err != nil {
-		log.Fatalf("Couldn't get SmartPool information from Ethereum Blockchain. Error: %s\n", err)
-		return nil
-	}
-	account := GetAccount()
-	if account == nil {
-		log.Fatalf("Couldn't get any account from key store.\n")
-		return nil
+		return nil, fmt.Errorf("couldn't get SmartPool information from Ethereum Blockchain: %s", err)
 	}
-	fmt.Printf("Key: %s\n", account.KeyFile())
-	keyio, err := os.Open(account.KeyFile())
+	auth, err := Transactor()
 	if err != nil {
-		log.Fatalf("Failed to open key file: %s\n", err)
-		return nil
+		return nil, err
 	}
-	auth, err := bind.NewTransactor(keyio, account.PassPhrase())
+	s, err := newSender(auth, m)
 	if err != nil {
-		log.Fatalf("Failed to create authorized transactor: %s\n", err)
-		return nil
+		return nil, err
 	}
-	return &UpdaterClient{pool, auth}
+	return &UpdaterClient{pool, s}, nil
 }
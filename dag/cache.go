@@ -0,0 +1,156 @@
+// Package dag gives access to the ethash dataset of an epoch and to the
+// merkle tree of it the contract verifies shares with.
+package dag
+
+import (
+	"../ethash"
+	"../mtree"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const epochLength = 30000
+
+var (
+	// cacheMu only guards the map, trees are built without it
+	cacheMu sync.Mutex
+	caches  = map[uint64]*merkleCache{}
+)
+
+// merkleCache is the merkle tree of an epoch. It is opened or built by
+// the first caller asking for the epoch, later ones wait for done.
+type merkleCache struct {
+	done chan struct{} // closed once dc or err is set
+	dc   *mtree.DagCache
+	err  error
+	// callers which haven't released the tree yet, evicted trees are
+	// closed by the last of them. Both are guarded by cacheMu.
+	refs    int
+	evicted bool
+}
+
+// release must be called with cacheMu held.
+func (mc *merkleCache) release() {
+	mc.refs--
+	if mc.refs == 0 && mc.evicted && mc.dc != nil {
+		mc.dc.Close()
+	}
+}
+
+func (mc *merkleCache) ready() bool {
+	select {
+	case <-mc.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// CachePath returns where the merkle tree of the dataset of the block's
+// epoch is kept, next to the dataset.
+func CachePath(blockNumber uint64) (string, error) {
+	seed, err := seedPrefix(blockNumber)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(ethash.DefaultDir, fmt.Sprintf("merkle-R%d-%s", ethash.Revision, seed)), nil
+}
+
+// MerkleCache returns the merkle tree of the dataset of the block's
+// epoch. The first call for an epoch generates the dataset and builds
+// the tree if they are not on disk yet, which takes minutes, later
+// calls return the same cache. Trees of other epochs can be got while
+// one is built. The caller must call release once it is done with the
+// tree, it is not closed before.
+func MerkleCache(blockNumber uint64) (dc *mtree.DagCache, release func(), err error) {
+	epoch := blockNumber / epochLength
+	cacheMu.Lock()
+	mc := caches[epoch]
+	build := mc == nil
+	if build {
+		mc = &merkleCache{done: make(chan struct{})}
+		caches[epoch] = mc
+	}
+	mc.refs++
+	cacheMu.Unlock()
+
+	if build {
+		mc.dc, mc.err = loadCache(blockNumber)
+		close(mc.done)
+	} else {
+		<-mc.done
+	}
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if mc.err != nil {
+		mc.release()
+		// let the next call try again
+		if caches[epoch] == mc {
+			delete(caches, epoch)
+		}
+		return nil, nil, mc.err
+	}
+	if build {
+		evictCaches(epoch)
+	}
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			cacheMu.Lock()
+			defer cacheMu.Unlock()
+			mc.release()
+		})
+	}
+	return mc.dc, release, nil
+}
+
+// loadCache opens the merkle tree of the block's epoch from disk or
+// builds it.
+func loadCache(blockNumber uint64) (*mtree.DagCache, error) {
+	path, err := CachePath(blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	dc, err := mtree.OpenDagCache(path)
+	if err == nil {
+		return dc, nil
+	}
+	if !os.IsNotExist(err) {
+		fmt.Printf("Rebuilding dag merkle tree of epoch %d: %s\n", blockNumber/epochLength, err)
+	}
+	return buildCache(blockNumber, path)
+}
+
+// evictCaches drops the caches of the epochs before the one preceding
+// epoch, shares of them are too old to be claimed. They are closed once
+// released by their callers. Caches still being built are left to
+// their builder. It must be called with cacheMu held.
+func evictCaches(epoch uint64) {
+	for e, mc := range caches {
+		if e+1 < epoch && mc.ready() {
+			mc.evicted = true
+			if mc.refs == 0 && mc.dc != nil {
+				mc.dc.Close()
+			}
+			delete(caches, e)
+		}
+	}
+}
+
+func buildCache(blockNumber uint64, path string) (*mtree.DagCache, error) {
+	ds, err := GenerateDataset(blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	defer ds.Close()
+	fmt.Printf("Building dag merkle tree of epoch %d...\n", blockNumber/epochLength)
+	dc, err := mtree.BuildDagCache(path, ds, ds.Rows())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't build the merkle tree of epoch %d: %s", blockNumber/epochLength, err)
+	}
+	fmt.Printf("Done.\n")
+	return dc, nil
+}
@@ -0,0 +1,101 @@
+package dag
+
+import (
+	"../common"
+	"../mtree"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEvictCaches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merkle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	saved := caches
+	caches = map[uint64]*merkleCache{}
+	defer func() { caches = saved }()
+	data := bytes.NewReader(make([]byte, testRows*common.WordLength))
+	for epoch := uint64(1); epoch < 4; epoch++ {
+		dc, err := mtree.BuildDagCache(filepath.Join(dir, fmt.Sprintf("merkle-%d", epoch)), data, testRows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mc := &merkleCache{done: make(chan struct{}), dc: dc}
+		close(mc.done)
+		caches[epoch] = mc
+	}
+	// a tree of an old epoch still being built
+	building := &merkleCache{done: make(chan struct{})}
+	caches[0] = building
+	// a proof still uses the tree of epoch 1
+	caches[1].refs = 1
+	used := caches[1]
+	evictCaches(3)
+	if _, err := used.dc.RootHash(); err != nil {
+		t.Errorf("evicted tree closed while used: %s", err)
+	}
+	used.release()
+	if _, err := used.dc.RootHash(); err == nil {
+		t.Error("expected the evicted tree closed once released")
+	}
+	if len(caches) != 3 || caches[0] != building || caches[2] == nil || caches[3] == nil {
+		t.Errorf("expected the caches of epochs 2 and 3 and the one being built kept, got %v", caches)
+	}
+}
+
+func TestMerkleCacheWhileBuilding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merkle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := bytes.NewReader(make([]byte, testRows*common.WordLength))
+	dc, err := mtree.BuildDagCache(filepath.Join(dir, "merkle"), data, testRows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close()
+	cacheMu.Lock()
+	saved := caches
+	built := &merkleCache{done: make(chan struct{}), dc: dc}
+	close(built.done)
+	building := &merkleCache{done: make(chan struct{})}
+	caches = map[uint64]*merkleCache{2: built, 3: building}
+	cacheMu.Unlock()
+	defer func() {
+		cacheMu.Lock()
+		caches = saved
+		cacheMu.Unlock()
+	}()
+
+	waiting := make(chan *mtree.DagCache)
+	go func() {
+		dc, release, _ := MerkleCache(3 * epochLength)
+		release()
+		waiting <- dc
+	}()
+	// the tree of epoch 3 is still built, epoch 2 is available
+	got, release, err := MerkleCache(2*epochLength + 1)
+	if err != nil || got != dc {
+		t.Fatalf("expected the tree of epoch 2, got %v, %v", got, err)
+	}
+	release()
+	select {
+	case <-waiting:
+		t.Fatal("got the tree of epoch 3 before it was built")
+	default:
+	}
+	building.dc = dc
+	close(building.done)
+	if got := <-waiting; got != dc {
+		t.Errorf("expected the tree built for epoch 3, got %v", got)
+	}
+}
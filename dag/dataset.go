@@ -0,0 +1,260 @@
+package dag
+
+import (
+	"../common"
+	"../ethash"
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// bytes before the first page of a dataset file
+	datasetHeaderLength = 8
+	// written by libethash in the byte order of the machine, see
+	// https://github.com/ethereum/wiki/wiki/Ethash-DAG-Disk-Storage-Format
+	datasetMagic uint64 = 0xFEE1DEADBADDCAFE
+)
+
+// DatasetPath returns where ethash keeps the dataset of the block's
+// epoch.
+func DatasetPath(blockNumber uint64) (string, error) {
+	seed, err := seedPrefix(blockNumber)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(ethash.DefaultDir, fmt.Sprintf("full-R%d-%s", ethash.Revision, seed)), nil
+}
+
+func seedPrefix(blockNumber uint64) (string, error) {
+	seedHash, err := ethash.GetSeedHash(blockNumber)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(seedHash[:8]), nil
+}
+
+// Dataset is the DAG file of an epoch. Its pages are read from memory
+// where the file can be mapped, from the file otherwise.
+type Dataset struct {
+	path string
+	f    *os.File
+	data []byte
+	rows uint32
+}
+
+// datasetSize returns the number of bytes of the dataset of the block's
+// epoch, tests replace it to use small datasets.
+var datasetSize = ethash.DatasetSize
+
+// OpenDataset opens the dataset of the block's epoch, checking its
+// magic number and that it has the size ethash expects.
+func OpenDataset(blockNumber uint64) (*Dataset, error) {
+	path, err := DatasetPath(blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return openDataset(path, datasetSize(blockNumber))
+}
+
+// GenerateDataset opens the dataset of the block's epoch, generating it
+// first if it is not on disk.
+func GenerateDataset(blockNumber uint64) (*Dataset, error) {
+	ds, err := OpenDataset(blockNumber)
+	if !os.IsNotExist(err) {
+		return ds, err
+	}
+	fmt.Printf("Generating DAG of epoch %d...\n", blockNumber/epochLength)
+	if err = ethash.MakeDAG(blockNumber, ""); err != nil {
+		return nil, err
+	}
+	return OpenDataset(blockNumber)
+}
+
+func openDataset(path string, size uint64) (*Dataset, error) {
+	if size%common.WordLength != 0 || size/common.WordLength > uint64(^uint32(0)) {
+		return nil, fmt.Errorf("dataset size %d is not a number of pages", size)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := checkDataset(path, f, size)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return ds, nil
+}
+
+func checkDataset(path string, f *os.File, size uint64) (*Dataset, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(info.Size()) != datasetHeaderLength+size {
+		return nil, fmt.Errorf("dataset %s is %d bytes, expected %d", path, info.Size(), datasetHeaderLength+size)
+	}
+	header := make([]byte, datasetHeaderLength)
+	if _, err = f.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("couldn't read the magic number of %s: %s", path, err)
+	}
+	if magic := binary.LittleEndian.Uint64(header); magic != datasetMagic {
+		return nil, fmt.Errorf("dataset %s has magic number 0x%x, expected 0x%x", path, magic, datasetMagic)
+	}
+	data, err := mmapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't map %s: %s", path, err)
+	}
+	return &Dataset{path, f, data, uint32(size / common.WordLength)}, nil
+}
+
+// Rows returns the number of pages of the dataset.
+func (ds *Dataset) Rows() uint32 { return ds.rows }
+
+// ReadAt reads the pages of the dataset, off 0 being the first byte of
+// the first page.
+func (ds *Dataset) ReadAt(p []byte, off int64) (int, error) {
+	size := int64(ds.rows) * common.WordLength
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d in %s", off, ds.path)
+	}
+	if off >= size {
+		return 0, io.EOF
+	}
+	if ds.data == nil {
+		if int64(len(p)) > size-off {
+			n, err := ds.f.ReadAt(p[:size-off], datasetHeaderLength+off)
+			if err == nil {
+				err = io.EOF
+			}
+			return n, err
+		}
+		return ds.f.ReadAt(p, datasetHeaderLength+off)
+	}
+	n := copy(p, ds.data[datasetHeaderLength+off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Item returns the page at index.
+func (ds *Dataset) Item(index uint32) (common.Word, error) {
+	w := common.Word{}
+	if index >= ds.rows {
+		return w, fmt.Errorf("page %d out of the %d pages of %s", index, ds.rows, ds.path)
+	}
+	if _, err := ds.ReadAt(w[:], int64(index)*common.WordLength); err != nil {
+		return w, fmt.Errorf("couldn't read page %d of %s: %s", index, ds.path, err)
+	}
+	return w, nil
+}
+
+// Items returns the pages at indices.
+func (ds *Dataset) Items(indices []uint32) ([]common.Word, error) {
+	result := make([]common.Word, len(indices))
+	for i, index := range indices {
+		w, err := ds.Item(index)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = w
+	}
+	return result, nil
+}
+
+// Iterator returns an iterator over the pages of the dataset in order.
+// It reads the file sequentially instead of going through the mapping.
+func (ds *Dataset) Iterator() *Iterator {
+	size := int64(ds.rows) * common.WordLength
+	return &Iterator{
+		path: ds.path,
+		r:    bufio.NewReaderSize(io.NewSectionReader(ds.f, datasetHeaderLength, size), 1<<20),
+		rows: ds.rows,
+	}
+}
+
+func (ds *Dataset) Close() error {
+	err := munmapFile(ds.data)
+	if cerr := ds.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Iterator streams the pages of a dataset:
+//
+//	it := ds.Iterator()
+//	for it.Next() {
+//		index, page := it.Item()
+//	}
+//	if it.Err() != nil { ... }
+type Iterator struct {
+	path  string
+	r     *bufio.Reader
+	rows  uint32
+	index uint32
+	item  common.Word
+	err   error
+}
+
+// Next reads the next page, it returns false after the last one or on
+// an error.
+func (it *Iterator) Next() bool {
+	if it.err != nil || it.index >= it.rows {
+		return false
+	}
+	if _, err := io.ReadFull(it.r, it.item[:]); err != nil {
+		it.err = fmt.Errorf("couldn't read page %d of %s: %s", it.index, it.path, err)
+		return false
+	}
+	it.index++
+	return true
+}
+
+// Item returns the page read by the last call to Next and its index.
+func (it *Iterator) Item() (uint32, common.Word) {
+	return it.index - 1, it.item
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// light computes pages when the dataset is not on disk
+var light = new(ethash.Light)
+
+// Items returns the pages at indices of the dataset of the block's
+// epoch. They are read from the dataset when it is on disk and computed
+// from the light cache otherwise, the dataset itself is needed only to
+// build the merkle tree of the epoch.
+func Items(blockNumber uint64, indices []uint32) ([]common.Word, error) {
+	ds, err := OpenDataset(blockNumber)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Computing dataset pages from the light cache: %s\n", err)
+		}
+		return LightItems(blockNumber, indices)
+	}
+	defer ds.Close()
+	return ds.Items(indices)
+}
+
+// LightItems computes the pages at indices of the dataset of the
+// block's epoch from the light cache.
+func LightItems(blockNumber uint64, indices []uint32) ([]common.Word, error) {
+	items, err := light.DatasetItems(blockNumber, indices)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]common.Word, len(items))
+	for i, item := range items {
+		copy(result[i][:], item)
+	}
+	return result, nil
+}
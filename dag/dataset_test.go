@@ -0,0 +1,98 @@
+package dag
+
+import (
+	"../common"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testRows = 10
+
+// writeTestDataset writes a dataset of testRows pages, the bytes of
+// page i all being i.
+func writeTestDataset(t *testing.T, magic uint64, extra int) string {
+	dir, err := ioutil.TempDir("", "dataset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "full")
+	writeDatasetFile(t, path, magic, extra)
+	return path
+}
+
+func writeDatasetFile(t *testing.T, path string, magic uint64, extra int) {
+	data := make([]byte, datasetHeaderLength, datasetHeaderLength+testRows*common.WordLength+extra)
+	binary.LittleEndian.PutUint64(data, magic)
+	for i := 0; i < testRows; i++ {
+		for j := 0; j < common.WordLength; j++ {
+			data = append(data, byte(i))
+		}
+	}
+	data = append(data, make([]byte, extra)...)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatasetItems(t *testing.T) {
+	path := writeTestDataset(t, datasetMagic, 0)
+	defer os.RemoveAll(filepath.Dir(path))
+	ds, err := openDataset(path, testRows*common.WordLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	if ds.Rows() != testRows {
+		t.Errorf("expected %d rows, got %d", testRows, ds.Rows())
+	}
+	items, err := ds.Items([]uint32{7, 0, 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, index := range []byte{7, 0, 9} {
+		if items[i][0] != index || items[i][common.WordLength-1] != index {
+			t.Errorf("item %d is not page %d", i, index)
+		}
+	}
+	if _, err = ds.Item(testRows); err == nil {
+		t.Errorf("expected page %d to be out of the dataset", testRows)
+	}
+	buf := make([]byte, 2*common.WordLength)
+	if n, err := ds.ReadAt(buf, (testRows-1)*common.WordLength); n != common.WordLength || err != io.EOF {
+		t.Errorf("expected to read the last page then EOF, got %d bytes and %v", n, err)
+	}
+
+	it := ds.Iterator()
+	count := 0
+	for it.Next() {
+		index, page := it.Item()
+		if index != uint32(count) || page[0] != byte(count) {
+			t.Errorf("iterator returned page %d with %d at %d", index, page[0], count)
+		}
+		count++
+	}
+	if it.Err() != nil || count != testRows {
+		t.Errorf("iterator stopped after %d pages: %v", count, it.Err())
+	}
+}
+
+func TestDatasetValidation(t *testing.T) {
+	path := writeTestDataset(t, 0x1234, 0)
+	defer os.RemoveAll(filepath.Dir(path))
+	if _, err := openDataset(path, testRows*common.WordLength); err == nil {
+		t.Errorf("expected a wrong magic number to be rejected")
+	}
+
+	path = writeTestDataset(t, datasetMagic, 100)
+	defer os.RemoveAll(filepath.Dir(path))
+	if _, err := openDataset(path, testRows*common.WordLength); err == nil {
+		t.Errorf("expected a dataset of the wrong size to be rejected")
+	}
+	if _, err := openDataset(filepath.Join(filepath.Dir(path), "missing"), testRows*common.WordLength); !os.IsNotExist(err) {
+		t.Errorf("expected a missing dataset to be reported as such, got %v", err)
+	}
+}
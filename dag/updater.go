@@ -0,0 +1,268 @@
+package dag
+
+import (
+	"../client"
+	"../params"
+	"../txs"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EpochData is what the contract needs to verify shares of an epoch.
+type EpochData struct {
+	MerkleRoot              *big.Int
+	FullSizeIn128Resolution uint64
+	BranchDepth             uint64
+}
+
+func (d EpochData) Equal(o EpochData) bool {
+	return d.MerkleRoot != nil && o.MerkleRoot != nil && d.MerkleRoot.Cmp(o.MerkleRoot) == 0 &&
+		d.FullSizeIn128Resolution == o.FullSizeIn128Resolution && d.BranchDepth == o.BranchDepth
+}
+
+// EpochDataOf builds the merkle tree of the block's epoch if needed and
+// returns the epoch data of it.
+func EpochDataOf(blockNumber uint64) (EpochData, error) {
+	dc, release, err := MerkleCache(blockNumber)
+	if err != nil {
+		return EpochData{}, err
+	}
+	defer release()
+	root, err := dc.RootHash()
+	if err != nil {
+		return EpochData{}, err
+	}
+	return EpochData{root.Big(), uint64(dc.Rows()), uint64(dc.Depth())}, nil
+}
+
+// EpochContract is the part of the pool contract the updater uses, see
+// contract.UpdaterClient.
+type EpochContract interface {
+	EpochData(epoch *big.Int) (merkleRoot *big.Int, fullSizeIn128Resolution uint64, branchDepth uint64, err error)
+	SetEpochData(merkleRoot *big.Int, fullSizeIn128Resolution uint64, branchDepth uint64, epoch *big.Int) (*types.Transaction, error)
+}
+
+type EpochState int
+
+const (
+	// dataset and merkle tree of the epoch are being built
+	EpochPreparing EpochState = iota
+	// SetEpochData tx is pending
+	EpochSubmitted
+	// contract has the epoch data
+	EpochUpToDate
+	// last attempt failed, it is retried on the next check
+	EpochFailed
+)
+
+var epochStateNames = map[EpochState]string{
+	EpochPreparing: "preparing",
+	EpochSubmitted: "submitted",
+	EpochUpToDate:  "up to date",
+	EpochFailed:    "failed",
+}
+
+func (s EpochState) String() string {
+	if name, ok := epochStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// EpochStatus is what the updater knows of an epoch.
+type EpochStatus struct {
+	Epoch uint64
+	State EpochState
+	// nil until the merkle tree of the epoch is built
+	Data *EpochData
+	// last SetEpochData tx, zero if none was sent
+	TxHash  common.Hash
+	Error   string
+	Updated time.Time
+}
+
+var DefaultEpochUpdater *EpochUpdater
+
+// EpochUpdater keeps the epoch data of the contract in line with the
+// chain head. It checks the epoch of the head and, once the head is
+// ahead blocks from the next epoch, that epoch too, so its dataset is
+// generated and its data is on chain before the first share of it.
+// Epoch data is submitted only when the contract has none or wrong
+// data for the epoch.
+type EpochUpdater struct {
+	contract    EpochContract
+	blockNumber func() (uint64, error)
+	epochData   func(blockNumber uint64) (EpochData, error)
+	wait        func(tx *types.Transaction) (*txs.TxResult, error)
+	pending     func(h common.Hash) (bool, error)
+	ahead       uint64
+
+	mu     sync.Mutex
+	status map[uint64]*EpochStatus
+}
+
+func waitEpochTx(tx *types.Transaction) (*txs.TxResult, error) {
+	return txs.NewTxWatcher(tx).WaitFor(params.TxTimeout)
+}
+
+func NewEpochUpdater(c EpochContract, ahead uint64) *EpochUpdater {
+	return &EpochUpdater{
+		contract:    c,
+		blockNumber: func() (uint64, error) { return client.DefaultGethClient.GetBlockNumber() },
+		epochData:   EpochDataOf,
+		wait:        waitEpochTx,
+		pending:     func(h common.Hash) (bool, error) { return client.DefaultGethClient.IsPending(h) },
+		ahead:       ahead,
+		status:      map[uint64]*EpochStatus{},
+	}
+}
+
+// Statuses returns the status of every epoch checked so far, oldest
+// first.
+func (u *EpochUpdater) Statuses() []EpochStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	result := []EpochStatus{}
+	for _, s := range u.status {
+		result = append(result, *s)
+	}
+	sort.Sort(epochStatusesByEpoch(result))
+	return result
+}
+
+type epochStatusesByEpoch []EpochStatus
+
+func (s epochStatusesByEpoch) Len() int           { return len(s) }
+func (s epochStatusesByEpoch) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s epochStatusesByEpoch) Less(i, j int) bool { return s[i].Epoch < s[j].Epoch }
+
+// setStatus records the state of epoch and prints it when it changes.
+func (u *EpochUpdater) setStatus(epoch uint64, state EpochState, data *EpochData, txHash common.Hash, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	s := u.status[epoch]
+	if s == nil {
+		s = &EpochStatus{Epoch: epoch, State: -1}
+		u.status[epoch] = s
+	}
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	if s.State != state || s.Error != message {
+		if err != nil {
+			fmt.Printf("Epoch %d: %s, %s\n", epoch, state, message)
+		} else {
+			fmt.Printf("Epoch %d: %s\n", epoch, state)
+		}
+	}
+	s.State = state
+	s.Error = message
+	if data != nil {
+		s.Data = data
+	}
+	if txHash != (common.Hash{}) {
+		s.TxHash = txHash
+	}
+	s.Updated = time.Now()
+}
+
+func (u *EpochUpdater) prepared(epoch uint64) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.status[epoch] != nil && u.status[epoch].Data != nil
+}
+
+// lastTx returns the last SetEpochData tx of epoch, zero if none was
+// sent.
+func (u *EpochUpdater) lastTx(epoch uint64) common.Hash {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if s := u.status[epoch]; s != nil {
+		return s.TxHash
+	}
+	return common.Hash{}
+}
+
+// Check updates the epochs the head needs.
+func (u *EpochUpdater) Check() {
+	head, err := u.blockNumber()
+	if err != nil {
+		fmt.Printf("Couldn't get the head block to update epoch data: %s\n", err)
+		return
+	}
+	epoch := head / epochLength
+	u.update(epoch)
+	if head%epochLength+u.ahead >= epochLength {
+		u.update(epoch + 1)
+	}
+}
+
+func (u *EpochUpdater) update(epoch uint64) {
+	if !u.prepared(epoch) {
+		u.setStatus(epoch, EpochPreparing, nil, common.Hash{}, nil)
+	}
+	data, err := u.epochData(epoch * epochLength)
+	if err != nil {
+		u.setStatus(epoch, EpochFailed, nil, common.Hash{}, fmt.Errorf("couldn't build the epoch data: %s", err))
+		return
+	}
+	root, fullSize, depth, err := u.contract.EpochData(new(big.Int).SetUint64(epoch))
+	if err != nil {
+		u.setStatus(epoch, EpochFailed, &data, common.Hash{}, fmt.Errorf("couldn't read the epoch data of the contract: %s", err))
+		return
+	}
+	if data.Equal(EpochData{root, fullSize, depth}) {
+		u.setStatus(epoch, EpochUpToDate, &data, common.Hash{}, nil)
+		return
+	}
+	// a tx that wasn't mined in time may still be
+	if h := u.lastTx(epoch); h != (common.Hash{}) {
+		pending, err := u.pending(h)
+		if err != nil {
+			u.setStatus(epoch, EpochFailed, &data, common.Hash{}, fmt.Errorf("couldn't check tx 0x%x: %s", h, err))
+			return
+		}
+		if pending {
+			u.setStatus(epoch, EpochSubmitted, &data, common.Hash{}, nil)
+			return
+		}
+	}
+	if root != nil && root.Sign() != 0 {
+		fmt.Printf("Epoch %d: contract has root 0x%s, %d pages, depth %d, expected 0x%s, %d pages, depth %d\n",
+			epoch, root.Text(16), fullSize, depth, data.MerkleRoot.Text(16), data.FullSizeIn128Resolution, data.BranchDepth)
+	}
+	tx, err := u.contract.SetEpochData(data.MerkleRoot, data.FullSizeIn128Resolution, data.BranchDepth, new(big.Int).SetUint64(epoch))
+	if err != nil {
+		u.setStatus(epoch, EpochFailed, &data, common.Hash{}, fmt.Errorf("couldn't submit the epoch data: %s", err))
+		return
+	}
+	u.setStatus(epoch, EpochSubmitted, &data, tx.Hash(), nil)
+	result, err := u.wait(tx)
+	if err != nil {
+		u.setStatus(epoch, EpochFailed, &data, tx.Hash(), err)
+		return
+	}
+	if !result.Succeeded() {
+		u.setStatus(epoch, EpochFailed, &data, tx.Hash(), fmt.Errorf("tx 0x%x reverted", tx.Hash()))
+		return
+	}
+	u.setStatus(epoch, EpochUpToDate, &data, tx.Hash(), nil)
+}
+
+// Run checks the epochs every interval, it never returns.
+func (u *EpochUpdater) Run(interval time.Duration) {
+	for {
+		u.Check()
+		time.Sleep(interval)
+	}
+}
+
+func (u *EpochUpdater) Start(interval time.Duration) {
+	go u.Run(interval)
+}
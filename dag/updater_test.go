@@ -0,0 +1,207 @@
+package dag
+
+import (
+	spcommon "../common"
+	"../ethash"
+	"../txs"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+)
+
+type fakeEpochContract struct {
+	data      map[uint64]EpochData
+	submitted []uint64
+	fail      bool
+}
+
+func (c *fakeEpochContract) EpochData(epoch *big.Int) (*big.Int, uint64, uint64, error) {
+	d, ok := c.data[epoch.Uint64()]
+	if !ok {
+		return big.NewInt(0), 0, 0, nil
+	}
+	return d.MerkleRoot, d.FullSizeIn128Resolution, d.BranchDepth, nil
+}
+
+func (c *fakeEpochContract) SetEpochData(merkleRoot *big.Int, fullSizeIn128Resolution uint64, branchDepth uint64, epoch *big.Int) (*types.Transaction, error) {
+	if c.fail {
+		return nil, errors.New("node is down")
+	}
+	c.submitted = append(c.submitted, epoch.Uint64())
+	c.data[epoch.Uint64()] = EpochData{merkleRoot, fullSizeIn128Resolution, branchDepth}
+	return types.NewTransaction(uint64(len(c.submitted)), common.Address{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), nil), nil
+}
+
+func testEpochData(blockNumber uint64) (EpochData, error) {
+	epoch := blockNumber / epochLength
+	return EpochData{big.NewInt(int64(1000 + epoch)), 100 + epoch, 7}, nil
+}
+
+func testUpdater(c *fakeEpochContract, head *uint64) *EpochUpdater {
+	return &EpochUpdater{
+		contract:    c,
+		blockNumber: func() (uint64, error) { return *head, nil },
+		epochData:   testEpochData,
+		wait: func(tx *types.Transaction) (*txs.TxResult, error) {
+			return &txs.TxResult{Hash: tx.Hash(), Status: txs.TxSucceeded}, nil
+		},
+		pending: func(h common.Hash) (bool, error) { return false, nil },
+		ahead:   1000,
+		status:  map[uint64]*EpochStatus{},
+	}
+}
+
+func TestEpochUpdaterSubmitsMissingAndWrongData(t *testing.T) {
+	c := &fakeEpochContract{data: map[uint64]EpochData{}}
+	head := uint64(epochLength + 10)
+	u := testUpdater(c, &head)
+
+	u.Check()
+	if len(c.submitted) != 1 || c.submitted[0] != 1 {
+		t.Fatalf("expected epoch 1 to be submitted, got %v", c.submitted)
+	}
+	u.Check()
+	if len(c.submitted) != 1 {
+		t.Errorf("expected epoch 1 not to be submitted again, got %v", c.submitted)
+	}
+
+	// the next epoch is prepared ahead of its first block
+	head = 2*epochLength - 500
+	c.data[2] = EpochData{big.NewInt(1), 1, 1}
+	u.Check()
+	if len(c.submitted) != 2 || c.submitted[1] != 2 {
+		t.Fatalf("expected wrong data of epoch 2 to be replaced, got %v", c.submitted)
+	}
+	statuses := u.Statuses()
+	if len(statuses) != 2 || statuses[0].Epoch != 1 || statuses[1].Epoch != 2 {
+		t.Fatalf("expected the status of epochs 1 and 2, got %v", statuses)
+	}
+	for _, s := range statuses {
+		if s.State != EpochUpToDate || s.TxHash == (common.Hash{}) {
+			t.Errorf("epoch %d is %s with tx %x", s.Epoch, s.State, s.TxHash)
+		}
+	}
+}
+
+func TestEpochUpdaterKeepsUpToDateData(t *testing.T) {
+	current, _ := testEpochData(3 * epochLength)
+	c := &fakeEpochContract{data: map[uint64]EpochData{3: current}}
+	head := uint64(3*epochLength + 10)
+	u := testUpdater(c, &head)
+	u.Check()
+	if len(c.submitted) != 0 {
+		t.Errorf("expected nothing to be submitted, got %v", c.submitted)
+	}
+	if s := u.Statuses(); len(s) != 1 || s[0].State != EpochUpToDate || s[0].Data == nil {
+		t.Errorf("expected epoch 3 to be up to date, got %v", s)
+	}
+}
+
+func TestEpochUpdaterRetriesFailedSubmission(t *testing.T) {
+	c := &fakeEpochContract{data: map[uint64]EpochData{}, fail: true}
+	head := uint64(10)
+	u := testUpdater(c, &head)
+	u.Check()
+	if s := u.Statuses(); len(s) != 1 || s[0].State != EpochFailed || s[0].Error == "" {
+		t.Fatalf("expected epoch 0 to fail, got %v", s)
+	}
+	c.fail = false
+	u.Check()
+	if s := u.Statuses(); s[0].State != EpochUpToDate || s[0].Error != "" {
+		t.Errorf("expected epoch 0 to be up to date after a retry, got %v", s)
+	}
+}
+
+func TestEpochUpdaterWaitsForPendingSubmission(t *testing.T) {
+	c := &fakeEpochContract{data: map[uint64]EpochData{}}
+	head := uint64(10)
+	u := testUpdater(c, &head)
+	u.wait = func(tx *types.Transaction) (*txs.TxResult, error) {
+		return nil, errors.New("timed out")
+	}
+	pending := true
+	u.pending = func(h common.Hash) (bool, error) { return pending, nil }
+	u.Check()
+	// the tx is not mined yet
+	delete(c.data, 0)
+	u.Check()
+	if len(c.submitted) != 1 {
+		t.Fatalf("expected epoch 0 not to be submitted while its tx is pending, got %v", c.submitted)
+	}
+	if s := u.Statuses(); s[0].State != EpochSubmitted {
+		t.Errorf("expected epoch 0 to wait for its tx, got %s", s[0].State)
+	}
+	// the node dropped the tx
+	pending = false
+	u.Check()
+	if len(c.submitted) != 2 {
+		t.Errorf("expected epoch 0 to be submitted again, got %v", c.submitted)
+	}
+}
+
+// The updater builds the merkle trees of test datasets on disk while a
+// proof holds the tree of an epoch the updater evicts.
+func TestEpochUpdaterBuildsMerkleTrees(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ethash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	savedDir, savedSize := ethash.DefaultDir, datasetSize
+	ethash.DefaultDir = dir
+	datasetSize = func(uint64) uint64 { return testRows * spcommon.WordLength }
+	cacheMu.Lock()
+	saved := caches
+	caches = map[uint64]*merkleCache{}
+	cacheMu.Unlock()
+	defer func() {
+		cacheMu.Lock()
+		for _, mc := range caches {
+			if mc.dc != nil {
+				mc.dc.Close()
+			}
+		}
+		caches = saved
+		cacheMu.Unlock()
+		ethash.DefaultDir, datasetSize = savedDir, savedSize
+	}()
+	for _, epoch := range []uint64{0, 3} {
+		path, err := DatasetPath(epoch * epochLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeDatasetFile(t, path, datasetMagic, 0)
+	}
+
+	c := &fakeEpochContract{data: map[uint64]EpochData{}}
+	head := uint64(10)
+	u := testUpdater(c, &head)
+	u.epochData = EpochDataOf
+	u.Check()
+	// a proof of a share of epoch 0 holds its tree
+	dc, release, err := MerkleCache(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head = 3*epochLength + 10
+	u.Check()
+	if len(c.submitted) != 2 || c.submitted[0] != 0 || c.submitted[1] != 3 {
+		t.Fatalf("expected epochs 0 and 3 to be submitted, got %v", c.submitted)
+	}
+	for _, s := range u.Statuses() {
+		if s.State != EpochUpToDate || s.Data == nil || s.Data.FullSizeIn128Resolution != testRows {
+			t.Errorf("expected epoch %d up to date with %d pages, got %s, %v", s.Epoch, testRows, s.State, s.Data)
+		}
+	}
+	if _, err := dc.RootHash(); err != nil {
+		t.Fatalf("tree of epoch 0 closed while used: %s", err)
+	}
+	release()
+	if _, err := dc.RootHash(); err == nil {
+		t.Error("expected the tree of epoch 0 closed once released")
+	}
+}
@@ -57,6 +57,9 @@ const (
 	dagSizeForTesting   C.uint64_t = 1024 * 32
 )
 
+// Revision of the ethash algorithm, DAG file names start with it.
+const Revision = C.ETHASH_REVISION
+
 var DefaultDir = defaultDir()
 
 func defaultDir() string {
@@ -119,6 +122,20 @@ func (cache *cache) compute(dagSize uint64, hash common.Hash, nonce uint64) (ok
 	return bool(ret.success), h256ToHash(ret.mix_hash), h256ToHash(ret.result)
 }
 
+// datasetItem computes the 128 bytes page at index of the full dataset
+// from its two 64 bytes nodes, laid out as in the DAG file.
+func (cache *cache) datasetItem(index uint32) []byte {
+	item := make([]byte, 0, C.ETHASH_MIX_BYTES)
+	var n C.node
+	for i := uint32(0); i < C.MIX_NODES; i++ {
+		C.ethash_calculate_dag_item(&n, C.uint32_t(index*C.MIX_NODES+i), cache.ptr)
+		item = append(item, C.GoBytes(unsafe.Pointer(&n), C.int(unsafe.Sizeof(n)))...)
+	}
+	// Make sure cache is live until after the C calls.
+	_ = cache
+	return item
+}
+
 // Light implements the Verify half of the proof of work. It uses a few small
 // in-memory caches to verify the nonces found by Full.
 type Light struct {
@@ -151,6 +168,31 @@ func (l *Light) GetVerificationIndices(block pow.Block) []uint32 {
 	return result
 }
 
+// DatasetItems computes the pages at indices of the full dataset of the
+// block's epoch from the light cache, so they can be had without
+// generating the dataset. Each page takes 512 lookups in the cache.
+func (l *Light) DatasetItems(blockNum uint64, indices []uint32) ([][]byte, error) {
+	if blockNum >= epochLength*2048 {
+		return nil, fmt.Errorf("block number too high, limit is %d", epochLength*2048)
+	}
+	dagSize := C.ethash_get_datasize(C.uint64_t(blockNum))
+	if l.test {
+		dagSize = dagSizeForTesting
+	}
+	rows := uint64(dagSize) / C.ETHASH_MIX_BYTES
+	for _, index := range indices {
+		if uint64(index) >= rows {
+			return nil, fmt.Errorf("page %d out of the %d pages of epoch %d", index, rows, blockNum/epochLength)
+		}
+	}
+	cache := l.getCache(blockNum)
+	result := make([][]byte, len(indices))
+	for i, index := range indices {
+		result[i] = cache.datasetItem(index)
+	}
+	return result, nil
+}
+
 func (l *Light) SolutionState(block pow.Block, shareDifficulty *big.Int) int {
 	// TODO: do ethash_quick_verify before getCache in order
 	// to prevent DOS attacks.
@@ -199,6 +241,26 @@ func (l *Light) SolutionState(block pow.Block, shareDifficulty *big.Int) int {
 	return 0
 }
 
+// Compute runs hashimoto with the light cache of the block's epoch and
+// returns the mix digest and the result for the given header hash and
+// nonce. It is used to recover the mix digest of solutions submitted
+// without one.
+func (l *Light) Compute(blockNum uint64, hash common.Hash, nonce uint64) (mixDigest, result common.Hash, err error) {
+	if blockNum >= epochLength*2048 {
+		return common.Hash{}, common.Hash{}, fmt.Errorf("block number too high, limit is %d", epochLength*2048)
+	}
+	cache := l.getCache(blockNum)
+	dagSize := C.ethash_get_datasize(C.uint64_t(blockNum))
+	if l.test {
+		dagSize = dagSizeForTesting
+	}
+	ok, mixDigest, result := cache.compute(uint64(dagSize), hash, nonce)
+	if !ok {
+		return common.Hash{}, common.Hash{}, errors.New("ethash light compute failed")
+	}
+	return mixDigest, result, nil
+}
+
 // Verify checks whether the block's nonce is valid.
 func (l *Light) Verify(block pow.Block) bool {
 	// TODO: do ethash_quick_verify before getCache in order
@@ -515,6 +577,12 @@ func NewForTesting() (*Ethash, error) {
 	return &Ethash{&Light{test: true}, &Full{Dir: dir, test: true}}, nil
 }
 
+// DatasetSize returns the size in bytes of the full dataset of the
+// block's epoch, without the magic number of its file.
+func DatasetSize(blockNum uint64) uint64 {
+	return uint64(C.ethash_get_datasize(C.uint64_t(blockNum)))
+}
+
 func GetSeedHash(blockNum uint64) ([]byte, error) {
 	if blockNum >= epochLength*2048 {
 		return nil, fmt.Errorf("block number too high, limit is %d", epochLength*2048)
@@ -24,8 +24,10 @@ import (
 	"log"
 	"math/big"
 	"os"
+	"runtime"
 	"sync"
 	"testing"
+	"unsafe"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -57,8 +59,8 @@ var validBlocks = []*testBlock{
 		hashNoNonce: common.HexToHash("372eca2454ead349c3df0ab5d00b0b706b23e49d469387db91811cee0358fc6d"),
 		difficulty:  big.NewInt(132416),
 		// nonce:       0x495732e0ed7a801c,
-		nonce:       0x495732e0ed7a801c,
-		mixDigest:   common.HexToHash("2f74cdeb198af0b9abe65d22d372e22fb2d474371774a9583c1cc427a07939f5"),
+		nonce:     0x495732e0ed7a801c,
+		mixDigest: common.HexToHash("2f74cdeb198af0b9abe65d22d372e22fb2d474371774a9583c1cc427a07939f5"),
 	},
 	// from proof of concept nine testnet, epoch 1
 	{
@@ -194,6 +196,31 @@ func TestEthashSearchAcrossEpoch(t *testing.T) {
 	}
 }
 
+func TestDatasetItemsMatchFullDAG(t *testing.T) {
+	eth, err := NewForTesting()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(eth.Full.Dir)
+
+	d := eth.Full.getDAG(0)
+	full := (*[1 << 20]byte)(d.Ptr())[:dagSizeForTesting:dagSizeForTesting]
+	indices := []uint32{0, 1, 100, uint32(dagSizeForTesting/128 - 1)}
+	items, err := eth.DatasetItems(0, indices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, index := range indices {
+		if !bytes.Equal(items[i], full[index*128:(index+1)*128]) {
+			t.Errorf("page %d computed from the cache differs from the DAG", index)
+		}
+	}
+	runtime.KeepAlive(d)
+	if _, err = eth.DatasetItems(0, []uint32{uint32(dagSizeForTesting / 128)}); err == nil {
+		t.Errorf("expected a page out of the dataset to fail")
+	}
+}
+
 func TestGetSeedHash(t *testing.T) {
 	seed0, err := GetSeedHash(0)
 	if err != nil {
@@ -0,0 +1,180 @@
+package mtree
+
+import (
+	"../common"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+)
+
+var dagCacheMagic = [8]byte{'s', 'p', 'd', 'a', 'g', 'm', 't', '1'}
+
+const dagCacheHeaderLength = 16
+
+// DagCache is a DagTree kept on disk with the hashes of all its nodes,
+// level by level from the leaves up, so branches of any index are read
+// instead of rebuilt from the whole dataset. Odd nodes of a level are
+// paired with themselves the way Finalize pads a DagTree.
+type DagCache struct {
+	f      *os.File
+	rows   uint32
+	levels []dagCacheLevel
+}
+
+type dagCacheLevel struct {
+	offset int64
+	count  uint32
+}
+
+// dagCacheLevels returns where each level of a tree of rows leaves
+// starts in the cache file.
+func dagCacheLevels(rows uint32) []dagCacheLevel {
+	levels := []dagCacheLevel{}
+	offset := int64(dagCacheHeaderLength)
+	for count := rows; ; count = (count + 1) / 2 {
+		levels = append(levels, dagCacheLevel{offset, count})
+		offset += int64(count) * common.HashLength
+		if count == 1 {
+			return levels
+		}
+	}
+}
+
+// BuildDagCache hashes the rows dataset pages of r and writes their
+// tree to path, see buildDagLevels.
+func BuildDagCache(path string, r io.ReaderAt, rows uint32) (*DagCache, error) {
+	if rows == 0 {
+		return nil, errors.New("dataset is empty")
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	err = writeDagCache(f, r, rows, dagChunkDepth)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	return OpenDagCache(path)
+}
+
+func writeDagCache(f *os.File, r io.ReaderAt, rows uint32, chunkDepth int) error {
+	levels := dagCacheLevels(rows)
+	if err := buildDagLevels(r, f, levels, chunkDepth, runtime.NumCPU()); err != nil {
+		return err
+	}
+	header := make([]byte, dagCacheHeaderLength)
+	copy(header, dagCacheMagic[:])
+	binary.LittleEndian.PutUint64(header[8:], uint64(rows))
+	_, err := f.WriteAt(header, 0)
+	return err
+}
+
+// OpenDagCache opens a cache written by BuildDagCache, checking its
+// header and size.
+func OpenDagCache(path string) (*DagCache, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, dagCacheHeaderLength)
+	if _, err = f.ReadAt(header, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("dag cache %s has no header: %s", path, err)
+	}
+	if string(header[:8]) != string(dagCacheMagic[:]) {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a dag cache", path)
+	}
+	rows := binary.LittleEndian.Uint64(header[8:])
+	if rows == 0 || rows > uint64(^uint32(0)) {
+		f.Close()
+		return nil, fmt.Errorf("dag cache %s has %d pages", path, rows)
+	}
+	levels := dagCacheLevels(uint32(rows))
+	root := levels[len(levels)-1]
+	size := root.offset + common.HashLength
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() != size {
+		f.Close()
+		return nil, fmt.Errorf("dag cache %s is %d bytes, expected %d", path, info.Size(), size)
+	}
+	return &DagCache{f: f, rows: uint32(rows), levels: levels}, nil
+}
+
+func (dc *DagCache) node(level int, index uint32) (DagData, error) {
+	h := DagData{}
+	offset := dc.levels[level].offset + int64(index)*common.HashLength
+	_, err := dc.f.ReadAt(h[:], offset)
+	return h, err
+}
+
+// Rows returns the number of dataset pages of the tree.
+func (dc *DagCache) Rows() uint32 { return dc.rows }
+
+// Depth returns the number of levels of branches.
+func (dc *DagCache) Depth() int { return len(dc.levels) - 1 }
+
+func (dc *DagCache) RootHash() (common.SPHash, error) {
+	h, err := dc.node(dc.Depth(), 0)
+	return common.SPHash(h), err
+}
+
+// Branch returns the siblings of the page at index from the leaf up.
+func (dc *DagCache) Branch(index uint32) ([]common.SPHash, error) {
+	if index >= dc.rows {
+		return nil, fmt.Errorf("index %d out of a dataset of %d pages", index, dc.rows)
+	}
+	result := []common.SPHash{}
+	for level := 0; level < dc.Depth(); level++ {
+		sibling := index ^ 1
+		if sibling >= dc.levels[level].count {
+			sibling = index
+		}
+		h, err := dc.node(level, sibling)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, common.SPHash(h))
+		index >>= 1
+	}
+	return result, nil
+}
+
+// AllBranchesArray returns the branches of indices the way
+// DagTree.AllBranchesArray does.
+func (dc *DagCache) AllBranchesArray(indices []uint32) ([]common.BranchElement, error) {
+	result := []common.BranchElement{}
+	for _, index := range indices {
+		hashes, err := dc.Branch(index)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i*2 < len(hashes); i++ {
+			upper := common.SPHash{}
+			if i*2+1 < len(hashes) {
+				upper = hashes[i*2+1]
+			}
+			result = append(result, common.BranchElementFromHash(upper, hashes[i*2]))
+		}
+	}
+	return result, nil
+}
+
+func (dc *DagCache) Close() error {
+	return dc.f.Close()
+}
@@ -0,0 +1,160 @@
+package mtree
+
+import (
+	"../common"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func testDatasetBytes(words []common.Word) []byte {
+	data := []byte{}
+	for _, w := range words {
+		data = append(data, w[:]...)
+	}
+	return data
+}
+
+// testDagCache builds the cache of words in subtrees of chunkDepth
+// levels.
+func testDagCache(tb testing.TB, words []common.Word, chunkDepth int) *DagCache {
+	dir, err := ioutil.TempDir("", "dagcache")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache")
+	f, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	err = writeDagCache(f, bytes.NewReader(testDatasetBytes(words)), uint32(len(words)), chunkDepth)
+	f.Close()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	dc, err := OpenDagCache(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return dc
+}
+
+func TestDagCacheMatchesDagTree(t *testing.T) {
+	for _, rows := range []int{1, 2, 5, 64, 100} {
+		words := testWords(rows)
+		indices := []uint32{0, uint32(rows / 2), uint32(rows - 1)}
+		dt := NewDagTree()
+		dt.RegisterIndex(indices...)
+		for i, w := range words {
+			dt.Insert(w, uint32(i))
+		}
+		dt.Finalize()
+		expected := dt.AllBranchesArray()
+
+		for _, chunkDepth := range []int{0, 2, dagChunkDepth} {
+			dc := testDagCache(t, words, chunkDepth)
+			defer dc.Close()
+			if dc.Rows() != uint32(rows) {
+				t.Errorf("%d rows: cache has %d", rows, dc.Rows())
+			}
+			root, err := dc.RootHash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if root != dt.RootHash() {
+				t.Errorf("%d rows, chunks of depth %d: root %s, expected %s", rows, chunkDepth, root.Hex(), dt.RootHash().Hex())
+			}
+			branches, err := dc.AllBranchesArray(indices)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(branches) != len(expected) {
+				t.Fatalf("%d rows: %d branch elements, expected %d", rows, len(branches), len(expected))
+			}
+			for i := range branches {
+				if branches[i] != expected[i] {
+					t.Errorf("%d rows, chunks of depth %d: branch element %d is %s, expected %s",
+						rows, chunkDepth, i, branches[i].Hex(), expected[i].Hex())
+				}
+			}
+		}
+	}
+}
+
+func TestDagCacheRejectsTruncatedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dagcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache")
+	data := testDatasetBytes(testWords(10))
+	dc, err := BuildDagCache(path, bytes.NewReader(data), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc.Close()
+	if err = os.Truncate(path, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenDagCache(path); err == nil {
+		t.Errorf("expected a truncated cache to be rejected")
+	}
+	if _, err = BuildDagCache(path, bytes.NewReader(data[:200]), 10); err == nil {
+		t.Errorf("expected a partial page to be rejected")
+	}
+}
+
+// benchmarkRows gives 16 chunks of dagChunkDepth and 256 of depth 8
+const benchmarkRows = 1 << 16
+
+func BenchmarkDagTreeRoot(b *testing.B) {
+	words := testWords(benchmarkRows)
+	b.SetBytes(benchmarkRows * common.WordLength)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dt := NewDagTree()
+		for j, w := range words {
+			dt.Insert(w, uint32(j))
+		}
+		dt.Finalize()
+		dt.RootHash()
+	}
+}
+
+// memWriterAt is a cache file kept in memory.
+type memWriterAt []byte
+
+func (m memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	return copy(m[off:], p), nil
+}
+
+// BenchmarkBuildDagLevels hashes the tree in memory so it compares with
+// BenchmarkDagTreeRoot, with one worker and with one per core.
+func BenchmarkBuildDagLevels(b *testing.B) {
+	r := bytes.NewReader(testDatasetBytes(testWords(benchmarkRows)))
+	levels := dagCacheLevels(benchmarkRows)
+	root := levels[len(levels)-1]
+	w := make(memWriterAt, root.offset+common.HashLength)
+	workerCounts := []int{1}
+	if n := runtime.NumCPU(); n > 1 {
+		workerCounts = append(workerCounts, n)
+	}
+	for _, chunkDepth := range []int{dagChunkDepth, 8} {
+		for _, workers := range workerCounts {
+			b.Run(fmt.Sprintf("depth%d-workers%d", chunkDepth, workers), func(b *testing.B) {
+				b.SetBytes(benchmarkRows * common.WordLength)
+				for i := 0; i < b.N; i++ {
+					if err := buildDagLevels(r, w, levels, chunkDepth, workers); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
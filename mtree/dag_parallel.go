@@ -0,0 +1,130 @@
+package mtree
+
+import (
+	"../common"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// pages of the subtrees hashed by one worker at a time, 512KB of
+// dataset
+const dagChunkDepth = 12
+
+// buildDagLevels hashes the dataset pages of r into the levels of their
+// tree and writes them to w. Aligned subtrees of 1 << chunkDepth
+// pages are built on workers goroutines, each writing its part of
+// every level, then the levels above them are built from their roots.
+// A level is hashed the same way whether its nodes come from one
+// subtree or several, so the tree is the one of a DagTree.
+func buildDagLevels(r io.ReaderAt, w io.WriterAt, levels []dagCacheLevel, chunkDepth, workers int) error {
+	if chunkDepth > len(levels)-1 {
+		chunkDepth = len(levels) - 1
+	}
+	chunks := levels[chunkDepth].count
+	roots := make([]DagData, chunks)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	jobs := make(chan uint32)
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range jobs {
+				root, err := buildDagChunk(r, w, levels, c, chunkDepth)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					continue
+				}
+				roots[c] = root
+			}
+		}()
+	}
+	for c := uint32(0); c < chunks && !failed(); c++ {
+		jobs <- c
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	nodes := roots
+	for level := chunkDepth + 1; level < len(levels); level++ {
+		nodes = hashDagLevel(nodes)
+		if err := writeDagLevel(w, levels[level], 0, nodes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildDagChunk builds the subtree of chunk c, depth levels high, and
+// returns its root.
+func buildDagChunk(r io.ReaderAt, w io.WriterAt, levels []dagCacheLevel, c uint32, depth int) (DagData, error) {
+	start := c << uint(depth)
+	count := levels[0].count - start
+	if count > 1<<uint(depth) {
+		count = 1 << uint(depth)
+	}
+	data := make([]byte, int(count)*common.WordLength)
+	if _, err := r.ReadAt(data, int64(start)*common.WordLength); err != nil {
+		return DagData{}, fmt.Errorf("couldn't read pages %d to %d: %s", start, start+count-1, err)
+	}
+	nodes := make([]DagData, count)
+	word := common.Word{}
+	for i := range nodes {
+		copy(word[:], data[i*common.WordLength:])
+		nodes[i] = _elementHash(word).(DagData)
+	}
+	if err := writeDagLevel(w, levels[0], start, nodes); err != nil {
+		return DagData{}, err
+	}
+	for level := 1; level <= depth; level++ {
+		nodes = hashDagLevel(nodes)
+		if err := writeDagLevel(w, levels[level], start>>uint(level), nodes); err != nil {
+			return DagData{}, err
+		}
+	}
+	return nodes[0], nil
+}
+
+// hashDagLevel returns the level above nodes, the last one being
+// paired with itself when they are odd.
+func hashDagLevel(nodes []DagData) []DagData {
+	result := make([]DagData, (len(nodes)+1)/2)
+	for i := range result {
+		left := nodes[i*2]
+		right := left
+		if i*2+1 < len(nodes) {
+			right = nodes[i*2+1]
+		}
+		result[i] = _hash(left, right).(DagData)
+	}
+	return result
+}
+
+// writeDagLevel writes nodes to level from index on.
+func writeDagLevel(w io.WriterAt, level dagCacheLevel, index uint32, nodes []DagData) error {
+	data := make([]byte, 0, len(nodes)*common.HashLength)
+	for _, n := range nodes {
+		data = append(data, n[:]...)
+	}
+	_, err := w.WriteAt(data, level.offset+int64(index)*common.HashLength)
+	return err
+}
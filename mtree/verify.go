@@ -0,0 +1,117 @@
+package mtree
+
+import (
+	"../common"
+	"../share"
+	"fmt"
+	"math/big"
+)
+
+// BranchError names the first level of a branch that doesn't check out.
+// Level 0 is the sibling of the leaf, the length of the branch is the
+// root.
+type BranchError struct {
+	Level  int
+	Reason string
+}
+
+func (e *BranchError) Error() string {
+	return fmt.Sprintf("level %d: %s", e.Level, e.Reason)
+}
+
+// word32 returns v as the 32 bytes word the contract reads.
+func word32(v *big.Int) ([]byte, error) {
+	if v == nil || v.Sign() < 0 || v.BitLen() > 256 {
+		return nil, fmt.Errorf("%v is not a uint256", v)
+	}
+	return msbPadding(v.Bytes(), 32), nil
+}
+
+// VerifyDagBranch checks that element is the leaf at index of the dag
+// tree of root. branch is the part of AllBranchesArray for the element
+// as the contract reads it: every word packs two levels, the upper one
+// in its high 16 bytes. depth is the number of levels of the tree.
+func VerifyDagBranch(element common.Word, index uint32, branch []*big.Int, depth int, root common.SPHash) error {
+	if len(branch) != (depth+1)/2 {
+		return &BranchError{0, fmt.Sprintf("%d words for %d levels", len(branch), depth)}
+	}
+	if depth < 32 && index>>uint(depth) != 0 {
+		return &BranchError{depth, fmt.Sprintf("index %d is out of a tree of %d levels", index, depth)}
+	}
+	node := _elementHash(element)
+	for level := 0; level < depth; level++ {
+		w, err := word32(branch[level/2])
+		if err != nil {
+			return &BranchError{level, err.Error()}
+		}
+		sibling := DagData{}
+		if level%2 == 0 {
+			copy(sibling[:], w[16:])
+		} else {
+			copy(sibling[:], w[:16])
+		}
+		if index>>uint(level)&1 == 0 {
+			node = _hash(node, sibling)
+		} else {
+			node = _hash(sibling, node)
+		}
+	}
+	if result := common.SPHash(node.(DagData)); result != root {
+		return &BranchError{depth, fmt.Sprintf("root %s is not %s", result.Hex(), root.Hex())}
+	}
+	return nil
+}
+
+// VerifyAugBranch checks that s is the leaf at index of the augmented
+// tree of root. counters and hashes are the parts of CounterBranchArray
+// and HashBranchArray for the share.
+func VerifyAugBranch(s share.Share, index uint32, counters, hashes []*big.Int, root AugData) error {
+	return VerifyAugLeaf(_augElementHash(s).(AugData), index, counters, hashes, root)
+}
+
+// VerifyAugLeaf is VerifyAugBranch for a share already turned into its
+// leaf. Besides the hashes it checks that counters increase from left
+// to right at every level, the root min and max included.
+func VerifyAugLeaf(leaf AugData, index uint32, counters, hashes []*big.Int, root AugData) error {
+	if len(counters) != len(hashes) {
+		return &BranchError{0, fmt.Sprintf("%d counters for %d hashes", len(counters), len(hashes))}
+	}
+	depth := len(hashes)
+	if depth < 32 && index>>uint(depth) != 0 {
+		return &BranchError{depth, fmt.Sprintf("index %d is out of a tree of %d levels", index, depth)}
+	}
+	node := leaf
+	for level := range hashes {
+		c, err := word32(counters[level])
+		if err != nil {
+			return &BranchError{level, err.Error()}
+		}
+		h, err := word32(hashes[level])
+		if err != nil {
+			return &BranchError{level, err.Error()}
+		}
+		sibling := AugData{
+			Min: new(big.Int).SetBytes(c[16:]),
+			Max: new(big.Int).SetBytes(c[:16]),
+		}
+		copy(sibling.Hash[:], h[16:])
+		if sibling.Min.(*big.Int).Cmp(sibling.Max.(*big.Int)) > 0 {
+			return &BranchError{level, fmt.Sprintf("sibling min %v is above its max %v", sibling.Min, sibling.Max)}
+		}
+		left, right := node, sibling
+		if index>>uint(level)&1 == 1 {
+			left, right = sibling, node
+		}
+		if left.Max.(*big.Int).Cmp(right.Min.(*big.Int)) >= 0 {
+			return &BranchError{level, fmt.Sprintf("counter %v on the left is not below %v on the right", left.Max, right.Min)}
+		}
+		node = _augHash(left, right).(AugData)
+	}
+	if node.Hash != root.Hash {
+		return &BranchError{depth, fmt.Sprintf("root %s is not %s", node.Hash.Hex(), root.Hash.Hex())}
+	}
+	if node.Min.(*big.Int).Cmp(root.Min.(*big.Int)) != 0 || node.Max.(*big.Int).Cmp(root.Max.(*big.Int)) != 0 {
+		return &BranchError{depth, fmt.Sprintf("counters [%v, %v] are not [%v, %v]", node.Min, node.Max, root.Min, root.Max)}
+	}
+	return nil
+}
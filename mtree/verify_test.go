@@ -0,0 +1,120 @@
+package mtree
+
+import (
+	"../common"
+	"../share"
+	"fmt"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+	"testing"
+)
+
+func testWords(n int) []common.Word {
+	words := make([]common.Word, n)
+	for i := range words {
+		for j := 0; j < common.WordLength; j += 32 {
+			copy(words[i][j:], crypto.Keccak256([]byte(fmt.Sprintf("%d-%d", i, j))))
+		}
+	}
+	return words
+}
+
+func testShares(n int) []share.Share {
+	shares := []share.Share{}
+	for i := 0; i < n; i++ {
+		h := &types.Header{
+			Coinbase:   ethcommon.HexToAddress("0x9e7a1925fa43d5f47b36e2e27f84adae95ddd845"),
+			Difficulty: big.NewInt(1000000),
+			Number:     big.NewInt(1),
+			GasLimit:   big.NewInt(4000000),
+			GasUsed:    big.NewInt(0),
+			Time:       big.NewInt(int64(1000 + i)),
+		}
+		shares = append(shares, *share.NewShare(h, big.NewInt(1)))
+	}
+	return shares
+}
+
+func expectLevel(t *testing.T, err error, level int) {
+	berr, ok := err.(*BranchError)
+	if !ok {
+		t.Errorf("expected a branch error at level %d, got %v", level, err)
+	} else if berr.Level != level {
+		t.Errorf("expected a branch error at level %d, got %s", level, berr)
+	}
+}
+
+func testDagBranch(words []common.Word, index uint32) ([]*big.Int, common.SPHash) {
+	dt := NewDagTree()
+	dt.RegisterIndex(index)
+	for i, w := range words {
+		dt.Insert(w, uint32(i))
+	}
+	dt.Finalize()
+	branch := []*big.Int{}
+	for _, be := range dt.AllBranchesArray() {
+		branch = append(branch, be.Big())
+	}
+	return branch, dt.RootHash()
+}
+
+func TestVerifyDagBranch(t *testing.T) {
+	words := testWords(100)
+	for _, index := range []uint32{0, 1, 63, 64, 99} {
+		branch, root := testDagBranch(words, index)
+		if err := VerifyDagBranch(words[index], index, branch, 7, root); err != nil {
+			t.Errorf("element %d: %s", index, err)
+		}
+	}
+}
+
+func TestVerifyTamperedDagBranch(t *testing.T) {
+	words := testWords(100)
+	branch, root := testDagBranch(words, 37)
+	expectLevel(t, VerifyDagBranch(words[38], 37, branch, 7, root), 7)
+	expectLevel(t, VerifyDagBranch(words[37], 37, branch[1:], 7, root), 0)
+	expectLevel(t, VerifyDagBranch(words[37], 200, branch, 7, root), 7)
+	branch[2] = new(big.Int).Lsh(big.NewInt(1), 256)
+	expectLevel(t, VerifyDagBranch(words[37], 37, branch, 7, root), 4)
+}
+
+func testAugBranch(shares []share.Share, index uint32) ([]*big.Int, []*big.Int, AugData) {
+	at := NewAugTree()
+	at.RegisterIndex(index)
+	for i, s := range shares {
+		at.Insert(s, uint32(i))
+	}
+	at.Finalize()
+	return at.CounterBranchArray(), at.HashBranchArray(), at.Root().(AugData)
+}
+
+func TestVerifyAugBranch(t *testing.T) {
+	shares := testShares(5)
+	for index := range shares {
+		counters, hashes, root := testAugBranch(shares, uint32(index))
+		if err := VerifyAugBranch(shares[index], uint32(index), counters, hashes, root); err != nil {
+			t.Errorf("share %d: %s", index, err)
+		}
+	}
+}
+
+func TestVerifyTamperedAugBranch(t *testing.T) {
+	shares := testShares(5)
+	counters, hashes, root := testAugBranch(shares, 2)
+	expectLevel(t, VerifyAugBranch(shares[3], 2, counters, hashes, root), 0)
+	expectLevel(t, VerifyAugBranch(shares[2], 2, counters, hashes[1:], root), 0)
+
+	hashes[1] = big.NewInt(1)
+	expectLevel(t, VerifyAugBranch(shares[2], 2, counters, hashes, root), 3)
+
+	// a sibling whose min is above its max
+	counters, hashes, root = testAugBranch(shares, 2)
+	counters[1] = new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(2))
+	expectLevel(t, VerifyAugBranch(shares[2], 2, counters, hashes, root), 1)
+
+	counters, hashes, root = testAugBranch(shares, 2)
+	root.Max = new(big.Int).Add(root.Max.(*big.Int), big.NewInt(1))
+	expectLevel(t, VerifyAugBranch(shares[2], 2, counters, hashes, root), 3)
+}
@@ -0,0 +1,173 @@
+// Package node manages the connections to the Ethereum nodes the client
+// talks to. Endpoints can be http, websocket or ipc. When the endpoint in
+// use stops answering, the manager switches to the next one that does.
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/rpc"
+	"sync"
+	"time"
+)
+
+const (
+	healthCheckInterval = 5 * time.Second
+	healthCheckTimeout  = 5 * time.Second
+	callTimeout         = 30 * time.Second
+)
+
+var ErrNoEndpoint = errors.New("no ethereum node endpoint is available")
+
+var DefaultManager *Manager
+
+// Manager keeps a connection to one of its endpoints. It is safe for
+// concurrent use.
+type Manager struct {
+	endpoints []string
+
+	// serializes switches, endpoints are dialed with it held but not mu
+	// so callers keep using the current connection meanwhile
+	switchMu sync.Mutex
+
+	mu     sync.Mutex
+	index  int
+	client *rpc.Client
+	// incremented on every switch so callers can tell whether the
+	// connection they saw failing is still the current one
+	generation uint64
+}
+
+// NewManager connects to the first healthy endpoint.
+func NewManager(endpoints []string) (*Manager, error) {
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	m := &Manager{endpoints: endpoints, index: -1}
+	m.switchMu.Lock()
+	defer m.switchMu.Unlock()
+	if err := m.switchEndpoint(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Client returns the current connection and its generation.
+func (m *Manager) Client() (*rpc.Client, uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.client, m.generation
+}
+
+// Endpoint returns the endpoint in use.
+func (m *Manager) Endpoint() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.endpoints[m.index]
+}
+
+func healthy(c *rpc.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	var number string
+	return c.CallContext(ctx, &number, "eth_blockNumber")
+}
+
+// switchEndpoint connects to the next healthy endpoint after the current
+// one, trying the current one last. It must be called with m.switchMu
+// held, m.mu is only taken to swap the connection.
+func (m *Manager) switchEndpoint() error {
+	m.mu.Lock()
+	current := m.index
+	m.mu.Unlock()
+	for i := 1; i <= len(m.endpoints); i++ {
+		index := (current + i) % len(m.endpoints)
+		endpoint := m.endpoints[index]
+		c, err := rpc.Dial(endpoint)
+		if err == nil {
+			if err = healthy(c); err != nil {
+				c.Close()
+			}
+		}
+		if err != nil {
+			fmt.Printf("Ethereum node %s is unavailable: %s\n", endpoint, err)
+			continue
+		}
+		m.mu.Lock()
+		old := m.client
+		m.index = index
+		m.client = c
+		m.generation++
+		m.mu.Unlock()
+		if old != nil {
+			old.Close()
+		}
+		if current >= 0 && index != current {
+			fmt.Printf("Switched to ethereum node %s\n", endpoint)
+		}
+		return nil
+	}
+	return ErrNoEndpoint
+}
+
+// Failover switches endpoint unless another caller already did since
+// generation was current.
+func (m *Manager) Failover(generation uint64) error {
+	m.switchMu.Lock()
+	defer m.switchMu.Unlock()
+	m.mu.Lock()
+	current := m.generation
+	m.mu.Unlock()
+	if current != generation {
+		return nil
+	}
+	return m.switchEndpoint()
+}
+
+// IsConnectionError tells errors of the connection from errors returned
+// by the node itself.
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, fromNode := err.(rpc.Error)
+	return !fromNode
+}
+
+// Call calls method on the current node. If the connection fails the
+// call is retried once on the next healthy node.
+func (m *Manager) Call(result interface{}, method string, args ...interface{}) error {
+	c, generation := m.Client()
+	err := call(c, result, method, args...)
+	if !IsConnectionError(err) {
+		return err
+	}
+	if m.Failover(generation) != nil {
+		return err
+	}
+	c, _ = m.Client()
+	return call(c, result, method, args...)
+}
+
+func call(c *rpc.Client, result interface{}, method string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	return c.CallContext(ctx, result, method, args...)
+}
+
+// Start checks the current node periodically and switches to another
+// one when it stops answering.
+func (m *Manager) Start() {
+	go func() {
+		for range time.Tick(healthCheckInterval) {
+			c, generation := m.Client()
+			if err := healthy(c); err != nil {
+				fmt.Printf("Ethereum node %s failed health check: %s\n", m.Endpoint(), err)
+				if err := m.Failover(generation); err != nil {
+					fmt.Printf("%s\n", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,156 @@
+package node
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testNode answers eth_chainId with its id. Its health checks wait
+// while it is held, held gets a value once one waits.
+type testNode struct {
+	id   uint64
+	held chan struct{}
+
+	mu   sync.Mutex
+	hold chan struct{}
+}
+
+func (n *testNode) BlockNumber() hexutil.Uint64 {
+	n.mu.Lock()
+	hold := n.hold
+	n.mu.Unlock()
+	if hold != nil {
+		select {
+		case n.held <- struct{}{}:
+		default:
+		}
+		<-hold
+	}
+	return 1
+}
+
+func (n *testNode) ChainId() hexutil.Uint64 { return hexutil.Uint64(n.id) }
+
+func startTestNode(t *testing.T, n *testNode) *httptest.Server {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", n); err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(server)
+}
+
+func chainID(t *testing.T, m *Manager) uint64 {
+	var id hexutil.Uint64
+	if err := m.Call(&id, "eth_chainId"); err != nil {
+		t.Fatal(err)
+	}
+	return uint64(id)
+}
+
+func TestManagerFailsOverToNextNode(t *testing.T) {
+	first := startTestNode(t, &testNode{id: 1})
+	second := startTestNode(t, &testNode{id: 2})
+	defer second.Close()
+	m, err := NewManager([]string{first.URL, second.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := chainID(t, m); id != 1 {
+		t.Fatalf("expected the first node, got node %d", id)
+	}
+	_, generation := m.Client()
+	first.Close()
+	// the call failing on the first node is retried on the second
+	if id := chainID(t, m); id != 2 {
+		t.Errorf("expected the second node, got node %d", id)
+	}
+	if _, g := m.Client(); g != generation+1 || m.Endpoint() != second.URL {
+		t.Errorf("expected generation %d on %s, got %d on %s", generation+1, second.URL, g, m.Endpoint())
+	}
+}
+
+func TestManagerSkipsUnavailableNodes(t *testing.T) {
+	closed := startTestNode(t, &testNode{id: 1})
+	closed.Close()
+	if _, err := NewManager([]string{closed.URL}); err != ErrNoEndpoint {
+		t.Errorf("expected %v, got %v", ErrNoEndpoint, err)
+	}
+	up := startTestNode(t, &testNode{id: 2})
+	defer up.Close()
+	m, err := NewManager([]string{closed.URL, up.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Endpoint() != up.URL {
+		t.Errorf("expected %s, got %s", up.URL, m.Endpoint())
+	}
+}
+
+// Callers that saw the same connection fail switch only once.
+func TestManagerIgnoresStaleFailover(t *testing.T) {
+	first := startTestNode(t, &testNode{id: 1})
+	defer first.Close()
+	second := startTestNode(t, &testNode{id: 2})
+	defer second.Close()
+	m, err := NewManager([]string{first.URL, second.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, generation := m.Client()
+	if err := m.Failover(generation); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Failover(generation); err != nil {
+		t.Fatal(err)
+	}
+	if _, g := m.Client(); g != generation+1 || m.Endpoint() != second.URL {
+		t.Errorf("expected generation %d on %s, got %d on %s", generation+1, second.URL, g, m.Endpoint())
+	}
+}
+
+// The current connection stays available while the next node is
+// checked.
+func TestManagerClientDuringFailover(t *testing.T) {
+	first := startTestNode(t, &testNode{id: 1})
+	defer first.Close()
+	slow := &testNode{id: 2, held: make(chan struct{}, 1)}
+	second := startTestNode(t, slow)
+	defer second.Close()
+	m, err := NewManager([]string{first.URL, second.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold := make(chan struct{})
+	slow.mu.Lock()
+	slow.hold = hold
+	slow.mu.Unlock()
+	_, generation := m.Client()
+	switched := make(chan error)
+	go func() { switched <- m.Failover(generation) }()
+	<-slow.held
+
+	got := make(chan uint64)
+	go func() {
+		_, g := m.Client()
+		got <- g
+	}()
+	select {
+	case g := <-got:
+		if g != generation {
+			t.Errorf("expected generation %d before the switch, got %d", generation, g)
+		}
+	case <-time.After(time.Second):
+		t.Error("Client blocked while the next node was checked")
+	}
+	close(hold)
+	if err := <-switched; err != nil {
+		t.Fatal(err)
+	}
+	if m.Endpoint() != second.URL {
+		t.Errorf("expected %s, got %s", second.URL, m.Endpoint())
+	}
+}
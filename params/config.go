@@ -6,12 +6,54 @@ import (
 )
 
 var (
-	IPCPath         string
-	KeystorePath    string
+	// ethereum nodes to connect to, in order of preference
+	NodeEndpoints []string
+	KeystorePath  string
+	// file holding the passphrase of the miner account, it is read
+	// before Passphrase and prompted for only in a terminal
+	PassphraseFile  string
+	Passphrase      string
 	NoSharePerClaim uint32
 	ShareDifficulty *big.Int
 	SubmitInterval  time.Duration
 	ContractAddress string
 	MinerAddress    string
 	ExtraData       string
+	// directory to keep the client state such as the claim journal
+	DataDir string
+	// number of blocks a work stays valid for share submission,
+	// 0 means works never expire
+	WorkExpiryBlocks uint64
+	// average time between shares vardiff aims at for each named
+	// worker, 0 gives every worker ShareDifficulty
+	VardiffTargetTime time.Duration
+	// how often the share difficulty of a worker is adjusted
+	VardiffRetargetTime time.Duration
+	// number of blocks a transaction needs, including its own, to be
+	// considered confirmed
+	TxConfirmations uint64
+	// number of blocks, including its own, after which a transaction
+	// is not expected to be reorged anymore
+	FinalityDepth uint64
+	// how long to wait for a transaction to be confirmed, 0 waits for
+	// ever except in the claim watcher
+	TxTimeout time.Duration
+	// how long a claim transaction may stay pending before it is
+	// replaced with a higher gas price, 0 never replaces it
+	TxReplaceAfter time.Duration
+	// fixed, node or capped, see contract.GasPricer
+	GasPricePolicy string
+	// gas price in wei of the fixed policy
+	GasPrice *big.Int
+	// factor applied to the gas price suggested by the node
+	GasPriceMultiplier float64
+	// highest gas price in wei the capped policy and replacements
+	// use, nil or 0 for no limit
+	MaxGasPrice *big.Int
+	// ports miners connect to
+	RPCPort     uint16
+	StratumPort uint16
+	// check proofs with eth_call instead of sending them, claims are
+	// never paid
+	DebugClaims bool
 )
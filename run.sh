@@ -1 +1 @@
-./spclient_exp
+./spclient_exp run "$@"
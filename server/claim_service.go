@@ -0,0 +1,63 @@
+package server
+
+import (
+	"../claim"
+	"github.com/ethereum/go-ethereum/common"
+	"time"
+)
+
+type TransitionInfo struct {
+	State       string       `json:"state"`
+	TxHash      *common.Hash `json:"txHash,omitempty"`
+	BlockNumber uint64       `json:"blockNumber,omitempty"`
+	Time        time.Time    `json:"time"`
+	Error       string       `json:"error,omitempty"`
+}
+
+type ClaimInfo struct {
+	Number    uint64           `json:"number"`
+	State     string           `json:"state"`
+	NumShares int              `json:"numShares"`
+	Dropped   int              `json:"droppedShares,omitempty"`
+	History   []TransitionInfo `json:"history"`
+}
+
+func newClaimInfo(r *claim.ClaimRecord) ClaimInfo {
+	result := ClaimInfo{
+		r.Number,
+		r.State().String(),
+		len(r.Shares),
+		r.Dropped,
+		[]TransitionInfo{},
+	}
+	for _, t := range r.History {
+		jt := TransitionInfo{State: t.State.String(), BlockNumber: t.BlockNumber, Time: t.Time, Error: t.Error}
+		if t.TxHash != (common.Hash{}) {
+			txHash := t.TxHash
+			jt.TxHash = &txHash
+		}
+		result.History = append(result.History, jt)
+	}
+	return result
+}
+
+// ClaimService lets operators inspect the claim lifecycle. It is
+// served under the smartpool namespace.
+type ClaimService struct{}
+
+func (ClaimService) Claims() []ClaimInfo {
+	result := []ClaimInfo{}
+	for _, r := range claim.DefaultClaimRepo.Records() {
+		result = append(result, newClaimInfo(r))
+	}
+	return result
+}
+
+func (ClaimService) Claim(number int) *ClaimInfo {
+	r := claim.DefaultClaimRepo.Record(number)
+	if r == nil {
+		return nil
+	}
+	result := newClaimInfo(r)
+	return &result
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"../dag"
+	"github.com/ethereum/go-ethereum/common"
+	"time"
+)
+
+type EpochInfo struct {
+	Epoch                   uint64       `json:"epoch"`
+	State                   string       `json:"state"`
+	MerkleRoot              string       `json:"merkleRoot,omitempty"`
+	FullSizeIn128Resolution uint64       `json:"fullSizeIn128Resolution,omitempty"`
+	BranchDepth             uint64       `json:"branchDepth,omitempty"`
+	TxHash                  *common.Hash `json:"txHash,omitempty"`
+	Error                   string       `json:"error,omitempty"`
+	Updated                 time.Time    `json:"updated"`
+}
+
+func newEpochInfo(s dag.EpochStatus) EpochInfo {
+	result := EpochInfo{Epoch: s.Epoch, State: s.State.String(), Error: s.Error, Updated: s.Updated}
+	if s.Data != nil {
+		result.MerkleRoot = "0x" + s.Data.MerkleRoot.Text(16)
+		result.FullSizeIn128Resolution = s.Data.FullSizeIn128Resolution
+		result.BranchDepth = s.Data.BranchDepth
+	}
+	if s.TxHash != (common.Hash{}) {
+		txHash := s.TxHash
+		result.TxHash = &txHash
+	}
+	return result
+}
+
+// EpochService reports what the epoch updater did, it returns nothing
+// when the client runs without it. It is served under the smartpool
+// namespace.
+type EpochService struct{}
+
+func (EpochService) Epochs() []EpochInfo {
+	result := []EpochInfo{}
+	if dag.DefaultEpochUpdater == nil {
+		return result
+	}
+	for _, s := range dag.DefaultEpochUpdater.Statuses() {
+		result = append(result, newEpochInfo(s))
+	}
+	return result
+}
@@ -1,9 +1,22 @@
 package server
 
 import (
+	"../params"
 	"fmt"
 	"github.com/ethereum/go-ethereum/rpc"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rpc servers of workers are dropped after this long without a
+	// request, they are made again on the next one
+	workerServerExpiry = time.Hour
+	// most rpc servers of workers kept, the least recently used one is
+	// dropped first
+	maxWorkerServers = 1024
 )
 
 var DefaultServer *Server
@@ -12,19 +25,82 @@ type Server struct {
 	Port      uint16
 	rpcServer *rpc.Server
 	server    *http.Server
+
+	// rpc servers of the workers connecting to /<worker>
+	mu      sync.Mutex
+	workers map[string]*workerServer
 }
 
-func NewRPCServer() *Server {
+type workerServer struct {
+	rpcServer *rpc.Server
+	used      time.Time
+}
+
+func newServiceServer(worker string) *rpc.Server {
 	rpcServer := rpc.NewServer()
-	service := SmartPoolService{}
-	rpcServer.RegisterName("eth", service)
-	return &Server{uint16(1633), rpcServer, &http.Server{
-		Addr:    ":1633",
-		Handler: rpcServer,
-	}}
+	rpcServer.RegisterName("eth", SmartPoolService{worker})
+	rpcServer.RegisterName("smartpool", ClaimService{})
+	rpcServer.RegisterName("smartpool", EpochService{})
+	rpcServer.RegisterName("worker", WorkerService{})
+	return rpcServer
+}
+
+func NewRPCServer() *Server {
+	s := &Server{
+		Port:      params.RPCPort,
+		rpcServer: newServiceServer(defaultWorker),
+		workers:   map[string]*workerServer{},
+	}
+	s.server = &http.Server{
+		Addr:    fmt.Sprintf(":%d", params.RPCPort),
+		Handler: s,
+	}
+	return s
+}
+
+// ServeHTTP lets miners name themselves by the url path, e.g.
+// http://localhost:1633/worker1.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	worker := strings.Trim(r.URL.Path, "/")
+	if worker == "" {
+		s.rpcServer.ServeHTTP(w, r)
+		return
+	}
+	if !validWorkerName(worker) {
+		http.Error(w, "invalid worker name", http.StatusNotFound)
+		return
+	}
+	s.mu.Lock()
+	now := time.Now()
+	ws := s.workers[worker]
+	if ws == nil {
+		s.expireWorkers(now)
+		ws = &workerServer{rpcServer: newServiceServer(worker)}
+		s.workers[worker] = ws
+	}
+	ws.used = now
+	s.mu.Unlock()
+	ws.rpcServer.ServeHTTP(w, r)
+}
+
+// expireWorkers drops the rpc servers of workers not seen for
+// workerServerExpiry and, if there are still too many, the least
+// recently used one. It must be called with s.mu held.
+func (s *Server) expireWorkers(now time.Time) {
+	oldest := ""
+	for name, ws := range s.workers {
+		if now.Sub(ws.used) > workerServerExpiry {
+			delete(s.workers, name)
+		} else if oldest == "" || ws.used.Before(s.workers[oldest].used) {
+			oldest = name
+		}
+	}
+	if len(s.workers) >= maxWorkerServers {
+		delete(s.workers, oldest)
+	}
 }
 
-func (s Server) Start() {
-	fmt.Printf("RPC Server is running...\n")
+func (s *Server) Start() {
+	fmt.Printf("RPC Server is running on port %d...\n", s.Port)
 	s.server.ListenAndServe()
 }
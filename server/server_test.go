@@ -0,0 +1,37 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveWorker(s *Server, worker string) {
+	body := strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"worker_list","params":[]}`)
+	r := httptest.NewRequest(http.MethodPost, "/"+worker, body)
+	r.Header.Set("Content-Type", "application/json")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func TestServerExpiresWorkerServers(t *testing.T) {
+	s := &Server{rpcServer: newServiceServer(defaultWorker), workers: map[string]*workerServer{}}
+	serveWorker(s, "gone")
+	serveWorker(s, "rig")
+	s.workers["gone"].used = time.Now().Add(-workerServerExpiry - time.Minute)
+	serveWorker(s, "new")
+	if s.workers["gone"] != nil || s.workers["rig"] == nil || s.workers["new"] == nil {
+		t.Fatalf("expected the server of the idle worker dropped, got %v", s.workers)
+	}
+
+	for i := 0; len(s.workers) < maxWorkerServers; i++ {
+		serveWorker(s, fmt.Sprintf("rig%d", i))
+	}
+	// rig is the least recently used
+	serveWorker(s, "last")
+	if len(s.workers) != maxWorkerServers || s.workers["rig"] != nil || s.workers["last"] == nil {
+		t.Errorf("expected %d servers without the least recently used, got %d", maxWorkerServers, len(s.workers))
+	}
+}
@@ -10,49 +10,127 @@ import (
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"math/big"
+	"time"
 )
 
-type SmartPoolService struct{}
+// SmartPoolService serves the eth namespace to miners. worker is the
+// name miners connecting to the url path /<worker> are accounted as.
+type SmartPoolService struct {
+	worker string
+}
+
+// how long miners asking for work wait for the first one
+const workTimeout = 5 * time.Second
 
-func (SmartPoolService) GetWork() ([3]string, error) {
+// GetWork fails while the node can't give work, e.g. with
+// client.ErrNotSynced while it syncs.
+func (sps SmartPoolService) GetWork() ([3]string, error) {
 	var res [3]string
-	w := client.DefaultGethClient.GetWork()
-	spcommon.WorkPool[w.PoWHash()] = w
+	w, err := client.DefaultWorkFeed.Current(workTimeout)
+	if err != nil {
+		return res, err
+	}
+	spcommon.WorkPool.AddWork(w)
 	// w.PrintInfo()
 	res[0] = w.PoWHash().Hex()
 	res[1] = w.SeedHash()
+	res[2] = shareBoundary(DefaultVardiff.Issue(sps.worker, w)).Hex()
+	return res, nil
+}
+
+// shareBoundary returns 2^256 / difficulty, the value a pow result
+// must not exceed to be a valid share.
+func shareBoundary(difficulty *big.Int) common.Hash {
 	n := big.NewInt(1)
 	n.Lsh(n, 255)
-	n.Div(n, w.ShareDifficulty())
+	n.Div(n, difficulty)
 	n.Lsh(n, 1)
-	res[2] = common.BytesToHash(n.Bytes()).Hex()
-	return res, nil
+	return common.BytesToHash(n.Bytes())
+}
+
+func (sps SmartPoolService) SubmitHashrate(hashrate hexutil.Uint64, id common.Hash) bool {
+	DefaultWorkerRegistry.RecordHashrate(sps.worker, uint64(hashrate), id)
+	ok, err := client.DefaultGethClient.SubmitHashrate(hashrate, id)
+	if err != nil {
+		fmt.Printf("Couldn't forward hashrate to geth: %s\n", err)
+	}
+	return ok
 }
 
-func (SmartPoolService) SubmitHashrate(hashrate hexutil.Uint64, id common.Hash) bool {
-	return client.DefaultGethClient.SubmitHashrate(hashrate, id)
+// shareError is returned to miners whose solution was not accepted.
+// ErrorCode makes the rpc server use code instead of its generic one.
+type shareError struct {
+	code    int
+	message string
 }
 
-func (SmartPoolService) SubmitWork(nonce types.BlockNonce, hash, mixDigest common.Hash) bool {
-	// Make sure the work submitted is present
-	work := spcommon.WorkPool[hash]
+func (e *shareError) Error() string  { return e.message }
+func (e *shareError) ErrorCode() int { return e.code }
+
+var (
+	errStaleShare         = &shareError{-32001, "stale share"}
+	errDuplicateShare     = &shareError{-32002, "duplicate share"}
+	errLowDifficultyShare = &shareError{-32003, "low difficulty share"}
+)
+
+func (sps SmartPoolService) SubmitWork(nonce types.BlockNonce, hash, mixDigest common.Hash) (bool, error) {
+	if err := acceptSolution(sps.worker, nonce, hash, mixDigest); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// acceptSolution validates a solution submitted by worker over any
+// protocol and accounts it to the worker. Full solutions are passed to
+// geth and valid shares are added to the current claim.
+func acceptSolution(worker string, nonce types.BlockNonce, hash, mixDigest common.Hash) error {
+	difficulty, err := checkSolution(worker, nonce, hash, mixDigest)
+	DefaultWorkerRegistry.RecordShare(worker, difficulty, err)
+	if err == nil {
+		DefaultVardiff.ShareAccepted(worker)
+	}
+	return err
+}
+
+// checkSolution returns the difficulty of the share found. Only shares
+// of the work's share difficulty are added to the claim.
+func checkSolution(worker string, nonce types.BlockNonce, hash, mixDigest common.Hash) (*big.Int, error) {
+	// Make sure the work submitted is present and not expired
+	work := spcommon.WorkPool.GetWork(hash)
 	if work == nil {
 		fmt.Printf("Work was submitted for %x but no pending work found\n", hash)
-		return false
+		return nil, errStaleShare
 	}
 	// fmt.Printf("Work submitted with: nonce(%v) mixDigest(%v) hash(%s)\n", nonce, mixDigest, hash.Hex())
 	fmt.Printf(".")
-	if client.DefaultGethClient.SubmitWork(nonce, hash, mixDigest) {
+	// the share is still worth claiming when geth can't be reached
+	if ok, err := client.DefaultGethClient.SubmitWork(nonce, hash, mixDigest); err != nil {
+		fmt.Printf("\nCouldn't forward solution to geth: %s\n", err)
+	} else if ok {
 		fmt.Printf("\n==========YAY found a full solution==========\n")
 	}
-	s := share.NewShare(work.BlockHeader(), work.ShareDifficulty())
+	issued := DefaultVardiff.DifficultyOf(worker, work)
+	s := share.NewShare(work.BlockHeader(), issued)
 	s.AcceptSolution(nonce, mixDigest)
+	if s.SolutionState == spcommon.ValidShare && issued.Cmp(work.ShareDifficulty()) < 0 {
+		// the contract credits shares with the difficulty of the extra
+		// data, a share below it is only counted for the worker
+		s = share.NewShare(work.BlockHeader(), work.ShareDifficulty())
+		s.AcceptSolution(nonce, mixDigest)
+		if s.SolutionState == spcommon.InvalidShare {
+			return issued, nil
+		}
+	}
 	if s.SolutionState == spcommon.FullBlockSolution {
-		delete(spcommon.WorkPool, hash)
+		spcommon.WorkPool.RemoveWork(hash)
 	} else if s.SolutionState == spcommon.ValidShare {
-		claim.DefaultClaimRepo.AddShare(s)
+		if err := claim.DefaultClaimRepo.AddShare(s); err == claim.ErrDuplicateShare {
+			return nil, errDuplicateShare
+		} else if err != nil {
+			return nil, err
+		}
 	} else {
-		return false
+		return nil, errLowDifficultyShare
 	}
-	return true
+	return s.ShareDifficulty, nil
 }
@@ -0,0 +1,401 @@
+package server
+
+import (
+	"../claim"
+	"../client"
+	spcommon "../common"
+	"../ethash"
+	"../node"
+	"../params"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEth is a node whose pending block never changes and which
+// reverts every transaction.
+type fakeEth struct {
+	header *types.Header
+}
+
+func newFakeEth() *fakeEth {
+	return &fakeEth{&types.Header{
+		Coinbase:   common.HexToAddress(params.ContractAddress),
+		Difficulty: new(big.Int).Lsh(big.NewInt(1), 50),
+		Number:     big.NewInt(1),
+		GasLimit:   big.NewInt(4000000),
+		GasUsed:    big.NewInt(0),
+		Time:       big.NewInt(1500000000),
+		Extra:      []byte(params.ExtraData),
+	}}
+}
+
+func (e *fakeEth) BlockNumber() hexutil.Uint64 { return hexutil.Uint64(e.header.Number.Uint64()) }
+func (e *fakeEth) Syncing() bool               { return false }
+
+func (e *fakeEth) GetBlockByNumber(number string, full bool) map[string]interface{} {
+	h := e.header
+	return map[string]interface{}{
+		"parentHash":       h.ParentHash,
+		"sha3Uncles":       h.UncleHash,
+		"miner":            h.Coinbase,
+		"stateRoot":        h.Root,
+		"transactionsRoot": h.TxHash,
+		"receiptsRoot":     h.ReceiptHash,
+		"logsBloom":        h.Bloom,
+		"difficulty":       (*hexutil.Big)(h.Difficulty),
+		"number":           (*hexutil.Big)(h.Number),
+		"gasLimit":         (*hexutil.Big)(h.GasLimit),
+		"gasUsed":          (*hexutil.Big)(h.GasUsed),
+		"timestamp":        (*hexutil.Big)(h.Time),
+		"extraData":        hexutil.Bytes(h.Extra),
+		"mixHash":          h.MixDigest,
+		"nonce":            h.Nonce,
+	}
+}
+
+func (e *fakeEth) GetWork() [3]string {
+	return [3]string{e.header.HashNoNonce().Hex(), common.Hash{}.Hex(), common.Hash{}.Hex()}
+}
+
+func (e *fakeEth) SubmitWork(nonce types.BlockNonce, hash, mixDigest common.Hash) bool { return false }
+
+func (e *fakeEth) GetTransactionReceipt(h common.Hash) map[string]interface{} {
+	return map[string]interface{}{
+		"blockHash":   common.HexToHash("0xb1"),
+		"blockNumber": hexutil.Uint64(e.header.Number.Uint64()),
+		"gasUsed":     hexutil.Uint64(21000),
+		"status":      hexutil.Uint64(0),
+	}
+}
+
+// fakeClaimContract records the number of shares of every submitted
+// claim. Its transactions are reverted by fakeEth so the watcher never
+// has to prove a claim, which would need the dataset.
+type fakeClaimContract struct {
+	mu        sync.Mutex
+	submitted []uint64
+}
+
+func (c *fakeClaimContract) SubmitClaim(numShares, difficulty, min, max, augMerkle *big.Int) (*types.Transaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.submitted = append(c.submitted, numShares.Uint64())
+	return types.NewTransaction(uint64(len(c.submitted)), common.Address{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), nil), nil
+}
+
+func (c *fakeClaimContract) VerifyClaim(rlpHeader []byte, nonce, shareIndex *big.Int,
+	dataSetLookup, witnessForLookup, augCountersBranch, augHashesBranch []*big.Int) (*types.Transaction, error) {
+	panic("rejected claims are never proved")
+}
+
+func (c *fakeClaimContract) VerifyClaim_debug(rlpHeader []byte, nonce, shareIndex *big.Int,
+	dataSetLookup, witnessForLookup, augCountersBranch, augHashesBranch []*big.Int) (*big.Int, error) {
+	panic("rejected claims are never proved")
+}
+
+func (c *fakeClaimContract) GetClaimSeed() (*big.Int, error) { return big.NewInt(0), nil }
+
+func (c *fakeClaimContract) ReplaceTransaction(h common.Hash) (*types.Transaction, error) {
+	return nil, nil
+}
+
+func (c *fakeClaimContract) sharesSubmitted() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := 0
+	for _, n := range c.submitted {
+		total += int(n)
+	}
+	return total
+}
+
+// poolGlobals are the package globals the pool tests replace.
+type poolGlobals struct {
+	contractAddress     string
+	shareDifficulty     *big.Int
+	submitInterval      time.Duration
+	noSharePerClaim     uint32
+	dataDir             string
+	extraData           string
+	vardiffTargetTime   time.Duration
+	vardiffRetargetTime time.Duration
+	gethClient          *client.GethClient
+	workFeed            *client.WorkFeed
+	claimRepo           *claim.ClaimRepo
+	vardiff             *vardiffs
+	workerRegistry      *workerRegistry
+}
+
+func savePoolGlobals() poolGlobals {
+	return poolGlobals{
+		params.ContractAddress,
+		params.ShareDifficulty,
+		params.SubmitInterval,
+		params.NoSharePerClaim,
+		params.DataDir,
+		params.ExtraData,
+		params.VardiffTargetTime,
+		params.VardiffRetargetTime,
+		client.DefaultGethClient,
+		client.DefaultWorkFeed,
+		claim.DefaultClaimRepo,
+		DefaultVardiff,
+		DefaultWorkerRegistry,
+	}
+}
+
+func (g poolGlobals) restore() {
+	params.ContractAddress = g.contractAddress
+	params.ShareDifficulty = g.shareDifficulty
+	params.SubmitInterval = g.submitInterval
+	params.NoSharePerClaim = g.noSharePerClaim
+	params.DataDir = g.dataDir
+	params.ExtraData = g.extraData
+	params.VardiffTargetTime = g.vardiffTargetTime
+	params.VardiffRetargetTime = g.vardiffRetargetTime
+	client.DefaultGethClient = g.gethClient
+	client.DefaultWorkFeed = g.workFeed
+	claim.DefaultClaimRepo = g.claimRepo
+	DefaultVardiff = g.vardiff
+	DefaultWorkerRegistry = g.workerRegistry
+}
+
+// startFakePool points the pool at a node serving eth and starts the
+// work feed and, if cc isn't nil, the claim watcher submitting to cc.
+// The returned function stops them and restores the globals.
+func startFakePool(t *testing.T, eth interface{}, cc claim.ClaimContract) func() {
+	saved := savePoolGlobals()
+	DefaultVardiff = newVardiffs()
+	DefaultWorkerRegistry = newWorkerRegistry()
+	rpcServer := rpc.NewServer()
+	rpcServer.RegisterName("eth", eth)
+	ts := httptest.NewServer(rpcServer)
+	m, err := node.NewManager([]string{ts.URL})
+	if err != nil {
+		ts.Close()
+		saved.restore()
+		t.Fatal(err)
+	}
+	client.DefaultGethClient = client.NewGethRPCClient(m)
+	client.DefaultWorkFeed = client.NewWorkFeed(client.DefaultGethClient)
+	client.DefaultWorkFeed.Start()
+	stop := func() {
+		if cc != nil && claim.DefaultClaimRepo != nil {
+			claim.DefaultClaimRepo.StopWatcher()
+		}
+		client.DefaultWorkFeed.Stop()
+		ts.Close()
+		saved.restore()
+	}
+	if cc != nil {
+		if claim.DefaultClaimRepo, err = claim.LoadClaimRepo(cc); err != nil {
+			stop()
+			t.Fatal(err)
+		}
+	}
+	return stop
+}
+
+// setPoolParams sets the params of a pool whose claims are credited at
+// shareDifficulty. Callers save them first with savePoolGlobals.
+func setPoolParams(shareDifficulty int64) {
+	params.ContractAddress = "0x0000000000000000000000000000000000000001"
+	params.ShareDifficulty = big.NewInt(shareDifficulty)
+	params.SubmitInterval = 10 * time.Millisecond
+	params.NoSharePerClaim = 10
+	params.DataDir = ""
+	params.ExtraData = spcommon.ExtraData(common.Address{}, params.ShareDifficulty)
+	params.VardiffTargetTime = 0
+}
+
+// Miners get work and submit shares through the rpc service while the
+// claim watcher seals, submits and rejects claims on its ticker and
+// operators read the claims. Run it with -race.
+func TestSmartPoolServiceConcurrentShares(t *testing.T) {
+	saved := savePoolGlobals()
+	defer saved.restore()
+	setPoolParams(1)
+	eth := newFakeEth()
+	cc := &fakeClaimContract{}
+	defer startFakePool(t, eth, cc)()
+	light := ethash.NewShared()
+
+	const miners, sharesPerMiner = 8, 25
+	accepted := make(chan int, miners)
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ClaimService{}.Claims()
+			WorkerService{}.List()
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < miners; i++ {
+		wg.Add(1)
+		go func(miner int) {
+			defer wg.Done()
+			sps := SmartPoolService{defaultWorker}
+			n := 0
+			for j := 0; j < sharesPerMiner; j++ {
+				work, err := sps.GetWork()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				hash := common.HexToHash(work[0])
+				nonce := uint64(miner*sharesPerMiner + j)
+				mixDigest, _, err := light.Compute(eth.header.Number.Uint64(), hash, nonce)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if ok, err := sps.SubmitWork(types.EncodeNonce(nonce), hash, mixDigest); err != nil {
+					t.Errorf("share %d of miner %d rejected: %s", j, miner, err)
+				} else if ok {
+					n++
+				}
+			}
+			accepted <- n
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+	total := 0
+	for i := 0; i < miners; i++ {
+		total += <-accepted
+	}
+
+	// let the watcher finish the sealed claims
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		// a full current claim is sealed on the next tick
+		busy := len(claim.DefaultClaimRepo.CurrentClaim()) >= int(params.NoSharePerClaim)
+		for _, r := range claim.DefaultClaimRepo.Records() {
+			if r.State() != claim.ClaimOpen && !r.State().Final() {
+				busy = true
+			}
+		}
+		if !busy {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("claim watcher didn't finish the sealed claims")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	numbers := map[uint64]bool{}
+	for _, r := range claim.DefaultClaimRepo.Records() {
+		if numbers[r.Number] {
+			t.Errorf("claim %d listed twice", r.Number)
+		}
+		numbers[r.Number] = true
+		if r.State() != claim.ClaimOpen && r.State() != claim.ClaimRejected {
+			t.Errorf("claim %d is %s, expected %s", r.Number, r.State(), claim.ClaimRejected)
+		}
+	}
+	if total != miners*sharesPerMiner {
+		t.Errorf("expected %d shares accepted, got %d", miners*sharesPerMiner, total)
+	}
+	if claimed := cc.sharesSubmitted() + len(claim.DefaultClaimRepo.CurrentClaim()); claimed != total {
+		t.Errorf("expected the %d accepted shares in claims, got %d", total, claimed)
+	}
+}
+
+// A worker vardiff gave a lower difficulty gets its shares counted but
+// only those of the extra data difficulty are claimed.
+func TestVardiffSharesClaimedAtExtraDataDifficulty(t *testing.T) {
+	saved := savePoolGlobals()
+	defer saved.restore()
+	setPoolParams(8)
+	params.NoSharePerClaim = 1000
+	params.VardiffTargetTime = time.Second
+	params.VardiffRetargetTime = time.Hour
+	eth := newFakeEth()
+	defer startFakePool(t, eth, &fakeClaimContract{})()
+	DefaultVardiff.workers["rig"] = &vardiff{
+		shift:        2,
+		lastRetarget: time.Now(),
+		issued:       map[common.Hash]uint{},
+	}
+	light := ethash.NewShared()
+
+	sps := SmartPoolService{"rig"}
+	work, err := sps.GetWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if boundary := shareBoundary(big.NewInt(2)).Hex(); work[2] != boundary {
+		t.Fatalf("expected the boundary of difficulty 2, got %s", work[2])
+	}
+	hash := common.HexToHash(work[0])
+	accepted := 0
+	for nonce := uint64(0); nonce < 200; nonce++ {
+		mixDigest, _, err := light.Compute(eth.header.Number.Uint64(), hash, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := sps.SubmitWork(types.EncodeNonce(nonce), hash, mixDigest); ok {
+			accepted++
+		}
+	}
+	shares := claim.DefaultClaimRepo.CurrentClaim()
+	if len(shares) == 0 || len(shares) >= accepted {
+		t.Fatalf("expected some but not all of the %d accepted shares claimed, got %d", accepted, len(shares))
+	}
+	for _, s := range shares {
+		if s.ShareDifficulty.Int64() != 8 {
+			t.Errorf("claimed share found at difficulty %s, expected 8", s.ShareDifficulty)
+		}
+	}
+	if d := shares.Difficulty(); d == nil || d.Int64() != 8 {
+		t.Errorf("expected the claim credited at difficulty 8, got %v", d)
+	}
+}
+
+// syncingEth is a node still syncing, it has no work to give.
+type syncingEth struct {
+	*fakeEth
+}
+
+func (e syncingEth) Syncing() bool { return true }
+
+func (e syncingEth) GetWork() ([3]string, error) {
+	return [3]string{}, errors.New("no mining work available yet, don't panic")
+}
+
+func TestGetWorkWhileSyncing(t *testing.T) {
+	saved := savePoolGlobals()
+	defer saved.restore()
+	params.ContractAddress = "0x0000000000000000000000000000000000000001"
+	defer startFakePool(t, syncingEth{newFakeEth()}, nil)()
+	done := make(chan error, 1)
+	go func() {
+		_, err := SmartPoolService{defaultWorker}.GetWork()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != client.ErrNotSynced {
+			t.Errorf("expected %v, got %v", client.ErrNotSynced, err)
+		}
+	case <-time.After(2 * workTimeout):
+		t.Fatal("GetWork didn't return while the node syncs")
+	}
+}
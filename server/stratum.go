@@ -0,0 +1,458 @@
+package server
+
+import (
+	"../client"
+	spcommon "../common"
+	"../ethash"
+	"../params"
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stratum error codes as used by EthereumStratum/1.0.0 pools.
+const (
+	stratumErrOther          = 20
+	stratumErrJobNotFound    = 21
+	stratumErrDuplicateShare = 22
+	stratumErrLowDifficulty  = 23
+	stratumErrUnauthorized   = 24
+	stratumErrNotSubscribed  = 25
+)
+
+const (
+	stratumProtocol       = "EthereumStratum/1.0.0"
+	stratumExtraNonceSize = 2
+	stratumMaxRequestSize = 4096
+	// time a new session has to subscribe and authorize
+	stratumLoginTimeout = 30 * time.Second
+	// time an authorized miner may send nothing, miners submit their
+	// hashrate more often than that
+	stratumIdleTimeout = 10 * time.Minute
+)
+
+var DefaultStratumServer *StratumServer
+
+// StratumServer serves miners speaking EthereumStratum/1.0.0 (the
+// NiceHash flavour of stratum). Solutions go through the same
+// validation as eth_submitWork.
+type StratumServer struct {
+	Port uint16
+	addr string
+	pow  *ethash.Ethash
+	// sessions are closed when they don't send a request in time, 0
+	// waits for ever
+	loginTimeout time.Duration
+	idleTimeout  time.Duration
+
+	mu       sync.Mutex
+	sessions map[*stratumSession]bool
+	work     *spcommon.Work
+	// extranonces of the live sessions, two miners with the same one
+	// would search the same nonces
+	extraNonces    map[uint16]bool
+	nextExtraNonce uint16
+}
+
+var errNoExtraNonce = errors.New("every extranonce is in use")
+
+type stratumRequest struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+type stratumResponse struct {
+	ID     *json.RawMessage `json:"id"`
+	Result interface{}      `json:"result"`
+	Error  interface{}      `json:"error"`
+}
+
+type stratumNotification struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params []interface{}    `json:"params"`
+}
+
+type stratumError struct {
+	code    int
+	message string
+}
+
+func (e stratumError) Error() string { return e.message }
+
+func (e stratumError) toJSON() []interface{} {
+	return []interface{}{e.code, e.message, nil}
+}
+
+type stratumSession struct {
+	conn         net.Conn
+	extraNonceID uint16
+	extraNonce   string
+	// the latest job the miner didn't get yet, see pushJobs
+	jobs chan *spcommon.Work
+	// closed when the session is removed
+	done chan struct{}
+
+	// serializes writes to conn
+	wmu sync.Mutex
+	enc *json.Encoder
+
+	// protects the fields below, they are set by the session's own
+	// goroutine and read when jobs are pushed
+	mu         sync.Mutex
+	subscribed bool
+	authorized bool
+	worker     string
+}
+
+func (ss *stratumSession) isSubscribed() bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.subscribed
+}
+
+func (ss *stratumSession) isAuthorized() bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.authorized
+}
+
+func (ss *stratumSession) workerName() string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.worker
+}
+
+func (ss *stratumSession) authorize(worker string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.worker = worker
+	ss.authorized = true
+}
+
+func (ss *stratumSession) send(msg interface{}) error {
+	ss.wmu.Lock()
+	defer ss.wmu.Unlock()
+	ss.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return ss.enc.Encode(msg)
+}
+
+func (ss *stratumSession) notify(method string, params ...interface{}) error {
+	return ss.send(stratumNotification{nil, method, params})
+}
+
+// queueJob makes w the next job pushed to the miner, replacing the one
+// it didn't get yet.
+func (ss *stratumSession) queueJob(w *spcommon.Work) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	select {
+	case <-ss.jobs:
+	default:
+	}
+	ss.jobs <- w
+}
+
+func NewStratumServer() *StratumServer {
+	return &StratumServer{
+		Port:         params.StratumPort,
+		addr:         fmt.Sprintf(":%d", params.StratumPort),
+		pow:          ethash.NewShared(),
+		loginTimeout: stratumLoginTimeout,
+		idleTimeout:  stratumIdleTimeout,
+		sessions:     map[*stratumSession]bool{},
+		extraNonces:  map[uint16]bool{},
+	}
+}
+
+func (s *StratumServer) Start() {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		fmt.Printf("Couldn't start stratum server: %s\n", err)
+		return
+	}
+	fmt.Printf("Stratum Server is running on port %d...\n", s.Port)
+	go s.refreshWork()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Printf("Stratum server couldn't accept connection: %s\n", err)
+			continue
+		}
+		ss, err := s.newSession(conn)
+		if err != nil {
+			fmt.Printf("Stratum server refused %s: %s\n", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		go s.handle(ss)
+	}
+}
+
+// newSession gives conn an extranonce no live session has. Extranonces
+// of closed sessions are given again once the others are used.
+func (s *StratumServer) newSession(conn net.Conn) (*stratumSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < 1<<(8*stratumExtraNonceSize); i++ {
+		id := s.nextExtraNonce
+		s.nextExtraNonce++
+		if s.extraNonces[id] {
+			continue
+		}
+		extraNonce := make([]byte, stratumExtraNonceSize)
+		extraNonce[0] = byte(id >> 8)
+		extraNonce[1] = byte(id)
+		ss := &stratumSession{
+			conn:         conn,
+			extraNonceID: id,
+			extraNonce:   hex.EncodeToString(extraNonce),
+			jobs:         make(chan *spcommon.Work, 1),
+			done:         make(chan struct{}),
+			enc:          json.NewEncoder(conn),
+		}
+		s.extraNonces[id] = true
+		s.sessions[ss] = true
+		return ss, nil
+	}
+	return nil, errNoExtraNonce
+}
+
+func (s *StratumServer) removeSession(ss *stratumSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, ss)
+	delete(s.extraNonces, ss.extraNonceID)
+	close(ss.done)
+	ss.conn.Close()
+}
+
+// refreshWork pushes every new work from the work feed to miners.
+func (s *StratumServer) refreshWork() {
+	works, _ := client.DefaultWorkFeed.Subscribe()
+	// works published from now on come from the subscription
+	if w, err := client.DefaultWorkFeed.Current(workTimeout); err == nil {
+		s.Notify(w)
+	} else {
+		fmt.Printf("Stratum server has no work yet: %s\n", err)
+	}
+	for w := range works {
+		s.Notify(w)
+	}
+}
+
+// Notify makes w the current job and queues it for every authorized
+// miner. It doesn't wait for the miners to get it.
+func (s *StratumServer) Notify(w *spcommon.Work) {
+	spcommon.WorkPool.AddWork(w)
+	s.mu.Lock()
+	s.work = w
+	sessions := []*stratumSession{}
+	for ss := range s.sessions {
+		sessions = append(sessions, ss)
+	}
+	s.mu.Unlock()
+	for _, ss := range sessions {
+		if ss.isAuthorized() {
+			ss.queueJob(w)
+		}
+	}
+}
+
+// pushJobs sends the queued jobs of ss from its own goroutine, so a
+// stalled miner only delays its own jobs.
+func (s *StratumServer) pushJobs(ss *stratumSession) {
+	for {
+		select {
+		case w := <-ss.jobs:
+			if err := s.sendJob(ss, w, true); err != nil {
+				// handle stops reading and removes the session
+				ss.conn.Close()
+				return
+			}
+		case <-ss.done:
+			return
+		}
+	}
+}
+
+func (s *StratumServer) currentWork() *spcommon.Work {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.work
+}
+
+// stratumDifficulty converts a share difficulty to the stratum one,
+// where difficulty 1 means 2^32 hashes.
+func stratumDifficulty(shareDifficulty *big.Int) float64 {
+	diff, _ := new(big.Float).SetInt(shareDifficulty).Float64()
+	return diff / float64(uint64(1)<<32)
+}
+
+func (s *StratumServer) sendJob(ss *stratumSession, w *spcommon.Work, clean bool) error {
+	difficulty := DefaultVardiff.Issue(ss.workerName(), w)
+	if err := ss.notify("mining.set_difficulty", stratumDifficulty(difficulty)); err != nil {
+		return err
+	}
+	return ss.notify("mining.notify",
+		hex.EncodeToString(w.PoWHash().Bytes()),
+		strings.TrimPrefix(w.SeedHash(), "0x"),
+		hex.EncodeToString(w.PoWHash().Bytes()),
+		clean,
+	)
+}
+
+// readTimeout returns how long ss may take to send its next request.
+func (s *StratumServer) readTimeout(ss *stratumSession) time.Duration {
+	if ss.isAuthorized() {
+		return s.idleTimeout
+	}
+	return s.loginTimeout
+}
+
+func (s *StratumServer) handle(ss *stratumSession) {
+	defer s.removeSession(ss)
+	go s.pushJobs(ss)
+	scanner := bufio.NewScanner(ss.conn)
+	scanner.Buffer(make([]byte, stratumMaxRequestSize), stratumMaxRequestSize)
+	for {
+		if timeout := s.readTimeout(ss); timeout > 0 {
+			ss.conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+		if !scanner.Scan() {
+			if err, ok := scanner.Err().(net.Error); ok && err.Timeout() {
+				if ss.isAuthorized() {
+					fmt.Printf("Stratum miner %s sent nothing for %s, closing\n", ss.workerName(), s.idleTimeout)
+				} else {
+					fmt.Printf("Stratum session %s didn't log in within %s, closing\n", ss.conn.RemoteAddr(), s.loginTimeout)
+				}
+			}
+			return
+		}
+		req := stratumRequest{}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			fmt.Printf("Malformed stratum request from %s: %s\n", ss.conn.RemoteAddr(), err)
+			return
+		}
+		result, err := s.dispatch(ss, req)
+		resp := stratumResponse{ID: req.ID, Result: result}
+		if err != nil {
+			resp.Result = nil
+			resp.Error = err.toJSON()
+		}
+		if err := ss.send(resp); err != nil {
+			return
+		}
+		if req.Method == "mining.authorize" && err == nil {
+			if w := s.currentWork(); w != nil {
+				ss.queueJob(w)
+			}
+		}
+	}
+}
+
+func (s *StratumServer) dispatch(ss *stratumSession, req stratumRequest) (interface{}, *stratumError) {
+	params := []string{}
+	if len(req.Params) > 0 {
+		// params of the methods we support are all strings
+		raw := []interface{}{}
+		if err := json.Unmarshal(req.Params, &raw); err != nil {
+			return nil, &stratumError{stratumErrOther, "Invalid params"}
+		}
+		for _, p := range raw {
+			str, _ := p.(string)
+			params = append(params, str)
+		}
+	}
+	switch req.Method {
+	case "mining.subscribe":
+		ss.mu.Lock()
+		ss.subscribed = true
+		ss.mu.Unlock()
+		return []interface{}{
+			[]string{"mining.notify", ss.extraNonce, stratumProtocol},
+			ss.extraNonce,
+		}, nil
+	case "mining.extranonce.subscribe":
+		return true, nil
+	case "mining.authorize":
+		if !ss.isSubscribed() {
+			return nil, &stratumError{stratumErrNotSubscribed, "Not subscribed"}
+		}
+		// the login names the worker
+		if len(params) < 1 || !validWorkerName(params[0]) {
+			return nil, &stratumError{stratumErrUnauthorized, "Unauthorized worker"}
+		}
+		ss.authorize(params[0])
+		return true, nil
+	case "mining.submit":
+		if !ss.isAuthorized() {
+			return nil, &stratumError{stratumErrUnauthorized, "Unauthorized worker"}
+		}
+		if len(params) < 3 {
+			return nil, &stratumError{stratumErrOther, "Invalid params"}
+		}
+		if err := s.submit(ss, params[1], params[2]); err != nil {
+			return nil, err
+		}
+		return true, nil
+	case "eth_submitHashrate":
+		if !ss.isAuthorized() {
+			return nil, &stratumError{stratumErrUnauthorized, "Unauthorized worker"}
+		}
+		if len(params) < 2 {
+			return nil, &stratumError{stratumErrOther, "Invalid params"}
+		}
+		hashrate, err := hexutil.DecodeUint64(params[0])
+		if err != nil {
+			return nil, &stratumError{stratumErrOther, "Invalid params"}
+		}
+		DefaultWorkerRegistry.RecordHashrate(ss.workerName(), hashrate, common.HexToHash(params[1]))
+		return true, nil
+	}
+	return nil, &stratumError{stratumErrOther, "Method not found"}
+}
+
+// submit checks a solution for the job. Miners only send their part of
+// the nonce, the session's extranonce is the rest of it.
+func (s *StratumServer) submit(ss *stratumSession, jobID string, minerNonce string) *stratumError {
+	nonceBytes, err := hex.DecodeString(ss.extraNonce + strings.TrimPrefix(minerNonce, "0x"))
+	if err != nil || len(nonceBytes) != len(types.BlockNonce{}) {
+		return &stratumError{stratumErrOther, "Malformed nonce"}
+	}
+	var nonce types.BlockNonce
+	copy(nonce[:], nonceBytes)
+	hash := common.HexToHash(jobID)
+	work := spcommon.WorkPool.GetWork(hash)
+	if work == nil {
+		DefaultWorkerRegistry.RecordShare(ss.workerName(), nil, errStaleShare)
+		return &stratumError{stratumErrJobNotFound, "Job not found"}
+	}
+	mixDigest, _, err := s.pow.Compute(work.BlockHeader().Number.Uint64(), hash, nonce.Uint64())
+	if err != nil {
+		return &stratumError{stratumErrOther, err.Error()}
+	}
+	switch err := acceptSolution(ss.workerName(), nonce, hash, mixDigest); err {
+	case nil:
+		return nil
+	case errStaleShare:
+		return &stratumError{stratumErrJobNotFound, "Job not found"}
+	case errDuplicateShare:
+		return &stratumError{stratumErrDuplicateShare, "Duplicate share"}
+	case errLowDifficultyShare:
+		return &stratumError{stratumErrLowDifficulty, "Low difficulty share"}
+	default:
+		return &stratumError{stratumErrOther, err.Error()}
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"../client"
+	"../ethash"
+	"bufio"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStratumExtraNonceReuse(t *testing.T) {
+	s := &StratumServer{
+		sessions:    map[*stratumSession]bool{},
+		extraNonces: map[uint16]bool{},
+	}
+	// every extranonce but the last two is taken
+	for id := 0; id < 1<<16-2; id++ {
+		s.extraNonces[uint16(id)] = true
+	}
+	conn, _ := net.Pipe()
+	first, err := s.newSession(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.newSession(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.extraNonce == second.extraNonce {
+		t.Fatalf("two sessions got extranonce %s", first.extraNonce)
+	}
+	if _, err := s.newSession(conn); err != errNoExtraNonce {
+		t.Fatalf("expected %v, got %v", errNoExtraNonce, err)
+	}
+	s.removeSession(first)
+	third, err := s.newSession(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.extraNonce != first.extraNonce {
+		t.Errorf("expected freed extranonce %s, got %s", first.extraNonce, third.extraNonce)
+	}
+}
+
+// stratumMessage is a response or a notification read by a test miner.
+type stratumMessage struct {
+	ID     *int          `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	Result interface{}   `json:"result"`
+	Error  []interface{} `json:"error"`
+}
+
+// errorCode returns the stratum error code of a response, 0 if none.
+func (m stratumMessage) errorCode() int {
+	if len(m.Error) == 0 {
+		return 0
+	}
+	code, _ := m.Error[0].(float64)
+	return int(code)
+}
+
+type stratumTestMiner struct {
+	t        *testing.T
+	conn     net.Conn
+	messages chan stratumMessage
+	nextID   int
+}
+
+// connectStratum starts a session of s on one end of a pipe and
+// returns a miner reading the other end.
+func connectStratum(t *testing.T, s *StratumServer) *stratumTestMiner {
+	serverConn, minerConn := net.Pipe()
+	ss, err := s.newSession(serverConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.handle(ss)
+	m := &stratumTestMiner{t: t, conn: minerConn, messages: make(chan stratumMessage, 16)}
+	go func() {
+		defer close(m.messages)
+		scanner := bufio.NewScanner(minerConn)
+		for scanner.Scan() {
+			msg := stratumMessage{}
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				t.Errorf("malformed message %s: %s", scanner.Text(), err)
+				return
+			}
+			m.messages <- msg
+		}
+	}()
+	return m
+}
+
+func (m *stratumTestMiner) next() stratumMessage {
+	select {
+	case msg, ok := <-m.messages:
+		if !ok {
+			m.t.Fatal("stratum server closed the session")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		m.t.Fatal("no message from the stratum server")
+	}
+	return stratumMessage{}
+}
+
+// call sends a request and returns its response, notifications sent
+// before it are returned too.
+func (m *stratumTestMiner) call(method string, params ...interface{}) (stratumMessage, []stratumMessage) {
+	m.nextID++
+	id := m.nextID
+	req, _ := json.Marshal(map[string]interface{}{"id": id, "method": method, "params": params})
+	if _, err := m.conn.Write(append(req, '\n')); err != nil {
+		m.t.Fatal(err)
+	}
+	notifications := []stratumMessage{}
+	for {
+		msg := m.next()
+		if msg.ID != nil && *msg.ID == id {
+			return msg, notifications
+		}
+		notifications = append(notifications, msg)
+	}
+}
+
+func testStratumServer() *StratumServer {
+	return &StratumServer{
+		pow:          ethash.NewShared(),
+		loginTimeout: 5 * time.Second,
+		idleTimeout:  5 * time.Second,
+		sessions:     map[*stratumSession]bool{},
+		extraNonces:  map[uint16]bool{},
+	}
+}
+
+func TestStratumSession(t *testing.T) {
+	saved := savePoolGlobals()
+	defer saved.restore()
+	setPoolParams(1)
+	defer startFakePool(t, newFakeEth(), &fakeClaimContract{})()
+	work, err := client.DefaultWorkFeed.Current(workTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testStratumServer()
+	s.Notify(work)
+	m := connectStratum(t, s)
+	defer m.conn.Close()
+
+	if resp, _ := m.call("mining.submit", "rig", "0x1", "000000000001"); resp.errorCode() != stratumErrUnauthorized {
+		t.Errorf("expected error %d submitting before authorize, got %v", stratumErrUnauthorized, resp.Error)
+	}
+	resp, _ := m.call("mining.subscribe", "miner/1.0", stratumProtocol)
+	result, _ := resp.Result.([]interface{})
+	if len(result) != 2 || result[1] == "" {
+		t.Fatalf("expected the subscription and an extranonce, got %v", resp.Result)
+	}
+	extraNonce := result[1].(string)
+	if resp, _ := m.call("mining.authorize", "rig", "x"); resp.Result != true {
+		t.Fatalf("expected the worker authorized, got %v", resp.Error)
+	}
+	// the current job follows the authorization
+	difficulty, job := m.next(), m.next()
+	if difficulty.Method != "mining.set_difficulty" || job.Method != "mining.notify" {
+		t.Fatalf("expected the difficulty and a job, got %s and %s", difficulty.Method, job.Method)
+	}
+	jobID, _ := job.Params[0].(string)
+	if common.HexToHash(jobID) != work.PoWHash() {
+		t.Errorf("expected job %s, got %s", work.PoWHash().Hex(), jobID)
+	}
+
+	resp, _ = m.call("mining.submit", "rig", jobID, "000000000001")
+	if resp.Result != true {
+		t.Fatalf("expected the share of nonce %s000000000001 accepted, got %v", extraNonce, resp.Error)
+	}
+	if resp, _ := m.call("mining.submit", "rig", jobID, "000000000001"); resp.errorCode() != stratumErrDuplicateShare {
+		t.Errorf("expected error %d for a duplicate share, got %v", stratumErrDuplicateShare, resp.Error)
+	}
+	if resp, _ := m.call("mining.submit", "rig", "0x1234", "000000000002"); resp.errorCode() != stratumErrJobNotFound {
+		t.Errorf("expected error %d for a stale job, got %v", stratumErrJobNotFound, resp.Error)
+	}
+	if info := DefaultWorkerRegistry.Worker("rig"); info == nil || info.Accepted != 1 {
+		t.Errorf("expected 1 share accepted from rig, got %v", info)
+	}
+}
+
+func TestStratumClosesIdleSessions(t *testing.T) {
+	s := testStratumServer()
+	s.loginTimeout = 50 * time.Millisecond
+	m := connectStratum(t, s)
+	defer m.conn.Close()
+	select {
+	case _, ok := <-m.messages:
+		if ok {
+			t.Fatal("expected no message before the session is closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session which didn't log in wasn't closed")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sessions) != 0 || len(s.extraNonces) != 0 {
+		t.Errorf("expected the session removed, got %d sessions", len(s.sessions))
+	}
+}
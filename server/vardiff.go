@@ -0,0 +1,172 @@
+package server
+
+import (
+	spcommon "../common"
+	"../params"
+	"github.com/ethereum/go-ethereum/common"
+	"math"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	// share difficulty of a worker is ShareDifficulty >> shift with
+	// shift in [0, vardiffMaxShift]. ShareDifficulty is the difficulty
+	// of the block extra data the contract credits shares with, so
+	// vardiff only lowers it for workers too slow to show their
+	// hashrate. Shares below ShareDifficulty are counted for the
+	// worker but not claimed, see checkSolution.
+	vardiffMaxShift = 20
+	// number of works a worker's difficulty is remembered for
+	vardiffIssuedWorks = 64
+)
+
+// vardiff lowers the share difficulty of a worker so it finds a share
+// every params.VardiffTargetTime on average.
+type vardiff struct {
+	shift        uint
+	shares       int
+	lastRetarget time.Time
+	// difficulty each work was given to the worker with
+	issued      map[common.Hash]uint
+	issuedOrder []common.Hash
+}
+
+func (v *vardiff) retarget(now time.Time) {
+	elapsed := now.Sub(v.lastRetarget)
+	if elapsed < params.VardiffRetargetTime {
+		return
+	}
+	expected := float64(elapsed) / float64(params.VardiffTargetTime)
+	ratio := float64(v.shares) / expected
+	step := 0
+	if ratio == 0 {
+		step = -1
+	} else if ratio >= 2 || ratio <= 0.5 {
+		step = int(math.Floor(math.Log2(ratio) + 0.5))
+	}
+	// a higher rate needs a higher difficulty, so a smaller shift
+	shift := int(v.shift) - step
+	if shift < 0 {
+		shift = 0
+	} else if shift > vardiffMaxShift {
+		shift = vardiffMaxShift
+	}
+	v.shift = uint(shift)
+	v.shares = 0
+	v.lastRetarget = now
+}
+
+func (v *vardiff) issue(hash common.Hash) uint {
+	if _, ok := v.issued[hash]; !ok {
+		if len(v.issuedOrder) == vardiffIssuedWorks {
+			delete(v.issued, v.issuedOrder[0])
+			v.issuedOrder = v.issuedOrder[1:]
+		}
+		v.issuedOrder = append(v.issuedOrder, hash)
+	}
+	v.issued[hash] = v.shift
+	return v.shift
+}
+
+// vardiffs keeps the vardiff state of named workers. It is safe for
+// concurrent use.
+type vardiffs struct {
+	mu      sync.Mutex
+	workers map[string]*vardiff
+	// when expired workers were last looked for
+	lastExpiry time.Time
+}
+
+var DefaultVardiff = newVardiffs()
+
+func newVardiffs() *vardiffs {
+	return &vardiffs{workers: map[string]*vardiff{}}
+}
+
+// get returns nil for workers which always get the base difficulty.
+// It must be called with vs.mu held.
+func (vs *vardiffs) get(worker string) *vardiff {
+	if params.VardiffTargetTime == 0 || worker == defaultWorker {
+		// unnamed miners can't be told apart
+		return nil
+	}
+	now := time.Now()
+	vs.expire(now)
+	v := vs.workers[worker]
+	if v == nil {
+		v = &vardiff{lastRetarget: now, issued: map[common.Hash]uint{}}
+		vs.workers[worker] = v
+	}
+	return v
+}
+
+// expire forgets the workers whose difficulty wasn't retargeted for
+// workerExpiry, retargets of a working miner are far more frequent.
+// It looks for them once an hour and must be called with vs.mu held.
+func (vs *vardiffs) expire(now time.Time) {
+	if now.Sub(vs.lastExpiry) < time.Hour {
+		return
+	}
+	vs.lastExpiry = now
+	for name, v := range vs.workers {
+		if now.Sub(v.lastRetarget) > workerExpiry {
+			delete(vs.workers, name)
+		}
+	}
+}
+
+// Issue returns the share difficulty w is given to worker with.
+func (vs *vardiffs) Issue(worker string, w *spcommon.Work) *big.Int {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	v := vs.get(worker)
+	if v == nil {
+		return w.ShareDifficulty()
+	}
+	v.retarget(time.Now())
+	return vardiffDifficulty(w.ShareDifficulty(), v.issue(w.PoWHash()))
+}
+
+// DifficultyOf returns the share difficulty w was given to worker
+// with. Works the worker didn't get from Issue have the base one.
+func (vs *vardiffs) DifficultyOf(worker string, w *spcommon.Work) *big.Int {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	v := vs.get(worker)
+	if v == nil {
+		return w.ShareDifficulty()
+	}
+	return vardiffDifficulty(w.ShareDifficulty(), v.issued[w.PoWHash()])
+}
+
+// Current returns the share difficulty the worker gets now, nil if it
+// is the base difficulty.
+func (vs *vardiffs) Current(worker string) *big.Int {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	v := vs.workers[worker]
+	if v == nil || params.ShareDifficulty == nil {
+		return nil
+	}
+	return vardiffDifficulty(params.ShareDifficulty, v.shift)
+}
+
+// vardiffDifficulty returns base lowered by shift, at least 1.
+func vardiffDifficulty(base *big.Int, shift uint) *big.Int {
+	d := new(big.Int).Rsh(base, shift)
+	if d.Sign() == 0 {
+		d.SetInt64(1)
+	}
+	return d
+}
+
+func (vs *vardiffs) ShareAccepted(worker string) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if v := vs.get(worker); v != nil {
+		v.shares++
+		v.retarget(time.Now())
+	}
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"math/big"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// window over which the effective hashrate of a worker is computed
+const effectiveHashrateWindow = 10 * time.Minute
+
+// shares of miners that didn't give a name are accounted to this worker
+const defaultWorker = "default"
+
+// workers that sent nothing for this long are forgotten, their
+// statistics start again if they come back
+const workerExpiry = 24 * time.Hour
+
+// worker names come from url paths and stratum logins
+var workerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+func validWorkerName(name string) bool {
+	return workerNamePattern.MatchString(name)
+}
+
+type acceptedShare struct {
+	time       time.Time
+	difficulty *big.Int
+}
+
+type reportedHashrate struct {
+	hashrate uint64
+	time     time.Time
+}
+
+type workerStats struct {
+	name      string
+	lastSeen  time.Time
+	accepted  uint64
+	stale     uint64
+	invalid   uint64
+	duplicate uint64
+	lastShare time.Time
+	// by hashrate id, miners sharing a worker name (e.g. the default
+	// worker) report their own hashrate
+	reported         map[common.Hash]reportedHashrate
+	firstRecentShare time.Time
+	recent           []acceptedShare
+	recentDifficulty *big.Int
+}
+
+// effectiveHashrate estimates the hashrate from the difficulty of the
+// shares accepted during the last effectiveHashrateWindow.
+func (ws *workerStats) effectiveHashrate(now time.Time) uint64 {
+	ws.prune(now)
+	if len(ws.recent) == 0 {
+		return 0
+	}
+	elapsed := now.Sub(ws.firstRecentShare)
+	if elapsed > effectiveHashrateWindow {
+		elapsed = effectiveHashrateWindow
+	}
+	if elapsed < time.Second {
+		elapsed = time.Second
+	}
+	rate := new(big.Int).Div(ws.recentDifficulty, big.NewInt(int64(elapsed/time.Second)))
+	return rate.Uint64()
+}
+
+func (ws *workerStats) prune(now time.Time) {
+	i := 0
+	for ; i < len(ws.recent) && now.Sub(ws.recent[i].time) > effectiveHashrateWindow; i++ {
+		ws.recentDifficulty.Sub(ws.recentDifficulty, ws.recent[i].difficulty)
+	}
+	ws.recent = ws.recent[i:]
+	if len(ws.recent) == 0 {
+		ws.firstRecentShare = time.Time{}
+	}
+}
+
+// WorkerInfo is the statistics of a worker as served over rpc.
+type WorkerInfo struct {
+	Name              string         `json:"name"`
+	HashrateID        *common.Hash   `json:"hashrateId,omitempty"`
+	Accepted          uint64         `json:"accepted"`
+	Stale             uint64         `json:"stale"`
+	Invalid           uint64         `json:"invalid"`
+	Duplicate         uint64         `json:"duplicate"`
+	LastShare         *time.Time     `json:"lastShare,omitempty"`
+	ReportedHashrate  hexutil.Uint64 `json:"reportedHashrate"`
+	EffectiveHashrate hexutil.Uint64 `json:"effectiveHashrate"`
+	ShareDifficulty   *hexutil.Big   `json:"shareDifficulty,omitempty"`
+}
+
+func (ws *workerStats) info(now time.Time) WorkerInfo {
+	result := WorkerInfo{
+		Name:              ws.name,
+		Accepted:          ws.accepted,
+		Stale:             ws.stale,
+		Invalid:           ws.invalid,
+		Duplicate:         ws.duplicate,
+		EffectiveHashrate: hexutil.Uint64(ws.effectiveHashrate(now)),
+	}
+	if !ws.lastShare.IsZero() {
+		lastShare := ws.lastShare
+		result.LastShare = &lastShare
+	}
+	for id, r := range ws.reported {
+		// a report that wasn't renewed for a whole window is outdated
+		if now.Sub(r.time) > effectiveHashrateWindow {
+			delete(ws.reported, id)
+			continue
+		}
+		result.ReportedHashrate += hexutil.Uint64(r.hashrate)
+	}
+	if len(ws.reported) == 1 {
+		for id := range ws.reported {
+			result.HashrateID = &id
+		}
+	}
+	return result
+}
+
+// workerRegistry keeps per worker share statistics. It is safe for
+// concurrent use.
+type workerRegistry struct {
+	mu      sync.Mutex
+	workers map[string]*workerStats
+	// when expired workers were last looked for
+	lastExpiry time.Time
+}
+
+var DefaultWorkerRegistry = newWorkerRegistry()
+
+func newWorkerRegistry() *workerRegistry {
+	return &workerRegistry{workers: map[string]*workerStats{}}
+}
+
+// get returns the statistics of a worker which just sent something.
+// It must be called with wr.mu held.
+func (wr *workerRegistry) get(name string) *workerStats {
+	now := time.Now()
+	wr.expire(now)
+	ws := wr.workers[name]
+	if ws == nil {
+		ws = &workerStats{
+			name:             name,
+			reported:         map[common.Hash]reportedHashrate{},
+			recentDifficulty: big.NewInt(0),
+		}
+		wr.workers[name] = ws
+	}
+	ws.lastSeen = now
+	return ws
+}
+
+// expire forgets the workers not seen for workerExpiry, it looks for
+// them once an hour. It must be called with wr.mu held.
+func (wr *workerRegistry) expire(now time.Time) {
+	if now.Sub(wr.lastExpiry) < time.Hour {
+		return
+	}
+	wr.lastExpiry = now
+	for name, ws := range wr.workers {
+		if now.Sub(ws.lastSeen) > workerExpiry {
+			delete(wr.workers, name)
+		}
+	}
+}
+
+// RecordShare counts a solution of worker. err is the result of
+// acceptSolution.
+func (wr *workerRegistry) RecordShare(worker string, difficulty *big.Int, err error) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	ws := wr.get(worker)
+	now := time.Now()
+	switch err {
+	case nil:
+		ws.accepted++
+		ws.lastShare = now
+		ws.prune(now)
+		if len(ws.recent) == 0 {
+			ws.firstRecentShare = now
+		}
+		ws.recent = append(ws.recent, acceptedShare{now, difficulty})
+		ws.recentDifficulty.Add(ws.recentDifficulty, difficulty)
+	case errStaleShare:
+		ws.stale++
+	case errDuplicateShare:
+		ws.duplicate++
+	case errLowDifficultyShare:
+		ws.invalid++
+	}
+}
+
+// RecordHashrate keeps the hashrate a miner of worker reported under
+// id. The reported hashrate of a worker is the sum over its miners, so
+// miners that didn't give a name are counted, like their shares, under
+// the default worker.
+func (wr *workerRegistry) RecordHashrate(worker string, hashrate uint64, id common.Hash) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.get(worker).reported[id] = reportedHashrate{hashrate, time.Now()}
+}
+
+func (wr *workerRegistry) Workers() []WorkerInfo {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	now := time.Now()
+	result := []WorkerInfo{}
+	for _, ws := range wr.workers {
+		result = append(result, ws.info(now))
+	}
+	sort.Sort(byName(result))
+	return result
+}
+
+type byName []WorkerInfo
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+func (wr *workerRegistry) Worker(name string) *WorkerInfo {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	ws := wr.workers[name]
+	if ws == nil {
+		return nil
+	}
+	result := ws.info(time.Now())
+	return &result
+}
+
+// WorkerService serves the worker statistics under the worker
+// namespace.
+type WorkerService struct{}
+
+func withDifficulty(info *WorkerInfo) {
+	if d := DefaultVardiff.Current(info.Name); d != nil {
+		info.ShareDifficulty = (*hexutil.Big)(d)
+	}
+}
+
+func (WorkerService) List() []WorkerInfo {
+	result := DefaultWorkerRegistry.Workers()
+	for i := range result {
+		withDifficulty(&result[i])
+	}
+	return result
+}
+
+func (WorkerService) Get(name string) *WorkerInfo {
+	result := DefaultWorkerRegistry.Worker(name)
+	if result != nil {
+		withDifficulty(result)
+	}
+	return result
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestUnnamedMinersShareTheDefaultWorker(t *testing.T) {
+	wr := newWorkerRegistry()
+	wr.RecordShare(defaultWorker, big.NewInt(100), nil)
+	wr.RecordHashrate(defaultWorker, 1000, common.HexToHash("0x1"))
+	wr.RecordHashrate(defaultWorker, 500, common.HexToHash("0x2"))
+	workers := wr.Workers()
+	if len(workers) != 1 {
+		t.Fatalf("expected only the default worker, got %v", workers)
+	}
+	info := workers[0]
+	if info.Name != defaultWorker || info.Accepted != 1 {
+		t.Errorf("expected 1 share of %s, got %d shares of %s", defaultWorker, info.Accepted, info.Name)
+	}
+	if info.ReportedHashrate != 1500 {
+		t.Errorf("expected the reported hashrates summed to 1500, got %d", info.ReportedHashrate)
+	}
+	if info.HashrateID != nil {
+		t.Errorf("expected no hashrate id for several miners, got %s", info.HashrateID.Hex())
+	}
+}
+
+func TestWorkerRegistryExpiresIdleWorkers(t *testing.T) {
+	wr := newWorkerRegistry()
+	wr.RecordShare("idle", big.NewInt(100), nil)
+	wr.RecordShare("busy", big.NewInt(100), nil)
+	wr.workers["idle"].lastSeen = time.Now().Add(-workerExpiry - time.Minute)
+	wr.lastExpiry = time.Time{}
+	wr.RecordShare("busy", big.NewInt(100), nil)
+	if wr.Worker("idle") != nil {
+		t.Error("expected the idle worker forgotten")
+	}
+	if info := wr.Worker("busy"); info == nil || info.Accepted != 2 {
+		t.Errorf("expected 2 shares of the busy worker, got %v", info)
+	}
+}
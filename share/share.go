@@ -9,6 +9,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/rlp"
+	"io"
 	"math/big"
 )
 
@@ -31,10 +32,19 @@ func (s Share) NonceBig() *big.Int {
 	return n
 }
 
+// ClaimDifficulty returns the difficulty the contract credits the
+// share with, the one of the extra data. Shares added to claims are
+// found at least at this difficulty.
+func (s Share) ClaimDifficulty() (*big.Int, error) {
+	return spcommon.ExtraDataDifficulty(s.blockHeader.Extra)
+}
+
 func (s *Share) AcceptSolution(nonce types.BlockNonce, mixDigest common.Hash) {
 	s.nonce = nonce
 	s.mixDigest = mixDigest
-	eth := ethash.New()
+	// the light cache of an epoch takes seconds to build, it is shared
+	// by every share
+	eth := ethash.NewShared()
 	s.SolutionState = eth.SolutionState(s, s.ShareDifficulty)
 }
 
@@ -104,6 +114,39 @@ func (s Share) PrintInfo() {
 	fmt.Printf("	RlpEncode: 0x%s\n", hex.EncodeToString(rlpEncoded))
 }
 
+// shareRLP is the on-disk representation of a share. Solution state
+// is stored unsigned because rlp doesn't support signed integers.
+type shareRLP struct {
+	BlockHeader     *types.Header
+	Nonce           types.BlockNonce
+	MixDigest       common.Hash
+	ShareDifficulty *big.Int
+	SolutionState   uint64
+}
+
+func (s *Share) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, shareRLP{
+		s.blockHeader,
+		s.nonce,
+		s.mixDigest,
+		s.ShareDifficulty,
+		uint64(s.SolutionState),
+	})
+}
+
+func (s *Share) DecodeRLP(st *rlp.Stream) error {
+	var dec shareRLP
+	if err := st.Decode(&dec); err != nil {
+		return err
+	}
+	s.blockHeader = dec.BlockHeader
+	s.nonce = dec.Nonce
+	s.mixDigest = dec.MixDigest
+	s.ShareDifficulty = dec.ShareDifficulty
+	s.SolutionState = int(dec.SolutionState)
+	return nil
+}
+
 func NewShare(h *types.Header, dif *big.Int) *Share {
 	return &Share{
 		h,
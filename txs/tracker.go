@@ -0,0 +1,191 @@
+package txs
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"sync"
+	"time"
+)
+
+// TxChange is what happened to a tracked transaction since it was last
+// checked.
+type TxChange int
+
+const (
+	// the transaction is at least the finality depth deep, it is not
+	// tracked anymore
+	TxFinal TxChange = iota
+	// the transaction was reorged into another block
+	TxMoved
+	// the block including the transaction was reorged out and the
+	// transaction is back in the pool of the node
+	TxPending
+	// the block including the transaction was reorged out and the node
+	// doesn't know the transaction anymore
+	TxDropped
+
+	txUnchanged TxChange = -1
+)
+
+var txChangeNames = map[TxChange]string{
+	TxFinal:   "final",
+	TxMoved:   "moved",
+	TxPending: "pending",
+	TxDropped: "dropped",
+}
+
+func (c TxChange) String() string {
+	if name, ok := txChangeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(c))
+}
+
+type trackedTx struct {
+	result   *TxResult
+	onChange func(TxChange, *TxResult)
+}
+
+// Tracker re-checks mined transactions against the canonical chain until
+// they are final. It is safe for concurrent use.
+type Tracker struct {
+	depth uint64
+
+	mu  sync.Mutex
+	txs map[common.Hash]*trackedTx
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewTracker considers transactions final once depth blocks, including
+// their own, are on top of the chain.
+func NewTracker(depth uint64) *Tracker {
+	return &Tracker{
+		depth: depth,
+		txs:   map[common.Hash]*trackedTx{},
+		stop:  make(chan struct{}),
+	}
+}
+
+// Track watches the transaction of result, which must be mined. onChange
+// is called by Check with the latest result whenever the transaction
+// changes. Final, pending and dropped transactions are not tracked
+// anymore, moved ones are. Tracking a transaction again replaces its
+// onChange.
+func (t *Tracker) Track(result *TxResult, onChange func(TxChange, *TxResult)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	copied := *result
+	t.txs[result.Hash] = &trackedTx{&copied, onChange}
+}
+
+func (t *Tracker) Untrack(h common.Hash) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.txs, h)
+}
+
+// Len returns the number of tracked transactions.
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.txs)
+}
+
+// Check checks every tracked transaction once. onChange is called
+// without holding the tracker so it can track and untrack transactions.
+// errors of the node are printed, the transaction is checked again next
+// time
+func (t *Tracker) Check() {
+	t.mu.Lock()
+	tracked := make([]*trackedTx, 0, len(t.txs))
+	lasts := make([]TxResult, 0, len(t.txs))
+	for _, tx := range t.txs {
+		tracked = append(tracked, tx)
+		lasts = append(lasts, *tx.result)
+	}
+	t.mu.Unlock()
+	for i, tx := range tracked {
+		last := lasts[i]
+		change, result, err := t.check(&last)
+		if err != nil {
+			fmt.Printf("Couldn't check transaction %s: %s\n", last.Hash.Hex(), err)
+			continue
+		}
+		t.mu.Lock()
+		// an earlier onChange of this round may have untracked it
+		current := t.txs[last.Hash] == tx
+		if current {
+			switch change {
+			case txUnchanged:
+				// results restored without their block hash adopt the
+				// first one seen
+				if tx.result.BlockHash == (common.Hash{}) {
+					tx.result.BlockHash = result.BlockHash
+				}
+			case TxMoved:
+				tx.result = result
+			default:
+				delete(t.txs, last.Hash)
+			}
+		}
+		t.mu.Unlock()
+		if current && change != txUnchanged {
+			tx.onChange(change, result)
+		}
+	}
+}
+
+// check compares the transaction with last, a copy of what was seen
+// last time. Pending and dropped transactions are reported with their
+// last result.
+func (t *Tracker) check(last *TxResult) (TxChange, *TxResult, error) {
+	result, err := checkTx(last.Hash)
+	if err != nil {
+		return txUnchanged, nil, err
+	}
+	if result == nil {
+		pending, err := source().IsPending(last.Hash)
+		if err != nil {
+			return txUnchanged, nil, err
+		}
+		if pending {
+			return TxPending, last, nil
+		}
+		return TxDropped, last, nil
+	}
+	if last.BlockHash != (common.Hash{}) && result.BlockHash != last.BlockHash {
+		return TxMoved, result, nil
+	}
+	if result.Confirmations >= t.depth {
+		return TxFinal, result, nil
+	}
+	return txUnchanged, result, nil
+}
+
+// Start checks the tracked transactions every interval until none is
+// left or the tracker is stopped.
+func (t *Tracker) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-t.stop:
+				return
+			}
+			t.Check()
+			if t.Len() == 0 {
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the checks started by Start. A check in progress still
+// completes.
+func (t *Tracker) Stop() {
+	t.stopOnce.Do(func() { close(t.stop) })
+}
@@ -0,0 +1,97 @@
+package txs
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"testing"
+)
+
+type trackerChange struct {
+	change TxChange
+	result *TxResult
+}
+
+// trackMined mines h in block and tracks it, changes get what the
+// tracker reports.
+func trackMined(chain *fakeChain, tracker *Tracker, h common.Hash, block uint64, changes map[common.Hash][]trackerChange) {
+	blockHash := common.HexToHash(fmt.Sprintf("0xb%d", block))
+	chain.set(h, &fakeTx{block: block, blockHash: blockHash, status: status(1)})
+	tracker.Track(&TxResult{Hash: h, BlockNumber: block, BlockHash: blockHash}, func(change TxChange, result *TxResult) {
+		changes[h] = append(changes[h], trackerChange{change, result})
+	})
+}
+
+func TestTrackerFollowsReorgs(t *testing.T) {
+	chain, restore := useFakeChain()
+	defer restore()
+	tracker := NewTracker(3)
+	changes := map[common.Hash][]trackerChange{}
+	moved, pending, dropped, final := common.HexToHash("0x1"), common.HexToHash("0x2"), common.HexToHash("0x3"), common.HexToHash("0x4")
+	chain.setHead(10)
+	for _, h := range []common.Hash{moved, pending, dropped, final} {
+		trackMined(chain, tracker, h, 10, changes)
+	}
+	tracker.Check()
+	if len(changes) != 0 || tracker.Len() != 4 {
+		t.Fatalf("expected 4 unchanged transactions, got %d tracked and changes %v", tracker.Len(), changes)
+	}
+
+	// a reorg replaces block 10
+	reorged := common.HexToHash("0xb2")
+	chain.set(moved, &fakeTx{block: 11, blockHash: reorged, status: status(1)})
+	chain.set(pending, &fakeTx{})
+	chain.set(dropped, nil)
+	chain.setHead(12)
+	tracker.Check()
+	expected := map[common.Hash]TxChange{moved: TxMoved, pending: TxPending, dropped: TxDropped, final: TxFinal}
+	for h, change := range expected {
+		if len(changes[h]) != 1 || changes[h][0].change != change {
+			t.Errorf("expected %s to be %s once, got %v", h.Hex(), change, changes[h])
+		}
+	}
+	if r := changes[moved][0].result; r.BlockNumber != 11 || r.BlockHash != reorged {
+		t.Errorf("expected the moved transaction in block 11, got %+v", r)
+	}
+	// pending and dropped transactions are reported with their last result
+	if r := changes[dropped][0].result; r.BlockNumber != 10 {
+		t.Errorf("expected the dropped transaction with its last block, got %+v", r)
+	}
+	if tracker.Len() != 1 {
+		t.Fatalf("expected only the moved transaction tracked, got %d", tracker.Len())
+	}
+
+	// the moved transaction is checked against its new block
+	tracker.Check()
+	if len(changes[moved]) != 1 {
+		t.Errorf("expected the moved transaction unchanged, got %v", changes[moved])
+	}
+	chain.setHead(13)
+	tracker.Check()
+	if len(changes[moved]) != 2 || changes[moved][1].change != TxFinal || tracker.Len() != 0 {
+		t.Errorf("expected the moved transaction final and untracked, got %v with %d tracked", changes[moved], tracker.Len())
+	}
+}
+
+// Results restored without a block hash take the first one seen, a
+// later reorg is then noticed.
+func TestTrackerAdoptsFirstBlockHash(t *testing.T) {
+	chain, restore := useFakeChain()
+	defer restore()
+	tracker := NewTracker(3)
+	h := common.HexToHash("0x1")
+	chain.setHead(10)
+	chain.set(h, &fakeTx{block: 10, blockHash: common.HexToHash("0xb1"), status: status(1)})
+	var changes []TxChange
+	tracker.Track(&TxResult{Hash: h, BlockNumber: 10}, func(change TxChange, result *TxResult) {
+		changes = append(changes, change)
+	})
+	tracker.Check()
+	if len(changes) != 0 {
+		t.Fatalf("expected no change, got %v", changes)
+	}
+	chain.set(h, &fakeTx{block: 10, blockHash: common.HexToHash("0xb2"), status: status(1)})
+	tracker.Check()
+	if len(changes) != 1 || changes[0] != TxMoved {
+		t.Errorf("expected the transaction moved, got %v", changes)
+	}
+}
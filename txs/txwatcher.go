@@ -2,39 +2,155 @@ package txs
 
 import (
 	"../client"
+	"../params"
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"time"
 )
 
-// transaction pool keeps track of pending transactions
-// and acknowledge corresponding channel when a transaction is
-// confirmed
+// how often Wait checks the receipt, a variable so tests don't wait
+var pollInterval = 1 * time.Second
+
+// txSource is the part of the node client transactions are checked
+// with.
+type txSource interface {
+	GetTransactionReceipt(h common.Hash) (*client.TxReceipt, error)
+	GetTransactionGas(h common.Hash) (uint64, error)
+	GetBlockNumber() (uint64, error)
+	IsPending(h common.Hash) (bool, error)
+}
+
+// source returns the node to check transactions with, tests replace it
+// with a fake chain.
+var source = func() txSource { return client.DefaultGethClient }
+
+type TxStatus int
+
+const (
+	TxSucceeded TxStatus = iota
+	TxReverted
+)
+
+func (s TxStatus) String() string {
+	if s == TxReverted {
+		return "reverted"
+	}
+	return "succeeded"
+}
+
+// TxResult is the outcome of a mined transaction.
+type TxResult struct {
+	Hash          common.Hash
+	BlockNumber   uint64
+	BlockHash     common.Hash
+	GasUsed       uint64
+	Status        TxStatus
+	Confirmations uint64
+}
+
+func (r *TxResult) Succeeded() bool {
+	return r.Status == TxSucceeded
+}
+
+// TxWatcher waits for a transaction to be mined and confirmed by a
+// number of blocks. It can watch several versions of a transaction,
+// e.g. one replaced with a higher gas price, the first one mined wins.
 type TxWatcher struct {
-	tx      *types.Transaction
-	verChan chan bool
+	txHashes      []common.Hash
+	confirmations uint64
 }
 
-func (tw *TxWatcher) isVerified() bool {
-	return client.DefaultGethClient.IsVerified(tw.tx.Hash())
+// WithConfirmations overrides params.TxConfirmations for this watcher.
+func (tw *TxWatcher) WithConfirmations(n uint64) *TxWatcher {
+	tw.confirmations = n
+	return tw
 }
 
-// loop to check transactions verification
-// if a transaction is verified, send it to verChan
-func (tw *TxWatcher) loop() {
+// Wait polls the receipt of the transaction until its block has enough
+// confirmations or ctx is done. When the block including the transaction
+// is reorged out the confirmations start over.
+// errors of the node are retried, it may come back or be switched
+func (tw *TxWatcher) Wait(ctx context.Context) (*TxResult, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
 	for {
-		if tw.isVerified() {
-			tw.verChan <- true
-			break
+		for _, h := range tw.txHashes {
+			result, err := checkTx(h)
+			if err != nil {
+				fmt.Printf("Couldn't check transaction %s: %s\n", h.Hex(), err)
+			} else if result != nil && result.Confirmations >= tw.confirmations {
+				return result, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("transaction %s: %s", tw.txHashes[len(tw.txHashes)-1].Hex(), ctx.Err())
+		case <-ticker.C:
 		}
-		time.Sleep(1 * time.Second)
 	}
 }
 
-func (tw *TxWatcher) Wait() {
-	go tw.loop()
-	<-tw.verChan
+// WaitFor is Wait with a timeout, 0 waits for ever.
+func (tw *TxWatcher) WaitFor(timeout time.Duration) (*TxResult, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return tw.Wait(ctx)
+}
+
+// checkTx returns nil while the transaction is not in a block.
+func checkTx(h common.Hash) (*TxResult, error) {
+	node := source()
+	receipt, err := node.GetTransactionReceipt(h)
+	if err != nil || receipt == nil {
+		return nil, err
+	}
+	head, err := node.GetBlockNumber()
+	if err != nil {
+		return nil, err
+	}
+	result := &TxResult{
+		Hash:        h,
+		BlockNumber: receipt.BlockNumber,
+		BlockHash:   receipt.BlockHash,
+		GasUsed:     receipt.GasUsed,
+	}
+	if head >= receipt.BlockNumber {
+		result.Confirmations = head - receipt.BlockNumber + 1
+	}
+	if receipt.Status != nil {
+		if *receipt.Status == 0 {
+			result.Status = TxReverted
+		}
+	} else {
+		// receipts before Byzantium have no status, a transaction
+		// that used all of its gas most likely threw
+		gas, err := node.GetTransactionGas(h)
+		if err != nil {
+			return nil, err
+		}
+		if receipt.GasUsed == gas {
+			result.Status = TxReverted
+		}
+	}
+	return result, nil
 }
 
 func NewTxWatcher(tx *types.Transaction) *TxWatcher {
-	return &TxWatcher{tx, make(chan bool)}
+	return NewTxWatcherByHash(tx.Hash())
+}
+
+// watch a transaction that was sent before, eg. by a previous run
+// of the client, or all the versions of a replaced one
+func NewTxWatcherByHash(hs ...common.Hash) *TxWatcher {
+	confirmations := params.TxConfirmations
+	if confirmations == 0 {
+		confirmations = 1
+	}
+	return &TxWatcher{hs, confirmations}
 }
@@ -0,0 +1,192 @@
+package txs
+
+import (
+	"../client"
+	"github.com/ethereum/go-ethereum/common"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTx is a transaction of fakeChain, pending while its block is 0.
+type fakeTx struct {
+	block     uint64
+	blockHash common.Hash
+	gas       uint64
+	gasUsed   uint64
+	status    *uint64
+}
+
+// fakeChain is a node whose head and transactions are set by the
+// tests.
+type fakeChain struct {
+	mu   sync.Mutex
+	head uint64
+	txs  map[common.Hash]*fakeTx
+}
+
+// useFakeChain makes the package check transactions against a new
+// fake chain, the returned function restores the node.
+func useFakeChain() (*fakeChain, func()) {
+	chain := &fakeChain{txs: map[common.Hash]*fakeTx{}}
+	savedSource, savedInterval := source, pollInterval
+	source = func() txSource { return chain }
+	pollInterval = 10 * time.Millisecond
+	return chain, func() {
+		source, pollInterval = savedSource, savedInterval
+	}
+}
+
+func (c *fakeChain) setHead(head uint64) {
+	c.mu.Lock()
+	c.head = head
+	c.mu.Unlock()
+}
+
+// set adds or replaces the transaction h, a nil tx removes it.
+func (c *fakeChain) set(h common.Hash, tx *fakeTx) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if tx == nil {
+		delete(c.txs, h)
+	} else {
+		c.txs[h] = tx
+	}
+}
+
+func (c *fakeChain) GetTransactionReceipt(h common.Hash) (*client.TxReceipt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tx := c.txs[h]
+	if tx == nil || tx.block == 0 {
+		return nil, nil
+	}
+	return &client.TxReceipt{BlockHash: tx.blockHash, BlockNumber: tx.block, GasUsed: tx.gasUsed, Status: tx.status}, nil
+}
+
+func (c *fakeChain) GetTransactionGas(h common.Hash) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.txs[h].gas, nil
+}
+
+func (c *fakeChain) GetBlockNumber() (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.head, nil
+}
+
+func (c *fakeChain) IsPending(h common.Hash) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tx := c.txs[h]
+	return tx != nil && tx.block == 0, nil
+}
+
+func status(s uint64) *uint64 { return &s }
+
+type waitResult struct {
+	result *TxResult
+	err    error
+}
+
+func waitInBackground(tw *TxWatcher, timeout time.Duration) <-chan waitResult {
+	done := make(chan waitResult, 1)
+	go func() {
+		result, err := tw.WaitFor(timeout)
+		done <- waitResult{result, err}
+	}()
+	return done
+}
+
+func TestTxWatcherWaitsForConfirmations(t *testing.T) {
+	chain, restore := useFakeChain()
+	defer restore()
+	h := common.HexToHash("0x1")
+	chain.setHead(10)
+	chain.set(h, &fakeTx{})
+	done := waitInBackground(NewTxWatcherByHash(h).WithConfirmations(3), 5*time.Second)
+
+	chain.set(h, &fakeTx{block: 10, blockHash: common.HexToHash("0xb1"), gas: 100000, gasUsed: 21000, status: status(1)})
+	chain.setHead(11)
+	select {
+	case r := <-done:
+		t.Fatalf("expected to wait for 3 confirmations, returned %+v, %v", r.result, r.err)
+	case <-time.After(10 * pollInterval):
+	}
+	chain.setHead(12)
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if r.result.Confirmations != 3 || r.result.BlockNumber != 10 || !r.result.Succeeded() {
+			t.Errorf("expected a succeeded result in block 10 with 3 confirmations, got %+v", r.result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not returned after 3 confirmations")
+	}
+}
+
+// The first mined version of a replaced transaction is returned.
+func TestTxWatcherWaitsForReplacedTransaction(t *testing.T) {
+	chain, restore := useFakeChain()
+	defer restore()
+	replaced, replacement := common.HexToHash("0x1"), common.HexToHash("0x2")
+	chain.setHead(10)
+	chain.set(replacement, &fakeTx{block: 10, blockHash: common.HexToHash("0xb1"), status: status(1)})
+	result, err := NewTxWatcherByHash(replaced, replacement).WithConfirmations(1).WaitFor(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Hash != replacement {
+		t.Errorf("expected the replacement %s, got %s", replacement.Hex(), result.Hash.Hex())
+	}
+}
+
+func TestTxWatcherTimeout(t *testing.T) {
+	chain, restore := useFakeChain()
+	defer restore()
+	h := common.HexToHash("0x1")
+	chain.set(h, &fakeTx{})
+	start := time.Now()
+	result, err := NewTxWatcherByHash(h).WaitFor(5 * pollInterval)
+	if err == nil {
+		t.Fatalf("expected a pending transaction to time out, got %+v", result)
+	}
+	if !strings.Contains(err.Error(), h.Hex()) || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("expected a timeout of %s, got %s", h.Hex(), err)
+	}
+	if elapsed := time.Since(start); elapsed < 5*pollInterval {
+		t.Errorf("returned after %s, before the timeout", elapsed)
+	}
+}
+
+func TestTxWatcherReportsRevertedReceipt(t *testing.T) {
+	tests := []struct {
+		tx       *fakeTx
+		expected TxStatus
+	}{
+		{&fakeTx{gas: 100000, gasUsed: 21000, status: status(1)}, TxSucceeded},
+		{&fakeTx{gas: 100000, gasUsed: 21000, status: status(0)}, TxReverted},
+		// before Byzantium a transaction using all of its gas threw
+		{&fakeTx{gas: 100000, gasUsed: 21000}, TxSucceeded},
+		{&fakeTx{gas: 100000, gasUsed: 100000}, TxReverted},
+	}
+	chain, restore := useFakeChain()
+	defer restore()
+	chain.setHead(10)
+	for i, test := range tests {
+		h := common.HexToHash("0x1")
+		test.tx.block, test.tx.blockHash = 10, common.HexToHash("0xb1")
+		chain.set(h, test.tx)
+		result, err := NewTxWatcherByHash(h).WithConfirmations(1).WaitFor(time.Second)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if result.Status != test.expected || result.GasUsed != test.tx.gasUsed {
+			t.Errorf("%d: expected %s using %d gas, got %s using %d", i, test.expected, test.tx.gasUsed, result.Status, result.GasUsed)
+		}
+	}
+}
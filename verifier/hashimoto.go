@@ -0,0 +1,52 @@
+package verifier
+
+import (
+	spcommon "../common"
+	"encoding/binary"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// dataset pages read by hashimoto
+	Accesses = 64
+	// 32 bit words in a 128 bytes page
+	mixWords = spcommon.WordLength / 4
+)
+
+func fnv(a, b uint32) uint32 {
+	return a*0x01000193 ^ b
+}
+
+// Hashimoto runs the ethash loop over a dataset of rows pages of 128
+// bytes. lookup returns the page at an index, it may fail to stop the
+// loop. It returns the indices of the pages read in order together with
+// the mix digest and the result compared to the target.
+func Hashimoto(headerHash common.Hash, nonce uint64, rows uint64, lookup func(i int, index uint32) (spcommon.Word, error)) (indices []uint32, mixDigest, result common.Hash, err error) {
+	nonceBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(nonceBytes, nonce)
+	seed := crypto.Keccak512(headerHash[:], nonceBytes)
+	seedHead := binary.LittleEndian.Uint32(seed)
+
+	mix := make([]uint32, mixWords)
+	for i := range mix {
+		mix[i] = binary.LittleEndian.Uint32(seed[i%16*4:])
+	}
+	indices = make([]uint32, Accesses)
+	for i := 0; i < Accesses; i++ {
+		indices[i] = uint32(uint64(fnv(uint32(i)^seedHead, mix[i%mixWords])) % rows)
+		page, err := lookup(i, indices[i])
+		if err != nil {
+			return nil, common.Hash{}, common.Hash{}, err
+		}
+		for j := range mix {
+			mix[j] = fnv(mix[j], binary.LittleEndian.Uint32(page[j*4:]))
+		}
+	}
+	for i := 0; i < mixWords; i += 4 {
+		reduced := fnv(fnv(fnv(mix[i], mix[i+1]), mix[i+2]), mix[i+3])
+		binary.LittleEndian.PutUint32(mixDigest[i:], reduced)
+	}
+	copy(result[:], crypto.Keccak256(seed, mixDigest[:]))
+	return indices, mixDigest, result, nil
+}
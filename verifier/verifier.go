@@ -0,0 +1,212 @@
+// Package verifier checks claim proofs the way the pool contract does in
+// VerifyClaim, so the client can find a bad proof before paying gas for
+// it.
+package verifier
+
+import (
+	spcommon "../common"
+	"../mtree"
+	"../params"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"math/big"
+)
+
+const epochLength = 30000
+
+var maxUint256 = new(big.Int).Exp(big.NewInt(2), big.NewInt(256), nil)
+
+// Epoch is the DAG data the contract was given with SetEpochData.
+type Epoch struct {
+	Number                  uint64
+	MerkleRoot              *big.Int
+	FullSizeIn128Resolution uint64
+	BranchDepth             uint64
+}
+
+// SubmittedClaim is what the contract recorded with SubmitClaim.
+type SubmittedClaim struct {
+	NumShares  *big.Int
+	Difficulty *big.Int
+	Min        *big.Int
+	Max        *big.Int
+	AugRoot    *big.Int
+}
+
+// Proof holds the arguments of the contract's VerifyClaim.
+type Proof struct {
+	RlpHeader         []byte
+	Nonce             *big.Int
+	ShareIndex        *big.Int
+	DataSetLookup     []*big.Int
+	WitnessForLookup  []*big.Int
+	AugCountersBranch []*big.Int
+	AugHashesBranch   []*big.Int
+}
+
+// VerificationError tells which check a proof fails.
+type VerificationError struct {
+	Check  string
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("proof fails %s check: %s", e.Check, e.Reason)
+}
+
+func fail(check string, format string, args ...interface{}) error {
+	return &VerificationError{check, fmt.Sprintf(format, args...)}
+}
+
+// headerWithoutNonce is the header the share is proved for, without
+// the mix digest and nonce the miner found.
+type headerWithoutNonce struct {
+	ParentHash  common.Hash
+	UncleHash   common.Hash
+	Coinbase    common.Address
+	Root        common.Hash
+	TxHash      common.Hash
+	ReceiptHash common.Hash
+	Bloom       types.Bloom
+	Difficulty  *big.Int
+	Number      *big.Int
+	GasLimit    *big.Int
+	GasUsed     *big.Int
+	Time        *big.Int
+	Extra       []byte
+}
+
+// Verify checks the proof against the claim the miner submitted and the
+// epoch of its share. Like the contract's VerifyExtraData it checks
+// that the extra data of the header was built for the claim difficulty,
+// the miner id in it is left to the contract.
+func (p *Proof) Verify(claim SubmittedClaim, epoch Epoch) error {
+	header := headerWithoutNonce{}
+	if err := rlp.DecodeBytes(p.RlpHeader, &header); err != nil {
+		return fail("header", "%s", err)
+	}
+	if header.Coinbase != common.HexToAddress(params.ContractAddress) {
+		return fail("header", "coinbase %s is not the pool contract", header.Coinbase.Hex())
+	}
+	if header.Number.Uint64()/epochLength != epoch.Number {
+		return fail("epoch", "block %d is not in epoch %d", header.Number, epoch.Number)
+	}
+	if p.Nonce == nil || p.Nonce.Sign() < 0 || p.Nonce.BitLen() > 64 {
+		return fail("nonce", "%v is not a 64 bits nonce", p.Nonce)
+	}
+	if d, err := spcommon.ExtraDataDifficulty(header.Extra); err != nil {
+		return fail("extra data", "%s", err)
+	} else if claim.Difficulty == nil || d.Cmp(claim.Difficulty) != 0 {
+		return fail("extra data", "built for difficulty %v, the claim has %v", d, claim.Difficulty)
+	}
+	headerHash := crypto.Keccak256Hash(p.RlpHeader)
+	if err := p.verifyAugBranch(headerHash, header.Time, claim); err != nil {
+		return err
+	}
+	return p.verifyWork(headerHash, claim.Difficulty, epoch)
+}
+
+// counter is the share counter the augmented merkle tree is ordered by,
+// the timestamp followed by the nonce.
+func counter(timestamp, nonce *big.Int) *big.Int {
+	c := new(big.Int).Lsh(timestamp, 64)
+	return c.Add(c, nonce)
+}
+
+// low16 returns the lower 16 bytes of a 32 bytes word, the contract
+// keeps hashes in them.
+func low16(v *big.Int) spcommon.SPHash {
+	h := spcommon.SPHash{}
+	b := common.LeftPadBytes(v.Bytes(), 32)
+	copy(h[:], b[16:])
+	return h
+}
+
+// depth returns the number of levels of a tree of n leaves.
+func depth(n uint64) int {
+	if n <= 1 {
+		return 0
+	}
+	return len(fmt.Sprintf("%b", n-1))
+}
+
+// verifyAugBranch checks that the share is the leaf at ShareIndex of the
+// augmented merkle tree of the claim. Counters must increase from left
+// to right at every level.
+func (p *Proof) verifyAugBranch(headerHash common.Hash, timestamp *big.Int, claim SubmittedClaim) error {
+	if p.ShareIndex == nil || p.ShareIndex.Sign() < 0 || p.ShareIndex.Cmp(claim.NumShares) >= 0 {
+		return fail("share index", "%v is not below the %v shares of the claim", p.ShareIndex, claim.NumShares)
+	}
+	if d := depth(claim.NumShares.Uint64()); len(p.AugHashesBranch) != d {
+		return fail("augmented branch", "%d levels, a claim of %v shares has %d", len(p.AugHashesBranch), claim.NumShares, d)
+	}
+	c := counter(timestamp, p.Nonce)
+	leaf := mtree.AugData{Min: c, Max: c}
+	copy(leaf.Hash[:], headerHash[16:])
+	root := mtree.AugData{Min: claim.Min, Max: claim.Max, Hash: low16(claim.AugRoot)}
+	if err := mtree.VerifyAugLeaf(leaf, uint32(p.ShareIndex.Uint64()), p.AugCountersBranch, p.AugHashesBranch, root); err != nil {
+		return fail("augmented branch", "%s", err)
+	}
+	return nil
+}
+
+// lookupWord rebuilds a dataset page from the four words the contract
+// reads little endian.
+func lookupWord(values []*big.Int) (spcommon.Word, error) {
+	w := spcommon.Word{}
+	for i, v := range values {
+		if v == nil || v.Sign() < 0 || v.BitLen() > 256 {
+			return w, fmt.Errorf("word %d is not a uint256", i)
+		}
+		b := common.LeftPadBytes(v.Bytes(), 32)
+		for j := 0; j < 32; j++ {
+			w[i*32+j] = b[31-j]
+		}
+	}
+	return w, nil
+}
+
+// verifyWork runs hashimoto on the dataset pages of the proof, checking
+// each of them against the epoch, and compares the result with the
+// claim difficulty.
+func (p *Proof) verifyWork(headerHash common.Hash, difficulty *big.Int, epoch Epoch) error {
+	if epoch.FullSizeIn128Resolution == 0 {
+		return fail("epoch", "epoch %d has no dataset", epoch.Number)
+	}
+	if d := depth(epoch.FullSizeIn128Resolution); uint64(d) != epoch.BranchDepth {
+		return fail("epoch", "branch depth %d doesn't match %d dataset pages", epoch.BranchDepth, epoch.FullSizeIn128Resolution)
+	}
+	if len(p.DataSetLookup) != Accesses*4 {
+		return fail("dataset lookup", "%d words, expected %d", len(p.DataSetLookup), Accesses*4)
+	}
+	witnessLength := int(epoch.BranchDepth+1) / 2
+	if len(p.WitnessForLookup) != Accesses*witnessLength {
+		return fail("dataset witness", "%d words, expected %d", len(p.WitnessForLookup), Accesses*witnessLength)
+	}
+	_, _, result, err := Hashimoto(headerHash, p.Nonce.Uint64(), epoch.FullSizeIn128Resolution, func(i int, index uint32) (spcommon.Word, error) {
+		values := p.DataSetLookup[i*4 : (i+1)*4]
+		w, err := lookupWord(values)
+		if err != nil {
+			return w, fail("dataset lookup", "page %d: %s", i, err)
+		}
+		witness := p.WitnessForLookup[i*witnessLength : (i+1)*witnessLength]
+		if err := verifyDagBranch(values, index, witness, epoch); err != nil {
+			return w, fail("dataset witness", "page %d at index %d: %s", i, index, err)
+		}
+		return w, nil
+	})
+	if err != nil {
+		return err
+	}
+	if difficulty == nil || difficulty.Sign() <= 0 {
+		return fail("difficulty", "claim difficulty %v is not positive", difficulty)
+	}
+	target := new(big.Int).Div(maxUint256, difficulty)
+	if result.Big().Cmp(target) > 0 {
+		return fail("difficulty", "result %s is above the target of difficulty %v", result.Hex(), difficulty)
+	}
+	return nil
+}
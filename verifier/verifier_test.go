@@ -0,0 +1,140 @@
+package verifier
+
+import (
+	spcommon "../common"
+	"../mtree"
+	"../params"
+	"../share"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+	"testing"
+)
+
+const testRows = 100
+
+func testDataset() []spcommon.Word {
+	dataset := make([]spcommon.Word, testRows)
+	for i := range dataset {
+		for j := 0; j < spcommon.WordLength; j += 32 {
+			copy(dataset[i][j:], crypto.Keccak256([]byte(fmt.Sprintf("%d-%d", i, j))))
+		}
+	}
+	return dataset
+}
+
+// testShares are found at difficulties up to 8 as vardiff gives them,
+// with extra data for the base difficulty 1.
+func testShares() []*share.Share {
+	shares := []*share.Share{}
+	for i := 0; i < 5; i++ {
+		h := &types.Header{
+			Coinbase:   common.HexToAddress(params.ContractAddress),
+			Difficulty: big.NewInt(1000000),
+			Number:     big.NewInt(1),
+			GasLimit:   big.NewInt(4000000),
+			GasUsed:    big.NewInt(0),
+			Time:       big.NewInt(int64(1000 + i)),
+			Extra:      []byte(spcommon.ExtraData(common.Address{}, big.NewInt(1))),
+		}
+		shares = append(shares, share.NewShare(h, big.NewInt(int64(1)<<uint(i%4))))
+	}
+	return shares
+}
+
+// testProof builds a proof the way the claim does, over a dataset small
+// enough to keep in memory.
+func testProof(t *testing.T, index int) (*Proof, SubmittedClaim, Epoch) {
+	params.ContractAddress = "0x9e7a1925fa43d5f47b36e2e27f84adae95ddd845"
+	dataset := testDataset()
+	shares := testShares()
+	amt := mtree.NewAugTree()
+	amt.RegisterIndex(uint32(index))
+	for i, s := range shares {
+		amt.Insert(*s, uint32(i))
+	}
+	amt.Finalize()
+	s := shares[index]
+	rlpHeader, err := s.RlpHeaderWithoutNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	indices, _, _, err := Hashimoto(s.HashNoNonce(), s.Nonce(), testRows, func(i int, index uint32) (spcommon.Word, error) {
+		return dataset[index], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mt := mtree.NewDagTree()
+	mt.RegisterIndex(indices...)
+	for i, w := range dataset {
+		mt.Insert(w, uint32(i))
+	}
+	mt.Finalize()
+	sproof := share.ShareProof{mt.AllDAGElements(), mt.AllBranchesArray()}
+	proof := &Proof{
+		RlpHeader:         rlpHeader,
+		Nonce:             s.NonceBig(),
+		ShareIndex:        big.NewInt(int64(index)),
+		DataSetLookup:     sproof.DAGElementArray(),
+		WitnessForLookup:  sproof.DAGProofArray(),
+		AugCountersBranch: amt.CounterBranchArray(),
+		AugHashesBranch:   amt.HashBranchArray(),
+	}
+	claim := SubmittedClaim{
+		big.NewInt(int64(len(shares))),
+		big.NewInt(1),
+		amt.RootMin(),
+		amt.RootMax(),
+		amt.RootHash().Big(),
+	}
+	epoch := Epoch{0, mt.RootHash().Big(), testRows, uint64(depth(testRows))}
+	return proof, claim, epoch
+}
+
+func expectCheck(t *testing.T, err error, check string) {
+	verr, ok := err.(*VerificationError)
+	if !ok {
+		t.Errorf("expected %s check to fail, got %v", check, err)
+	} else if verr.Check != check {
+		t.Errorf("expected %s check to fail, got %s", check, verr)
+	}
+}
+
+func TestVerifyProof(t *testing.T) {
+	for index := 0; index < 5; index++ {
+		proof, claim, epoch := testProof(t, index)
+		if err := proof.Verify(claim, epoch); err != nil {
+			t.Errorf("share %d: %s", index, err)
+		}
+	}
+}
+
+func TestVerifyTamperedProof(t *testing.T) {
+	proof, claim, epoch := testProof(t, 3)
+	proof.DataSetLookup[5] = new(big.Int).Add(proof.DataSetLookup[5], big.NewInt(1))
+	expectCheck(t, proof.Verify(claim, epoch), "dataset witness")
+
+	proof, claim, epoch = testProof(t, 3)
+	proof.AugHashesBranch[1] = big.NewInt(1)
+	expectCheck(t, proof.Verify(claim, epoch), "augmented branch")
+
+	proof, claim, epoch = testProof(t, 3)
+	proof.ShareIndex = big.NewInt(2)
+	expectCheck(t, proof.Verify(claim, epoch), "augmented branch")
+
+	proof, claim, epoch = testProof(t, 3)
+	headerHash := crypto.Keccak256Hash(proof.RlpHeader)
+	expectCheck(t, proof.verifyWork(headerHash, new(big.Int).Set(maxUint256), epoch), "difficulty")
+}
+
+// The contract credits shares found at a vardiff difficulty with the
+// difficulty of their extra data, a claim submitted with the difficulty
+// the miner was given is refused.
+func TestVerifyExtraDataDifficulty(t *testing.T) {
+	proof, claim, epoch := testProof(t, 3)
+	claim.Difficulty = testShares()[3].ShareDifficulty
+	expectCheck(t, proof.Verify(claim, epoch), "extra data")
+}